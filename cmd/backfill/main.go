@@ -0,0 +1,97 @@
+// Record opening balances for portfolios set up before trades were recorded
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/env"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/portfolio"
+)
+
+// userPortfolio is a portfolio to record opening balances for.
+type userPortfolio struct {
+	user        model.User
+	portfolioID int64
+}
+
+func scanUserPortfolio(row database.Row, userPortfolio *userPortfolio) error {
+	return row.Scan(&userPortfolio.user.ID, &userPortfolio.user.Username, &userPortfolio.portfolioID)
+}
+
+func main() {
+	dateFlag := flag.String(
+		"date",
+		"",
+		"date the opening balances were acquired, as YYYY-MM-DD (default: when they were first recorded)",
+	)
+	flag.Parse()
+
+	var openedAt *time.Time
+
+	if *dateFlag != "" {
+		date, err := time.Parse(time.DateOnly, *dateFlag)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid date: %s\n", *dateFlag)
+			os.Exit(1)
+		}
+
+		openedAt = &date
+	}
+
+	env.LoadEnvironmentVariables()
+
+	conn, err := database.Connect()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Connection error: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer conn.Close()
+
+	var portfolioList []userPortfolio
+
+	err = model.LoadList(
+		conn,
+		&portfolioList,
+		10,
+		scanUserPortfolio,
+		`select user_id, username, portfolio_id
+		from crypto_portfolio
+		order by updated_at desc
+		limit 1 by user_id, portfolio_id`,
+	)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "SQL error: %s\n", err)
+		os.Exit(1)
+	}
+
+	failed := false
+
+	// Keep recording opening balances for other portfolios if one fails.
+	for _, userPortfolio := range portfolioList {
+		err := portfolio.BackfillOpeningBalances(conn, &userPortfolio.user, userPortfolio.portfolioID, openedAt)
+
+		if err != nil {
+			fmt.Fprintf(
+				os.Stderr,
+				"Backfill error for user %d, portfolio %d: %s\n",
+				userPortfolio.user.ID,
+				userPortfolio.portfolioID,
+				err,
+			)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
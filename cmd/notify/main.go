@@ -13,8 +13,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dense-analysis/pricewarp/internal/backtest"
 	"github.com/dense-analysis/pricewarp/internal/database"
 	"github.com/dense-analysis/pricewarp/internal/env"
+	"github.com/dense-analysis/pricewarp/internal/indicator"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/query"
 	"github.com/shopspring/decimal"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -33,6 +37,16 @@ type CryptoAlert struct {
 	Above            bool
 	Value            decimal.Decimal
 	AlertTime        time.Time
+	ActiveFrom       *time.Time
+	ExpiresAt        *time.Time
+	Note             string
+	Labels           []string
+	LadderID         int64
+	Indicator        string
+	Period           int
+	Rule             string
+	Recurring        bool
+	Upper            decimal.Decimal
 }
 
 const (
@@ -54,7 +68,17 @@ func findAlertsToTrigger(conn *database.Conn) ([]*CryptoAlert, error) {
 				alerts.to_currency_ticker,
 				alerts.above,
 				alerts.value,
-				alerts.alert_time
+				alerts.alert_time,
+				alerts.active_from,
+				alerts.expires_at,
+				alerts.note,
+				alerts.labels,
+				alerts.ladder_id,
+				alerts.indicator,
+				alerts.period,
+				alerts.rule,
+				alerts.recurring,
+				alerts.upper
 			FROM (
 				SELECT *
 				FROM (
@@ -65,6 +89,11 @@ func findAlertsToTrigger(conn *database.Conn) ([]*CryptoAlert, error) {
 				)
 				-- Predicate pushdown: filter after fetching latest.
 				WHERE is_deleted = 0 AND sent = 0
+				-- Indicator, percent and trailing alerts are checked separately.
+				AND indicator = '' AND rule = ''
+				-- Skip alerts outside of their active window.
+				AND (active_from IS NULL OR active_from <= now64(9))
+				AND (expires_at IS NULL OR expires_at > now64(9))
 			) AS alerts
 			-- Get the latest values
 			INNER JOIN (
@@ -88,6 +117,9 @@ func findAlertsToTrigger(conn *database.Conn) ([]*CryptoAlert, error) {
 					)
 					-- Predicate pushdown: filter after fetching latest.
 					WHERE is_deleted = 0 AND sent = 0
+					AND indicator = '' AND rule = ''
+					AND (active_from IS NULL OR active_from <= now64(9))
+					AND (expires_at IS NULL OR expires_at > now64(9))
 				)
 				GROUP BY from_currency_ticker, to_currency_ticker
 			) AS prices
@@ -98,6 +130,8 @@ func findAlertsToTrigger(conn *database.Conn) ([]*CryptoAlert, error) {
 				OR (alerts.above = 0 AND prices.value <= alerts.value)
 			)
 			AND prices.latest_time >= alerts.alert_time
+			-- Prices from before the window opened can't trigger the alert.
+			AND (alerts.active_from IS NULL OR prices.latest_time >= alerts.active_from)
 		`,
 	)
 
@@ -110,25 +144,384 @@ func findAlertsToTrigger(conn *database.Conn) ([]*CryptoAlert, error) {
 
 	for rows.Next() {
 		alert := &CryptoAlert{}
-		var above uint8
-		var value decimal.Decimal
-
-		if err := rows.Scan(
-			&alert.Id,
-			&alert.UserID,
-			&alert.Email,
-			&alert.FromCurrencyName,
-			&alert.FromCurrencyTick,
-			&alert.ToCurrencyName,
-			&alert.ToCurrencyTick,
-			&above,
-			&value,
-			&alert.AlertTime,
-		); err != nil {
+
+		if err := scanCryptoAlert(rows, alert); err != nil {
+			return nil, err
+		}
+
+		alertList = append(alertList, alert)
+	}
+
+	return alertList, rows.Err()
+}
+
+func scanCryptoAlert(row database.Row, alert *CryptoAlert) error {
+	var above uint8
+	var value decimal.Decimal
+	var period uint16
+	var recurring uint8
+
+	if err := row.Scan(
+		&alert.Id,
+		&alert.UserID,
+		&alert.Email,
+		&alert.FromCurrencyName,
+		&alert.FromCurrencyTick,
+		&alert.ToCurrencyName,
+		&alert.ToCurrencyTick,
+		&above,
+		&value,
+		&alert.AlertTime,
+		&alert.ActiveFrom,
+		&alert.ExpiresAt,
+		&alert.Note,
+		&alert.Labels,
+		&alert.LadderID,
+		&alert.Indicator,
+		&period,
+		&alert.Rule,
+		&recurring,
+		&alert.Upper,
+	); err != nil {
+		return err
+	}
+
+	alert.Above = above == 1
+	alert.Value = value
+	alert.Period = int(period)
+	alert.Recurring = recurring == 1
+
+	return nil
+}
+
+// armedSince returns when an alert was armed, which is when it was created or
+// last sent, or when it became active if that's later.
+func armedSince(alert *CryptoAlert) time.Time {
+	if alert.ActiveFrom != nil && alert.ActiveFrom.After(alert.AlertTime) {
+		return *alert.ActiveFrom
+	}
+
+	return alert.AlertTime
+}
+
+// closeInterval is the interval of the closing prices indicators use.
+const closeInterval = 24 * time.Hour
+
+// crossedSince returns true if the latest closing price crossed the indicator
+// for an alert, and the crossing happened after `since`.
+//
+// A crossing happens between two closes, so the close before the crossing
+// must have been taken after `since`. Crossings from before an alert was
+// created or became active can't trigger it.
+func crossedSince(alert *CryptoAlert, priceList []model.Price, closeList []decimal.Decimal, since time.Time) bool {
+	if len(priceList) < 2 {
+		return false
+	}
+
+	previousCloseEnd := priceList[len(priceList)-2].Time.Add(closeInterval)
+
+	if !previousCloseEnd.After(since) {
+		return false
+	}
+
+	if alert.Indicator == indicator.RSI && alert.Upper.IsPositive() {
+		return indicator.LeftBand(alert.Period, alert.Value, alert.Upper, closeList)
+	}
+
+	return indicator.Crossed(alert.Indicator, alert.Period, alert.Above, alert.Value, closeList)
+}
+
+// findIndicatorAlertsToTrigger finds indicator alerts where the latest price
+// crossed a moving average, or where the RSI crossed the alert value or left
+// the band of the alert.
+//
+// Indicators are computed in Go over daily closing prices, loaded once for
+// each pair.
+func findIndicatorAlertsToTrigger(conn *database.Conn) ([]*CryptoAlert, error) {
+	rows, err := conn.Query(
+		`
+			SELECT
+				alert_id,
+				user_id,
+				username,
+				from_currency_name,
+				from_currency_ticker,
+				to_currency_name,
+				to_currency_ticker,
+				above,
+				value,
+				alert_time,
+				active_from,
+				expires_at,
+				note,
+				labels,
+				ladder_id,
+				indicator,
+				period,
+				rule,
+				recurring,
+				upper
+			FROM (
+				SELECT *
+				FROM crypto_alert
+				ORDER BY updated_at DESC
+				LIMIT 1 BY alert_id
+			)
+			-- Predicate pushdown: filter after fetching latest.
+			WHERE is_deleted = 0 AND sent = 0
+			AND indicator != ''
+			AND (active_from IS NULL OR active_from <= now64(9))
+			AND (expires_at IS NULL OR expires_at > now64(9))
+		`,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairAlerts := map[[2]string][]*CryptoAlert{}
+	pairLookback := map[[2]string]int{}
+
+	for rows.Next() {
+		alert := &CryptoAlert{}
+
+		if err := scanCryptoAlert(rows, alert); err != nil {
+			return nil, err
+		}
+
+		pair := [2]string{alert.FromCurrencyTick, alert.ToCurrencyTick}
+		pairAlerts[pair] = append(pairAlerts[pair], alert)
+		pairLookback[pair] = max(pairLookback[pair], indicator.Lookback(alert.Indicator, alert.Period))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var alertList []*CryptoAlert
+	end := time.Now().UTC()
+
+	for pair, groupedList := range pairAlerts {
+		var priceList []model.Price
+		start := end.AddDate(0, 0, -pairLookback[pair])
+
+		if err := query.LoadClosingPrices(conn, &priceList, pair[0], pair[1], start, end, closeInterval); err != nil {
+			return nil, err
+		}
+
+		closeList := make([]decimal.Decimal, len(priceList))
+
+		for i, price := range priceList {
+			closeList[i] = price.Value
+		}
+
+		for _, alert := range groupedList {
+			if crossedSince(alert, priceList, closeList, armedSince(alert)) {
+				alertList = append(alertList, alert)
+			}
+		}
+	}
+
+	return alertList, nil
+}
+
+// findRuleAlertsToTrigger finds percent and trailing alerts where the latest
+// price has moved far enough from the prices seen since the alert was armed.
+//
+// The range of prices seen is loaded for each alert, as each alert was armed
+// at a different time.
+func findRuleAlertsToTrigger(conn *database.Conn) ([]*CryptoAlert, error) {
+	rows, err := conn.Query(
+		`
+			SELECT
+				alert_id,
+				user_id,
+				username,
+				from_currency_name,
+				from_currency_ticker,
+				to_currency_name,
+				to_currency_ticker,
+				above,
+				value,
+				alert_time,
+				active_from,
+				expires_at,
+				note,
+				labels,
+				ladder_id,
+				indicator,
+				period,
+				rule,
+				recurring,
+				upper
+			FROM (
+				SELECT *
+				FROM crypto_alert
+				ORDER BY updated_at DESC
+				LIMIT 1 BY alert_id
+			)
+			-- Predicate pushdown: filter after fetching latest.
+			WHERE is_deleted = 0 AND sent = 0
+			AND indicator = '' AND rule != ''
+			AND (active_from IS NULL OR active_from <= now64(9))
+			AND (expires_at IS NULL OR expires_at > now64(9))
+		`,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidateList []*CryptoAlert
+
+	for rows.Next() {
+		alert := &CryptoAlert{}
+
+		if err := scanCryptoAlert(rows, alert); err != nil {
+			return nil, err
+		}
+
+		candidateList = append(candidateList, alert)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var alertList []*CryptoAlert
+
+	for _, alert := range candidateList {
+		var priceRange backtest.PriceRange
+		var latest decimal.Decimal
+		var count uint64
+		since := armedSince(alert)
+
+		row := conn.QueryRow(
+			`
+				SELECT
+					argMin(value, time),
+					max(value),
+					min(value),
+					argMax(value, time),
+					count()
+				FROM crypto_currency_prices
+				-- Skip partitions from before the alert was armed.
+				PREWHERE yearmonth >= toYear(?) * 100 + toMonth(?)
+				WHERE from_currency_ticker = ?
+				AND to_currency_ticker = ?
+				AND time >= ?
+			`,
+			since,
+			since,
+			alert.FromCurrencyTick,
+			alert.ToCurrencyTick,
+			since,
+		)
+
+		if err := row.Scan(&priceRange.First, &priceRange.High, &priceRange.Low, &latest, &count); err != nil {
+			return nil, err
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		rule := model.Alert{Above: alert.Above, Value: alert.Value, Rule: alert.Rule}
+
+		if backtest.IsTriggered(&rule, backtest.Target(&rule, priceRange), latest) {
+			alertList = append(alertList, alert)
+		}
+	}
+
+	return alertList, nil
+}
+
+// findAlertsToRearm finds recurring threshold alerts that were sent, where
+// the latest price has since moved back across the threshold.
+func findAlertsToRearm(conn *database.Conn) ([]*CryptoAlert, error) {
+	rows, err := conn.Query(
+		`
+			SELECT
+				alerts.alert_id,
+				alerts.user_id,
+				alerts.username,
+				alerts.from_currency_name,
+				alerts.from_currency_ticker,
+				alerts.to_currency_name,
+				alerts.to_currency_ticker,
+				alerts.above,
+				alerts.value,
+				alerts.alert_time,
+				alerts.active_from,
+				alerts.expires_at,
+				alerts.note,
+				alerts.labels,
+				alerts.ladder_id,
+				alerts.indicator,
+				alerts.period,
+				alerts.rule,
+				alerts.recurring,
+				alerts.upper
+			FROM (
+				SELECT *
+				FROM (
+					SELECT *
+					FROM crypto_alert
+					ORDER BY updated_at DESC
+					LIMIT 1 BY alert_id
+				)
+				-- Predicate pushdown: filter after fetching latest.
+				WHERE is_deleted = 0 AND sent = 1 AND recurring = 1
+				AND indicator = '' AND rule = ''
+				AND (expires_at IS NULL OR expires_at > now64(9))
+			) AS alerts
+			INNER JOIN (
+				SELECT
+					from_currency_ticker,
+					to_currency_ticker,
+					argMax(value, time) AS latest_value,
+					max(time) AS latest_time
+				FROM crypto_currency_prices
+				PREWHERE yearmonth >= toYear(addMonths(now(), -1)) * 100 + toMonth(addMonths(now(), -1))
+				WHERE (from_currency_ticker, to_currency_ticker) IN (
+					SELECT from_currency_ticker, to_currency_ticker
+					FROM (
+						SELECT *
+						FROM crypto_alert
+						ORDER BY updated_at DESC
+						LIMIT 1 BY alert_id
+					)
+					WHERE is_deleted = 0 AND sent = 1 AND recurring = 1
+				)
+				GROUP BY from_currency_ticker, to_currency_ticker
+			) AS prices
+			ON prices.from_currency_ticker = alerts.from_currency_ticker
+			AND prices.to_currency_ticker = alerts.to_currency_ticker
+			WHERE (
+				(alerts.above = 1 AND prices.latest_value < alerts.value)
+				OR (alerts.above = 0 AND prices.latest_value > alerts.value)
+			)
+			-- Only prices from after the alert was sent can arm it again.
+			AND prices.latest_time > alerts.updated_at
+		`,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alertList []*CryptoAlert
+
+	for rows.Next() {
+		alert := &CryptoAlert{}
+
+		if err := scanCryptoAlert(rows, alert); err != nil {
 			return nil, err
 		}
-		alert.Above = above == 1
-		alert.Value = value
+
 		alertList = append(alertList, alert)
 	}
 
@@ -286,19 +679,11 @@ Prices have changed recently:
 		priceStringLines := make([]string, len(groupedList))
 
 		for i, alert := range groupedList {
-			operator := "<="
+			priceStringLines[i] = describeAlert(alert)
 
-			if alert.Above {
-				operator = ">="
+			if alert.Note != "" {
+				priceStringLines[i] += "\n  " + alert.Note
 			}
-
-			priceStringLines[i] = fmt.Sprintf(
-				"1 %s %s %s %s",
-				alert.FromCurrencyName,
-				operator,
-				alert.Value,
-				alert.ToCurrencyName,
-			)
 		}
 
 		message = strings.Replace(message, "{to}", email, -1)
@@ -315,16 +700,103 @@ Prices have changed recently:
 	return nil
 }
 
-func markAlertsAsSent(conn *database.Conn, alertList []*CryptoAlert) error {
+// describeAlert returns a line describing an alert for an email.
+func describeAlert(alert *CryptoAlert) string {
+	direction := "below"
+	operator := "<="
+
+	if alert.Above {
+		direction = "above"
+		operator = ">="
+	}
+
+	switch alert.Indicator {
+	case indicator.SMA, indicator.EMA:
+		return fmt.Sprintf(
+			"1 %s crossed %s its %d day %s in %s",
+			alert.FromCurrencyName,
+			direction,
+			alert.Period,
+			strings.ToUpper(alert.Indicator),
+			alert.ToCurrencyName,
+		)
+	case indicator.RSI:
+		if alert.Upper.IsPositive() {
+			return fmt.Sprintf(
+				"The %d day RSI of %s in %s left the band from %s to %s",
+				alert.Period,
+				alert.FromCurrencyName,
+				alert.ToCurrencyName,
+				alert.Value,
+				alert.Upper,
+			)
+		}
+
+		return fmt.Sprintf(
+			"The %d day RSI of %s in %s crossed %s %s",
+			alert.Period,
+			alert.FromCurrencyName,
+			alert.ToCurrencyName,
+			direction,
+			alert.Value,
+		)
+	}
+
+	movement := "fell"
+	extreme := "high"
+
+	if alert.Above {
+		movement = "rose"
+		extreme = "low"
+	}
+
+	switch alert.Rule {
+	case backtest.Percent:
+		return fmt.Sprintf(
+			"1 %s %s %s%% in %s",
+			alert.FromCurrencyName,
+			movement,
+			alert.Value,
+			alert.ToCurrencyName,
+		)
+	case backtest.Trailing:
+		return fmt.Sprintf(
+			"1 %s %s %s%% from its %s in %s",
+			alert.FromCurrencyName,
+			movement,
+			alert.Value,
+			extreme,
+			alert.ToCurrencyName,
+		)
+	}
+
+	return fmt.Sprintf(
+		"1 %s %s %s %s",
+		alert.FromCurrencyName,
+		operator,
+		alert.Value,
+		alert.ToCurrencyName,
+	)
+}
+
+// saveAlertList writes new versions of alerts, marking them as sent or as
+// armed again.
+func saveAlertList(conn *database.Conn, alertList []*CryptoAlert, sent bool) error {
 	batch, err := conn.PrepareBatch(
 		`insert into crypto_alert
 			(alert_id, user_id, username, above, alert_time, sent, value,
 			 from_currency_ticker, from_currency_name,
 			 to_currency_ticker, to_currency_name,
+			 active_from, expires_at,
+			 note, labels, ladder_id,
+			 indicator, period, rule, recurring, upper,
 			 updated_at, is_deleted)
 		values (?, ?, ?, ?, ?, ?, ?,
 			?, ?,
 			?, ?,
+			?, ?,
+			?, ?, ?,
+			?, ?, ?, ?, ?,
 			?, ?)`,
 	)
 
@@ -337,14 +809,24 @@ func markAlertsAsSent(conn *database.Conn, alertList []*CryptoAlert) error {
 			alert.Id,
 			alert.UserID,
 			alert.Email,
-			boolToUint(alert.Above),
+			database.BoolToUint(alert.Above),
 			alert.AlertTime,
-			uint8(1),
+			database.BoolToUint(sent),
 			alert.Value,
 			alert.FromCurrencyTick,
 			alert.FromCurrencyName,
 			alert.ToCurrencyTick,
 			alert.ToCurrencyName,
+			alert.ActiveFrom,
+			alert.ExpiresAt,
+			alert.Note,
+			alert.Labels,
+			alert.LadderID,
+			alert.Indicator,
+			uint16(alert.Period),
+			alert.Rule,
+			database.BoolToUint(alert.Recurring),
+			alert.Upper,
 			time.Now().UTC(),
 			uint8(0),
 		); err != nil {
@@ -355,6 +837,22 @@ func markAlertsAsSent(conn *database.Conn, alertList []*CryptoAlert) error {
 	return batch.Send()
 }
 
+func markAlertsAsSent(conn *database.Conn, alertList []*CryptoAlert) error {
+	return saveAlertList(conn, alertList, true)
+}
+
+// rearmAlerts arms alerts again from now, so only prices from now on can
+// trigger them.
+func rearmAlerts(conn *database.Conn, alertList []*CryptoAlert) error {
+	now := time.Now().UTC()
+
+	for _, alert := range alertList {
+		alert.AlertTime = now
+	}
+
+	return saveAlertList(conn, alertList, false)
+}
+
 func main() {
 	env.LoadEnvironmentVariables()
 
@@ -367,6 +865,11 @@ func main() {
 
 	defer conn.Close()
 
+	// Report stale prices without stopping alerts from being sent.
+	if err := checkStalePrices(conn); err != nil {
+		fmt.Fprintf(os.Stderr, "Stale price check error: %s\n", err)
+	}
+
 	alertList, err := findAlertsToTrigger(conn)
 
 	if err != nil {
@@ -374,6 +877,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	indicatorAlertList, err := findIndicatorAlertsToTrigger(conn)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "SQL error: %s\n", err)
+		os.Exit(1)
+	}
+
+	ruleAlertList, err := findRuleAlertsToTrigger(conn)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "SQL error: %s\n", err)
+		os.Exit(1)
+	}
+
+	alertList = append(alertList, indicatorAlertList...)
+	alertList = append(alertList, ruleAlertList...)
+
 	err = sendAlertEmails(alertList)
 
 	if err != nil {
@@ -389,12 +909,27 @@ func main() {
 			os.Exit(1)
 		}
 	}
-}
 
-func boolToUint(value bool) uint8 {
-	if value {
-		return 1
+	// Recurring threshold alerts are armed again once the price moves back
+	// across the threshold, and other recurring alerts are armed again straight
+	// away from the price they were sent at.
+	rearmList, err := findAlertsToRearm(conn)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "SQL error: %s\n", err)
+		os.Exit(1)
 	}
 
-	return 0
+	for _, alert := range alertList {
+		if alert.Recurring && (alert.Indicator != "" || alert.Rule != backtest.Threshold) {
+			rearmList = append(rearmList, alert)
+		}
+	}
+
+	if len(rearmList) > 0 {
+		if err := rearmAlerts(conn, rearmList); err != nil {
+			fmt.Fprintf(os.Stderr, "SQL error: %s\n", err)
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+)
+
+// defaultStalePriceThreshold is how old the newest price can be before
+// administrators are told, when STALE_PRICE_THRESHOLD isn't set.
+const defaultStalePriceThreshold = time.Hour
+
+// adminEmailList returns the addresses in ADMIN_EMAILS, separated by commas.
+func adminEmailList() []string {
+	var emailList []string
+
+	for email := range strings.SplitSeq(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emailList = append(emailList, email)
+		}
+	}
+
+	return emailList
+}
+
+func stalePriceThreshold() (time.Duration, error) {
+	value := os.Getenv("STALE_PRICE_THRESHOLD")
+
+	if value == "" {
+		return defaultStalePriceThreshold, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// stalePriceMessage builds the email telling administrators prices are stale,
+// leaving {to} to be replaced for each address.
+func stalePriceMessage(threshold time.Duration, latestTime time.Time) string {
+	latestString := "never in the last 3 months"
+
+	if latestTime.Year() > 1970 {
+		latestString = latestTime.UTC().Format(time.RFC1123)
+	}
+
+	message := `To: {to}
+From: {from}
+Subject: Price Data Is Stale
+Content-Type: text/plain; charset=UTF-8; format=flowed
+Content-Transfer-Encoding: 7bit
+
+No new prices have been stored for longer than {threshold}.
+
+The newest price was stored: {latest}
+
+Check that bin/ingest is running, as price alerts can't be sent without
+recent prices.
+`
+	message = strings.Replace(message, "{from}", os.Getenv("SMTP_FROM"), -1)
+	message = strings.Replace(message, "{threshold}", threshold.String(), -1)
+	message = strings.Replace(message, "{latest}", latestString, -1)
+
+	return message
+}
+
+// checkStalePrices emails administrators when the newest stored price is
+// older than the threshold, which means bin/ingest has stopped working.
+//
+// Only one email is sent for each newest price, so administrators are told
+// once when ingestion stops instead of on every run.
+func checkStalePrices(conn *database.Conn) error {
+	emailList := adminEmailList()
+
+	if len(emailList) == 0 {
+		return nil
+	}
+
+	threshold, err := stalePriceThreshold()
+
+	if err != nil {
+		return fmt.Errorf("invalid STALE_PRICE_THRESHOLD: %w", err)
+	}
+
+	var latestTime time.Time
+
+	row := conn.QueryRow(
+		`SELECT max(time)
+		FROM crypto_currency_prices
+		-- Don't scan every partition if ingestion stopped long ago.
+		PREWHERE yearmonth >= toYear(addMonths(now(), -3)) * 100 + toMonth(addMonths(now(), -3))`,
+	)
+
+	if err := row.Scan(&latestTime); err != nil {
+		return err
+	}
+
+	age := time.Since(latestTime)
+
+	if age < threshold {
+		return nil
+	}
+
+	var alarmCount uint64
+
+	row = conn.QueryRow(
+		`SELECT count()
+		FROM crypto_stale_price_alarm
+		WHERE latest_price_time = ?`,
+		latestTime,
+	)
+
+	if err := row.Scan(&alarmCount); err != nil {
+		return err
+	}
+
+	if alarmCount > 0 {
+		return nil
+	}
+
+	message := stalePriceMessage(threshold, latestTime)
+
+	for _, email := range emailList {
+		if err := sendEmail(email, strings.Replace(message, "{to}", email, -1)); err != nil {
+			return err
+		}
+	}
+
+	return conn.Exec(
+		`insert into crypto_stale_price_alarm (latest_price_time, sent_at)
+		values (?, now64(9))`,
+		latestTime,
+	)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminEmailList(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{"unset", "", nil},
+		{"one address", "admin@example.com", []string{"admin@example.com"}},
+		{
+			"spaces and empty entries",
+			" a@example.com, ,b@example.com ,",
+			[]string{"a@example.com", "b@example.com"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Setenv("ADMIN_EMAILS", testCase.value)
+
+			if result := adminEmailList(); !slices.Equal(result, testCase.expected) {
+				t.Errorf("got %v, expected %v", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestStalePriceThreshold(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected time.Duration
+		isError  bool
+	}{
+		{name: "default", value: "", expected: defaultStalePriceThreshold},
+		{name: "minutes", value: "30m", expected: 30 * time.Minute},
+		{name: "invalid", value: "soon", isError: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Setenv("STALE_PRICE_THRESHOLD", testCase.value)
+
+			result, err := stalePriceThreshold()
+
+			if testCase.isError {
+				if err == nil {
+					t.Errorf("expected an error, got %s", result)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if result != testCase.expected {
+				t.Errorf("got %s, expected %s", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestStalePriceMessage(t *testing.T) {
+	t.Setenv("SMTP_FROM", "alerts@example.com")
+
+	testCases := []struct {
+		name       string
+		latestTime time.Time
+		expected   string
+	}{
+		{
+			name:       "known time",
+			latestTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			expected:   "The newest price was stored: Tue, 02 Jan 2024 03:04:05 UTC",
+		},
+		{
+			name:       "no recent prices",
+			latestTime: time.Unix(0, 0),
+			expected:   "The newest price was stored: never in the last 3 months",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			message := stalePriceMessage(time.Hour, testCase.latestTime)
+
+			for _, line := range []string{
+				"To: {to}",
+				"From: alerts@example.com",
+				"longer than 1h0m0s.",
+				testCase.expected,
+			} {
+				if !strings.Contains(message, line) {
+					t.Errorf("expected %q in:\n%s", line, message)
+				}
+			}
+		})
+	}
+}
@@ -13,15 +13,22 @@ import (
 	"github.com/dense-analysis/pricewarp/internal/database"
 	"github.com/dense-analysis/pricewarp/internal/env"
 	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/openapi"
 	"github.com/dense-analysis/pricewarp/internal/route/alert"
 	"github.com/dense-analysis/pricewarp/internal/route/auth"
 	"github.com/dense-analysis/pricewarp/internal/route/portfolio"
+	"github.com/dense-analysis/pricewarp/internal/route/price"
+	"github.com/dense-analysis/pricewarp/internal/route/stream"
+	"github.com/dense-analysis/pricewarp/internal/route/token"
 	"github.com/dense-analysis/pricewarp/internal/route/util"
 	"github.com/dense-analysis/pricewarp/internal/session"
 	"github.com/dense-analysis/pricewarp/internal/template"
 	"github.com/gorilla/mux"
 )
 
+// streamPollInterval is how often live updates are loaded for /stream.
+const streamPollInterval = 10 * time.Second
+
 func handleIndex(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
 	var user model.User
 	found, err := session.LoadUserFromSession(conn, request, &user)
@@ -52,11 +59,8 @@ func addDatabaseConnection(
 	}
 }
 
-func main() {
-	env.LoadEnvironmentVariables()
-	session.InitSessionStorage()
-	template.Init()
-
+// newRouter registers the routes for every page and API endpoint.
+func newRouter(broker *stream.Broker) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
 	indexRoute := addDatabaseConnection(handleIndex)
@@ -68,12 +72,55 @@ func main() {
 	alertRoute := addDatabaseConnection(alert.HandleAlert)
 	updateAlertRoute := addDatabaseConnection(alert.HandleUpdateAlert)
 	deleteAlertRoute := addDatabaseConnection(alert.HandleDeleteAlert)
+	backtestAlertRoute := addDatabaseConnection(alert.HandleBacktestAlert)
+	exportAlertsRoute := addDatabaseConnection(alert.HandleExportAlerts)
+	importAlertsRoute := addDatabaseConnection(alert.HandleImportAlerts)
+	ladderCreateRoute := addDatabaseConnection(alert.HandleSubmitLadder)
+	ladderRoute := addDatabaseConnection(alert.HandleLadder)
+	updateLadderRoute := addDatabaseConnection(alert.HandleUpdateLadder)
+	deleteLadderRoute := addDatabaseConnection(alert.HandleDeleteLadder)
+
+	apiAlertListRoute := addDatabaseConnection(alert.HandleAPIAlertList)
+	apiAlertCreateRoute := addDatabaseConnection(alert.HandleAPICreateAlert)
+	apiAlertRoute := addDatabaseConnection(alert.HandleAPIAlert)
+	apiUpdateAlertRoute := addDatabaseConnection(alert.HandleAPIUpdateAlert)
+	apiDeleteAlertRoute := addDatabaseConnection(alert.HandleAPIDeleteAlert)
 
 	portfolioRoute := addDatabaseConnection(portfolio.HandlePortfolio)
 	portfolioUpdateRoute := addDatabaseConnection(portfolio.HandlePortfolioUpdate)
+	portfolioCreateRoute := addDatabaseConnection(portfolio.HandlePortfolioCreate)
+	portfolioSelectRoute := addDatabaseConnection(portfolio.HandlePortfolioSelect)
+	combinedPortfolioRoute := addDatabaseConnection(portfolio.HandleCombinedPortfolio)
+	cashDepositRoute := addDatabaseConnection(portfolio.HandleCashDeposit)
+	cashWithdrawalRoute := addDatabaseConnection(portfolio.HandleCashWithdrawal)
+	deleteCashFlowRoute := addDatabaseConnection(portfolio.HandleDeleteCashFlow)
+	transferRoute := addDatabaseConnection(portfolio.HandleTransfer)
 	portfolioAssetRoute := addDatabaseConnection(portfolio.HandleAsset)
 	portfolioBuyRoute := addDatabaseConnection(portfolio.HandleAssetBuy)
 	portfolioSellRoute := addDatabaseConnection(portfolio.HandleAssetSell)
+	portfolioSwapRoute := addDatabaseConnection(portfolio.HandleAssetSwap)
+	gainsRoute := addDatabaseConnection(portfolio.HandleGains)
+	taxReportRoute := addDatabaseConnection(portfolio.HandleTaxReport)
+	tradeImportFormRoute := addDatabaseConnection(portfolio.HandleTradeImportForm)
+	tradeImportPreviewRoute := addDatabaseConnection(portfolio.HandleTradeImportPreview)
+	tradeImportRoute := addDatabaseConnection(portfolio.HandleTradeImport)
+	transactionCreateRoute := addDatabaseConnection(portfolio.HandleCreateTransaction)
+	transactionRoute := addDatabaseConnection(portfolio.HandleTransaction)
+	updateTransactionRoute := addDatabaseConnection(portfolio.HandleUpdateTransaction)
+	deleteTransactionRoute := addDatabaseConnection(portfolio.HandleDeleteTransaction)
+
+	apiLatestPriceRoute := addDatabaseConnection(price.HandleAPILatestPrice)
+	apiPriceHistoryRoute := addDatabaseConnection(price.HandleAPIPriceHistory)
+
+	settingsRoute := addDatabaseConnection(token.HandleSettings)
+	tokenCreateRoute := addDatabaseConnection(token.HandleCreateToken)
+	tokenDeleteRoute := addDatabaseConnection(token.HandleDeleteToken)
+
+	apiPortfolioRoute := addDatabaseConnection(portfolio.HandleAPIPortfolio)
+	apiPortfolioListRoute := addDatabaseConnection(portfolio.HandleAPIPortfolioList)
+	apiPortfolioBuyRoute := addDatabaseConnection(portfolio.HandleAPIAssetBuy)
+	apiPortfolioSellRoute := addDatabaseConnection(portfolio.HandleAPIAssetSell)
+	apiPortfolioSwapRoute := addDatabaseConnection(portfolio.HandleAPIAssetSwap)
 
 	router.HandleFunc("/", indexRoute).Methods("GET")
 	router.HandleFunc("/login", auth.HandleViewLoginForm).Methods("GET")
@@ -81,14 +128,66 @@ func main() {
 	router.HandleFunc("/logout", auth.HandleLogout).Methods("POST")
 	router.HandleFunc("/alert", alertListRoute).Methods("GET")
 	router.HandleFunc("/alert", alertCreateRoute).Methods("POST")
+	router.HandleFunc("/alert/backtest", backtestAlertRoute).Methods("POST")
+	router.HandleFunc("/alert/export", exportAlertsRoute).Methods("GET")
+	router.HandleFunc("/alert/import", importAlertsRoute).Methods("POST")
+	router.HandleFunc("/alert/ladder", ladderCreateRoute).Methods("POST")
+	router.HandleFunc("/alert/ladder/{id}", ladderRoute).Methods("GET")
+	router.HandleFunc("/alert/ladder/{id}", updateLadderRoute).Methods("POST")
+	router.HandleFunc("/alert/ladder/{id}", deleteLadderRoute).Methods("DELETE")
 	router.HandleFunc("/alert/{id}", alertRoute).Methods("GET")
 	router.HandleFunc("/alert/{id}", updateAlertRoute).Methods("POST")
 	router.HandleFunc("/alert/{id}", deleteAlertRoute).Methods("DELETE")
+	router.HandleFunc("/api/openapi.json", openapi.HandleDocument).Methods("GET")
+	router.HandleFunc("/api/v1/alerts", apiAlertListRoute).Methods("GET")
+	router.HandleFunc("/api/v1/alerts", apiAlertCreateRoute).Methods("POST")
+	router.HandleFunc("/api/v1/alerts/{id}", apiAlertRoute).Methods("GET")
+	router.HandleFunc("/api/v1/alerts/{id}", apiUpdateAlertRoute).Methods("PUT")
+	router.HandleFunc("/api/v1/alerts/{id}", apiDeleteAlertRoute).Methods("DELETE")
+	router.HandleFunc("/api/v1/portfolio", apiPortfolioRoute).Methods("GET")
+	router.HandleFunc("/api/v1/portfolios", apiPortfolioListRoute).Methods("GET")
+	router.HandleFunc("/api/v1/portfolio/{ticker}/buy", apiPortfolioBuyRoute).Methods("POST")
+	router.HandleFunc("/api/v1/portfolio/{ticker}/sell", apiPortfolioSellRoute).Methods("POST")
+	router.HandleFunc("/api/v1/portfolio/{ticker}/swap", apiPortfolioSwapRoute).Methods("POST")
+	router.HandleFunc("/api/v1/prices/latest", apiLatestPriceRoute).Methods("GET")
+	router.HandleFunc("/api/v1/prices/history", apiPriceHistoryRoute).Methods("GET")
 	router.HandleFunc("/portfolio", portfolioRoute).Methods("GET")
 	router.HandleFunc("/portfolio", portfolioUpdateRoute).Methods("POST")
+	router.HandleFunc("/portfolio/new", portfolioCreateRoute).Methods("POST")
+	router.HandleFunc("/portfolio/select", portfolioSelectRoute).Methods("POST")
+	router.HandleFunc("/portfolio/combined", combinedPortfolioRoute).Methods("GET")
+	router.HandleFunc("/portfolio/cash/deposit", cashDepositRoute).Methods("POST")
+	router.HandleFunc("/portfolio/cash/withdraw", cashWithdrawalRoute).Methods("POST")
+	router.HandleFunc("/portfolio/cash/{id}", deleteCashFlowRoute).Methods("DELETE")
+	router.HandleFunc("/portfolio/transfer", transferRoute).Methods("POST")
+	router.HandleFunc("/portfolio/gains", gainsRoute).Methods("GET")
+	router.HandleFunc("/portfolio/report", taxReportRoute).Methods("GET")
+	router.HandleFunc("/portfolio/import", tradeImportFormRoute).Methods("GET")
+	router.HandleFunc("/portfolio/import", tradeImportPreviewRoute).Methods("POST")
+	router.HandleFunc("/portfolio/import/confirm", tradeImportRoute).Methods("POST")
+	router.HandleFunc("/portfolio/transaction/{id}", transactionRoute).Methods("GET")
+	router.HandleFunc("/portfolio/transaction/{id}", updateTransactionRoute).Methods("POST")
+	router.HandleFunc("/portfolio/transaction/{id}", deleteTransactionRoute).Methods("DELETE")
 	router.HandleFunc("/portfolio/{ticker}", portfolioAssetRoute).Methods("GET")
 	router.HandleFunc("/portfolio/{ticker}/buy", portfolioBuyRoute).Methods("POST")
 	router.HandleFunc("/portfolio/{ticker}/sell", portfolioSellRoute).Methods("POST")
+	router.HandleFunc("/portfolio/{ticker}/swap", portfolioSwapRoute).Methods("POST")
+	router.HandleFunc("/portfolio/{ticker}/transaction", transactionCreateRoute).Methods("POST")
+	router.HandleFunc("/stream", broker.HandleStream).Methods("GET")
+	router.HandleFunc("/settings", settingsRoute).Methods("GET")
+	router.HandleFunc("/settings/token", tokenCreateRoute).Methods("POST")
+	router.HandleFunc("/settings/token/{id}", tokenDeleteRoute).Methods("DELETE")
+
+	return router
+}
+
+func main() {
+	env.LoadEnvironmentVariables()
+	session.InitSessionStorage()
+	template.Init()
+
+	broker := stream.NewBroker(streamPollInterval)
+	router := newRouter(broker)
 
 	if os.Getenv("DEBUG") == "true" {
 		fileServer := http.FileServer(http.Dir("./static/"))
@@ -106,6 +205,7 @@ func main() {
 		Addr:    address,
 		Handler: router,
 	}
+	server.RegisterOnShutdown(broker.Close)
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
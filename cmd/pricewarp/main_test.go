@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dense-analysis/pricewarp/internal/openapi"
+	"github.com/dense-analysis/pricewarp/internal/route/stream"
+)
+
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	router := newRouter(stream.NewBroker(streamPollInterval))
+
+	if err := openapi.VerifyRoutes(router); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,73 @@
+// Save a daily snapshot of the value of every portfolio
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/env"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/portfolio"
+)
+
+// userPortfolio is a portfolio to save a snapshot of.
+type userPortfolio struct {
+	user        model.User
+	portfolioID int64
+}
+
+func scanUserPortfolio(row database.Row, userPortfolio *userPortfolio) error {
+	return row.Scan(&userPortfolio.user.ID, &userPortfolio.user.Username, &userPortfolio.portfolioID)
+}
+
+func main() {
+	env.LoadEnvironmentVariables()
+
+	conn, err := database.Connect()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Connection error: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer conn.Close()
+
+	var portfolioList []userPortfolio
+
+	err = model.LoadList(
+		conn,
+		&portfolioList,
+		10,
+		scanUserPortfolio,
+		`select user_id, username, portfolio_id
+		from crypto_portfolio
+		order by updated_at desc
+		limit 1 by user_id, portfolio_id`,
+	)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "SQL error: %s\n", err)
+		os.Exit(1)
+	}
+
+	failed := false
+
+	// Keep saving snapshots for other portfolios if one fails.
+	for _, userPortfolio := range portfolioList {
+		if err := portfolio.SaveSnapshot(conn, &userPortfolio.user, userPortfolio.portfolioID); err != nil {
+			fmt.Fprintf(
+				os.Stderr,
+				"Snapshot error for user %d, portfolio %d: %s\n",
+				userPortfolio.user.ID,
+				userPortfolio.portfolioID,
+				err,
+			)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
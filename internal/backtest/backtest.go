@@ -0,0 +1,179 @@
+// Package backtest replays stored prices against alert rules.
+package backtest
+
+import (
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/indicator"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// Rules for comparing prices with the value of an alert.
+const (
+	// Threshold triggers when the price reaches the value.
+	Threshold = ""
+	// Percent triggers when the price moves by the value, as a percentage,
+	// from the price when the alert was armed.
+	Percent = "percent"
+	// Trailing triggers when the price falls by the value, as a percentage,
+	// from its highest price since the alert was armed, or rises by the
+	// value from its lowest price for alerts going above.
+	Trailing = "trailing"
+)
+
+var hundred = decimal.NewFromInt(100)
+
+// IsValidRule returns true if a name is a supported rule.
+func IsValidRule(rule string) bool {
+	return rule == Threshold || rule == Percent || rule == Trailing
+}
+
+// Trigger is a point in time where an alert would have been sent.
+type Trigger struct {
+	Time  time.Time
+	Value decimal.Decimal
+}
+
+// PriceRange is the prices seen since an alert was armed.
+type PriceRange struct {
+	First decimal.Decimal
+	High  decimal.Decimal
+	Low   decimal.Decimal
+}
+
+// NewPriceRange returns a range starting at a price.
+func NewPriceRange(value decimal.Decimal) PriceRange {
+	return PriceRange{First: value, High: value, Low: value}
+}
+
+// Add widens the range to include a price.
+func (priceRange *PriceRange) Add(value decimal.Decimal) {
+	priceRange.High = decimal.Max(priceRange.High, value)
+	priceRange.Low = decimal.Min(priceRange.Low, value)
+}
+
+// Target returns the price that triggers an alert, given the prices seen
+// since it was armed.
+func Target(alert *model.Alert, priceRange PriceRange) decimal.Decimal {
+	reference := priceRange.First
+
+	switch alert.Rule {
+	case Threshold:
+		return alert.Value
+	case Trailing:
+		reference = priceRange.High
+
+		if alert.Above {
+			reference = priceRange.Low
+		}
+	}
+
+	change := reference.Mul(alert.Value).Div(hundred)
+
+	if alert.Above {
+		return reference.Add(change)
+	}
+
+	return reference.Sub(change)
+}
+
+// IsTriggered returns true if a price value reaches the target of an alert.
+func IsTriggered(alert *model.Alert, target decimal.Decimal, value decimal.Decimal) bool {
+	if alert.Above {
+		return value.GreaterThanOrEqual(target)
+	}
+
+	return value.LessThanOrEqual(target)
+}
+
+// IsActive returns true if a time is in the active window of an alert.
+func IsActive(alert *model.Alert, t time.Time) bool {
+	if alert.ActiveFrom != nil && t.Before(*alert.ActiveFrom) {
+		return false
+	}
+
+	return alert.ExpiresAt == nil || t.Before(*alert.ExpiresAt)
+}
+
+// Run returns every time an alert would have triggered for a list of prices.
+//
+// Prices must be sorted oldest first, and prices outside of the active window
+// of the alert are skipped. Alerts are armed again after each trigger, as
+// recurring alerts are. Threshold alerts are armed again once the price moves
+// back across the threshold, so each trigger is a separate crossing. Percent
+// and trailing alerts are armed again at the price they triggered at.
+func Run(alert *model.Alert, priceList []model.Price) []Trigger {
+	var triggerList []Trigger
+	var priceRange PriceRange
+	armed := true
+	started := false
+
+	for _, price := range priceList {
+		if !IsActive(alert, price.Time) {
+			continue
+		}
+
+		if started {
+			priceRange.Add(price.Value)
+		} else {
+			priceRange = NewPriceRange(price.Value)
+			started = true
+		}
+
+		if IsTriggered(alert, Target(alert, priceRange), price.Value) {
+			if armed {
+				triggerList = append(triggerList, Trigger{Time: price.Time, Value: price.Value})
+
+				if alert.Rule == Threshold {
+					armed = false
+				} else {
+					priceRange = NewPriceRange(price.Value)
+				}
+			}
+		} else {
+			armed = true
+		}
+	}
+
+	return triggerList
+}
+
+// Crossings returns whether each value in a series of closing prices just
+// crossed the indicator of an alert, or took the RSI out of its band.
+func Crossings(alert *model.Alert, closeList []decimal.Decimal) []bool {
+	if alert.Indicator == indicator.RSI && alert.Upper.IsPositive() {
+		return indicator.BandExits(alert.Period, alert.Value, alert.Upper, closeList)
+	}
+
+	return indicator.Crossings(alert.Indicator, alert.Period, alert.Above, alert.Value, closeList)
+}
+
+// RunIndicator returns every time an indicator alert would have triggered for
+// a list of daily closing prices.
+//
+// Prices must be sorted oldest first, and should start early enough before
+// `start` for the indicator to be computed, as given by indicator.Lookback.
+// Crossings outside of the active window of the alert are skipped.
+func RunIndicator(alert *model.Alert, closeList []model.Price, start time.Time) []Trigger {
+	var triggerList []Trigger
+	valueList := make([]decimal.Decimal, len(closeList))
+
+	for i, price := range closeList {
+		valueList[i] = price.Value
+	}
+
+	crossedList := Crossings(alert, valueList)
+
+	for i, price := range closeList {
+		if price.Time.Before(start) || !IsActive(alert, price.Time) {
+			continue
+		}
+
+		if crossedList[i] {
+			triggerList = append(triggerList, Trigger{Time: price.Time, Value: price.Value})
+		}
+	}
+
+	return triggerList
+}
@@ -0,0 +1,216 @@
+package backtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/indicator"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+var start = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// minute returns the time of the price at an index of a list from newPriceList.
+func minute(index int) *time.Time {
+	t := start.Add(time.Duration(index) * time.Minute)
+
+	return &t
+}
+
+// newPriceList returns prices a minute apart from the start of 2024.
+func newPriceList(valueList ...string) []model.Price {
+	priceList := make([]model.Price, len(valueList))
+
+	for i, value := range valueList {
+		priceList[i] = model.Price{
+			Time:  *minute(i),
+			Value: decimal.RequireFromString(value),
+		}
+	}
+
+	return priceList
+}
+
+// checkTriggerList checks triggers against the indexes of the prices expected
+// to trigger an alert.
+func checkTriggerList(t *testing.T, triggerList []Trigger, priceList []model.Price, expected []int) {
+	t.Helper()
+
+	if len(triggerList) != len(expected) {
+		t.Fatalf("got %d triggers, expected %d", len(triggerList), len(expected))
+	}
+
+	for i, index := range expected {
+		price := priceList[index]
+
+		if !triggerList[i].Time.Equal(price.Time) || !triggerList[i].Value.Equal(price.Value) {
+			t.Errorf(
+				"trigger %d: got %s at %s, expected %s at %s",
+				i, triggerList[i].Value, triggerList[i].Time, price.Value, price.Time,
+			)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name       string
+		rule       string
+		value      string
+		above      bool
+		activeFrom *time.Time
+		expiresAt  *time.Time
+		priceList  []model.Price
+		// expected lists the indexes of the prices that trigger the alert.
+		expected []int
+	}{
+		{
+			name:      "Never triggered",
+			value:     "100",
+			above:     true,
+			priceList: newPriceList("90", "95", "99"),
+		},
+		{
+			name:      "Triggered when reaching the threshold",
+			value:     "100",
+			above:     true,
+			priceList: newPriceList("90", "100", "110"),
+			expected:  []int{1},
+		},
+		{
+			name:      "Triggered straight away",
+			value:     "100",
+			above:     false,
+			priceList: newPriceList("90", "80", "110"),
+			expected:  []int{0},
+		},
+		{
+			name:      "Armed again after crossing back",
+			value:     "100",
+			above:     true,
+			priceList: newPriceList("90", "105", "110", "95", "101", "99", "100"),
+			expected:  []int{1, 4, 6},
+		},
+		{
+			name:      "Below the threshold",
+			value:     "100",
+			above:     false,
+			priceList: newPriceList("110", "100", "90", "101", "99"),
+			expected:  []int{1, 4},
+		},
+		{
+			name:       "Prices before the alert is active are skipped",
+			value:      "100",
+			above:      true,
+			activeFrom: minute(2),
+			priceList:  newPriceList("105", "95", "90", "100"),
+			expected:   []int{3},
+		},
+		{
+			name:       "Prices once the alert expires are skipped",
+			value:      "100",
+			above:      true,
+			activeFrom: minute(1),
+			expiresAt:  minute(3),
+			priceList:  newPriceList("105", "95", "101", "99", "110"),
+			expected:   []int{2},
+		},
+		{
+			name:      "Percent rise from the first price",
+			rule:      Percent,
+			value:     "10",
+			above:     true,
+			priceList: newPriceList("100", "105", "110", "115", "121"),
+			expected:  []int{2, 4},
+		},
+		{
+			name:      "Percent fall from the first price",
+			rule:      Percent,
+			value:     "10",
+			above:     false,
+			priceList: newPriceList("100", "95", "90", "85", "81"),
+			expected:  []int{2, 4},
+		},
+		{
+			name:       "Percent change from the first active price",
+			rule:       Percent,
+			value:      "10",
+			above:      true,
+			activeFrom: minute(1),
+			priceList:  newPriceList("50", "100", "105", "110"),
+			expected:   []int{3},
+		},
+		{
+			name:      "Trailing fall from the highest price",
+			rule:      Trailing,
+			value:     "10",
+			above:     false,
+			priceList: newPriceList("100", "120", "110", "108", "130", "117"),
+			expected:  []int{3, 5},
+		},
+		{
+			name:      "Trailing rise from the lowest price",
+			rule:      Trailing,
+			value:     "10",
+			above:     true,
+			priceList: newPriceList("100", "80", "85", "88", "70", "77"),
+			expected:  []int{3, 5},
+		},
+		{
+			name:  "No prices",
+			value: "100",
+			above: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			alert := model.Alert{
+				Rule:       testCase.rule,
+				Value:      decimal.RequireFromString(testCase.value),
+				Above:      testCase.above,
+				ActiveFrom: testCase.activeFrom,
+				ExpiresAt:  testCase.expiresAt,
+			}
+			triggerList := Run(&alert, testCase.priceList)
+
+			checkTriggerList(t, triggerList, testCase.priceList, testCase.expected)
+		})
+	}
+}
+
+func TestRunIndicator(t *testing.T) {
+	// The price crosses above its 2 period SMA at 2 and 6, and below it at 4.
+	priceList := newPriceList("10", "10", "12", "12", "9", "9", "11")
+
+	testCases := []struct {
+		name       string
+		above      bool
+		start      time.Time
+		activeFrom *time.Time
+		expiresAt  *time.Time
+		expected   []int
+	}{
+		{name: "Crossing above", above: true, start: start, expected: []int{2, 6}},
+		{name: "Crossing below", above: false, start: start, expected: []int{4}},
+		{name: "Crossings before the start are skipped", above: true, start: *minute(3), expected: []int{6}},
+		{name: "Crossings before the alert is active are skipped", above: true, start: start, activeFrom: minute(3), expected: []int{6}},
+		{name: "Crossings once the alert expires are skipped", above: true, start: start, expiresAt: minute(6), expected: []int{2}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			alert := model.Alert{
+				Indicator:  indicator.SMA,
+				Period:     2,
+				Above:      testCase.above,
+				ActiveFrom: testCase.activeFrom,
+				ExpiresAt:  testCase.expiresAt,
+			}
+			triggerList := RunIndicator(&alert, priceList, testCase.start)
+
+			checkTriggerList(t, triggerList, priceList, testCase.expected)
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Package chart renders line charts as SVG, so pages can show charts without
+// any JavaScript.
+package chart
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Default dimensions of a chart, in SVG units. Charts scale to fit the page.
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 300
+)
+
+// Space around the plot for labels, in SVG units.
+const (
+	paddingLeft   = 70
+	paddingRight  = 10
+	paddingTop    = 10
+	paddingBottom = 25
+)
+
+// Point is a value at a point in time.
+type Point struct {
+	Time  time.Time
+	Value decimal.Decimal
+}
+
+// Series is a line on a chart.
+type Series struct {
+	Name string
+	// Class is added to the line, so each series can be styled.
+	Class     string
+	PointList []Point
+}
+
+// Marker is an event marked on a chart, such as a trade.
+type Marker struct {
+	Time  time.Time
+	Value decimal.Decimal
+	Class string
+	// Label is shown when hovering over the marker.
+	Label string
+}
+
+// Chart is a line chart of values over time.
+type Chart struct {
+	Width      int
+	Height     int
+	SeriesList []Series
+	MarkerList []Marker
+	// TimeFormat is the layout for the times at each end of the chart.
+	TimeFormat string
+	// EmptyText is shown instead of the chart when there are no points.
+	EmptyText string
+}
+
+// IsEmpty returns true if there are no points to draw.
+func (chart Chart) IsEmpty() bool {
+	for _, series := range chart.SeriesList {
+		if len(series.PointList) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bounds returns the range of times and values of every point and marker.
+func (chart Chart) bounds() (time.Time, time.Time, float64, float64) {
+	var start, end time.Time
+	var low, high float64
+	first := true
+
+	include := func(t time.Time, value decimal.Decimal) {
+		v := value.InexactFloat64()
+
+		if first {
+			start, end, low, high = t, t, v, v
+			first = false
+
+			return
+		}
+
+		if t.Before(start) {
+			start = t
+		}
+
+		if t.After(end) {
+			end = t
+		}
+
+		low = min(low, v)
+		high = max(high, v)
+	}
+
+	for _, series := range chart.SeriesList {
+		for _, point := range series.PointList {
+			include(point.Time, point.Value)
+		}
+	}
+
+	for _, marker := range chart.MarkerList {
+		include(marker.Time, marker.Value)
+	}
+
+	return start, end, low, high
+}
+
+// SVG renders the chart as an SVG element.
+func (chart Chart) SVG() template.HTML {
+	width := chart.Width
+	height := chart.Height
+
+	if width == 0 {
+		width = DefaultWidth
+	}
+
+	if height == 0 {
+		height = DefaultHeight
+	}
+
+	var builder strings.Builder
+
+	fmt.Fprintf(
+		&builder,
+		`<svg class="chart" viewBox="0 0 %d %d" role="img">`,
+		width,
+		height,
+	)
+
+	if chart.IsEmpty() {
+		fmt.Fprintf(
+			&builder,
+			`<text class="chart-empty" x="%d" y="%d" text-anchor="middle">%s</text></svg>`,
+			width/2,
+			height/2,
+			html.EscapeString(chart.EmptyText),
+		)
+
+		return template.HTML(builder.String())
+	}
+
+	start, end, low, high := chart.bounds()
+
+	// Avoid dividing by zero for flat lines or single points.
+	if high == low {
+		high++
+		low--
+	}
+
+	if !end.After(start) {
+		end = start.Add(time.Second)
+	}
+
+	plotWidth := float64(width - paddingLeft - paddingRight)
+	plotHeight := float64(height - paddingTop - paddingBottom)
+	duration := float64(end.Sub(start))
+
+	x := func(t time.Time) float64 {
+		return paddingLeft + float64(t.Sub(start))/duration*plotWidth
+	}
+
+	y := func(value decimal.Decimal) float64 {
+		return paddingTop + (high-value.InexactFloat64())/(high-low)*plotHeight
+	}
+
+	// Axis labels for the highest and lowest values, and the first and last times.
+	fmt.Fprintf(
+		&builder,
+		`<text class="chart-label" x="%d" y="%d" text-anchor="end" dominant-baseline="hanging">%s</text>`,
+		paddingLeft-5,
+		paddingTop,
+		decimal.NewFromFloat(high).StringFixed(2),
+	)
+	fmt.Fprintf(
+		&builder,
+		`<text class="chart-label" x="%d" y="%d" text-anchor="end">%s</text>`,
+		paddingLeft-5,
+		height-paddingBottom,
+		decimal.NewFromFloat(low).StringFixed(2),
+	)
+	fmt.Fprintf(
+		&builder,
+		`<text class="chart-label" x="%d" y="%d">%s</text>`,
+		paddingLeft,
+		height-5,
+		html.EscapeString(start.UTC().Format(chart.TimeFormat)),
+	)
+	fmt.Fprintf(
+		&builder,
+		`<text class="chart-label" x="%d" y="%d" text-anchor="end">%s</text>`,
+		width-paddingRight,
+		height-5,
+		html.EscapeString(end.UTC().Format(chart.TimeFormat)),
+	)
+
+	for _, series := range chart.SeriesList {
+		fmt.Fprintf(&builder, `<polyline class="chart-line %s" points="`, html.EscapeString(series.Class))
+
+		for i, point := range series.PointList {
+			if i > 0 {
+				builder.WriteByte(' ')
+			}
+
+			fmt.Fprintf(&builder, "%.1f,%.1f", x(point.Time), y(point.Value))
+		}
+
+		fmt.Fprintf(&builder, `"><title>%s</title></polyline>`, html.EscapeString(series.Name))
+	}
+
+	for _, marker := range chart.MarkerList {
+		fmt.Fprintf(
+			&builder,
+			`<circle class="chart-marker %s" cx="%.1f" cy="%.1f" r="5"><title>%s</title></circle>`,
+			html.EscapeString(marker.Class),
+			x(marker.Time),
+			y(marker.Value),
+			html.EscapeString(marker.Label),
+		)
+	}
+
+	builder.WriteString("</svg>")
+
+	return template.HTML(builder.String())
+}
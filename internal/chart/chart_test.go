@@ -0,0 +1,128 @@
+package chart
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var start = time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+func point(minutes int, value int64) Point {
+	return Point{
+		Time:  start.Add(time.Duration(minutes) * time.Minute),
+		Value: decimal.NewFromInt(value),
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	testCases := []struct {
+		name     string
+		chart    Chart
+		expected bool
+	}{
+		{"no series", Chart{}, true},
+		{"series without points", Chart{SeriesList: []Series{{Name: "a"}}}, true},
+		{
+			"series with a point",
+			Chart{SeriesList: []Series{{Name: "a"}, {PointList: []Point{point(0, 1)}}}},
+			false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if result := testCase.chart.IsEmpty(); result != testCase.expected {
+				t.Errorf("got %v, expected %v", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestBounds(t *testing.T) {
+	chart := Chart{
+		SeriesList: []Series{
+			{PointList: []Point{point(10, 5), point(20, 8)}},
+			{PointList: []Point{point(15, 3)}},
+		},
+		MarkerList: []Marker{{Time: start, Value: decimal.NewFromInt(9)}},
+	}
+	first, last, low, high := chart.bounds()
+
+	if !first.Equal(start) || !last.Equal(start.Add(20*time.Minute)) {
+		t.Errorf("got times %s to %s", first, last)
+	}
+
+	if low != 3 || high != 9 {
+		t.Errorf("got values %v to %v, expected 3 to 9", low, high)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	testCases := []struct {
+		name     string
+		chart    Chart
+		expected []string
+	}{
+		{
+			name:  "empty chart",
+			chart: Chart{EmptyText: "No <prices>"},
+			expected: []string{
+				`viewBox="0 0 800 300"`,
+				`<text class="chart-empty" x="400" y="150" text-anchor="middle">No &lt;prices&gt;</text></svg>`,
+			},
+		},
+		{
+			name: "line and marker",
+			chart: Chart{
+				SeriesList: []Series{{
+					Name:      "BTC",
+					Class:     "price",
+					PointList: []Point{point(0, 10), point(60, 20)},
+				}},
+				MarkerList: []Marker{{
+					Time:  start.Add(30 * time.Minute),
+					Value: decimal.NewFromInt(15),
+					Class: "buy",
+					Label: "Buy & hold",
+				}},
+				TimeFormat: "15:04",
+			},
+			expected: []string{
+				`<polyline class="chart-line price" points="70.0,275.0 790.0,10.0"><title>BTC</title></polyline>`,
+				`<circle class="chart-marker buy" cx="430.0" cy="142.5" r="5"><title>Buy &amp; hold</title></circle>`,
+				`>20.00</text>`,
+				`>10.00</text>`,
+				`>03:00</text>`,
+				`>04:00</text>`,
+			},
+		},
+		{
+			// A single point is drawn in the middle of the height.
+			name: "single point",
+			chart: Chart{
+				Width:      400,
+				Height:     200,
+				SeriesList: []Series{{PointList: []Point{point(0, 5)}}},
+			},
+			expected: []string{
+				`viewBox="0 0 400 200"`,
+				`points="70.0,92.5"`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := string(testCase.chart.SVG())
+
+			for _, expected := range testCase.expected {
+				if !strings.Contains(result, expected) {
+					t.Errorf("expected %q in:\n%s", expected, result)
+				}
+			}
+		})
+	}
+}
@@ -92,6 +92,15 @@ type Queryable interface {
 	QueryRow(sql string, arguments ...any) Row
 }
 
+// BoolToUint converts a bool to the UInt8 ClickHouse stores flags as.
+func BoolToUint(value bool) uint8 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
 // HashID returns a stable int64 identifier for the provided value.
 func HashID(value string) int64 {
 	hasher := fnv.New64a()
@@ -0,0 +1,254 @@
+// Package finance works out rates of return for investments with money moving
+// in and out of them.
+package finance
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrTooFewValuations is returned when a return can't be worked out because
+// there are fewer than two valuations.
+var ErrTooFewValuations = errors.New("at least two valuations are needed")
+
+// ErrNoRate is returned when no rate of return explains the flows and values
+// of an investment, such as when money was never put in.
+var ErrNoRate = errors.New("no rate of return matches the flows")
+
+// hoursPerYear is the length of a year used for annual rates.
+const hoursPerYear = 365 * 24
+
+// Limits for finding an internal rate of return.
+const (
+	rateTolerance  = 1e-10
+	maxNewtonSteps = 100
+	maxBisectSteps = 300
+	// minRate is just above a total loss, where rates stop making sense.
+	minRate = -0.999999
+	maxRate = 1e10
+)
+
+var one = decimal.NewFromInt(1)
+
+// Valuation is the value of an investment at a time, before any flows at that
+// time.
+type Valuation struct {
+	Time  time.Time
+	Value decimal.Decimal
+}
+
+// Flow is money moved into an investment, or out of it with a negative amount.
+type Flow struct {
+	Time   time.Time
+	Amount decimal.Decimal
+}
+
+// sortFlows returns a copy of flows in the order they happened.
+func sortFlows(flowList []Flow) []Flow {
+	sorted := slices.Clone(flowList)
+
+	slices.SortStableFunc(sorted, func(a, b Flow) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	return sorted
+}
+
+// TimeWeightedReturn returns the return of an investment between its first
+// and last valuations, leaving out the effect of money moved in and out.
+//
+// The time between each pair of valuations is a period, with a return worked
+// out by the Modified Dietz method: flows in the period are weighted by how
+// much of the period they were invested for. The returns of the periods are
+// then chained together. Periods with nothing invested are skipped. Flows
+// before the first valuation or at or after the last are left out.
+//
+// The return is a fraction, so 0.1 is a 10% gain.
+func TimeWeightedReturn(valuationList []Valuation, flowList []Flow) (decimal.Decimal, error) {
+	if len(valuationList) < 2 {
+		return decimal.Zero, ErrTooFewValuations
+	}
+
+	flowList = sortFlows(flowList)
+	growth := one
+	flowIndex := 0
+
+	for i := 1; i < len(valuationList); i++ {
+		start := valuationList[i-1]
+		end := valuationList[i]
+		length := end.Time.Sub(start.Time)
+		net := decimal.Zero
+		weighted := decimal.Zero
+
+		for flowIndex < len(flowList) && flowList[flowIndex].Time.Before(start.Time) {
+			flowIndex++
+		}
+
+		for ; flowIndex < len(flowList) && flowList[flowIndex].Time.Before(end.Time); flowIndex++ {
+			flow := flowList[flowIndex]
+			net = net.Add(flow.Amount)
+
+			if length > 0 {
+				weight := decimal.NewFromInt(int64(end.Time.Sub(flow.Time))).Div(decimal.NewFromInt(int64(length)))
+				weighted = weighted.Add(flow.Amount.Mul(weight))
+			} else {
+				weighted = weighted.Add(flow.Amount)
+			}
+		}
+
+		base := start.Value.Add(weighted)
+
+		if !base.IsPositive() {
+			continue
+		}
+
+		gain := end.Value.Sub(start.Value).Sub(net)
+		growth = growth.Mul(one.Add(gain.Div(base)))
+	}
+
+	return growth.Sub(one), nil
+}
+
+// cashFlow is money paid to or taken from an investor, and how many years
+// after the start it happened.
+type cashFlow struct {
+	years  float64
+	amount float64
+}
+
+// netPresentValue returns the value of cash flows at the start, discounted at
+// an annual rate, with its derivative by the rate.
+func netPresentValue(cashFlowList []cashFlow, rate float64) (float64, float64) {
+	var value, derivative float64
+
+	for _, flow := range cashFlowList {
+		discount := math.Pow(1+rate, -flow.years)
+		value += flow.amount * discount
+		derivative -= flow.years * flow.amount * discount / (1 + rate)
+	}
+
+	return value, derivative
+}
+
+// solveRate finds the annual rate where the net present value of cash flows
+// is zero, with Newton's method, falling back to bisection.
+func solveRate(cashFlowList []cashFlow) (float64, bool) {
+	rate := 0.1
+
+	for range maxNewtonSteps {
+		value, derivative := netPresentValue(cashFlowList, rate)
+
+		if math.Abs(value) < rateTolerance {
+			return rate, true
+		}
+
+		if derivative == 0 || math.IsNaN(derivative) || math.IsInf(derivative, 0) {
+			break
+		}
+
+		next := rate - value/derivative
+
+		if next <= minRate || next > maxRate || math.IsNaN(next) {
+			break
+		}
+
+		if math.Abs(next-rate) < rateTolerance {
+			return next, true
+		}
+
+		rate = next
+	}
+
+	low, high := minRate, 1.0
+	lowValue, _ := netPresentValue(cashFlowList, low)
+	highValue, _ := netPresentValue(cashFlowList, high)
+
+	for math.Signbit(lowValue) == math.Signbit(highValue) {
+		if high >= maxRate {
+			return 0, false
+		}
+
+		high *= 10
+		highValue, _ = netPresentValue(cashFlowList, high)
+	}
+
+	for range maxBisectSteps {
+		middle := (low + high) / 2
+		middleValue, _ := netPresentValue(cashFlowList, middle)
+
+		if math.Abs(middleValue) < rateTolerance || high-low < rateTolerance {
+			return middle, true
+		}
+
+		if math.Signbit(middleValue) == math.Signbit(lowValue) {
+			low, lowValue = middle, middleValue
+		} else {
+			high = middle
+		}
+	}
+
+	return (low + high) / 2, true
+}
+
+// XIRR returns the annual internal rate of return of an investment between
+// two valuations, also known as the money-weighted return.
+//
+// The value at the start counts as money put in at the start, and the value
+// at the end as money taken out at the end. Flows outside of that time are
+// left out. ErrNoRate is returned if the rate can't be worked out, such as
+// when the start and end are at the same time or money was never put in.
+//
+// The rate is a fraction, so 0.1 is a 10% gain a year.
+func XIRR(start Valuation, flowList []Flow, end Valuation) (decimal.Decimal, error) {
+	length := end.Time.Sub(start.Time).Hours() / hoursPerYear
+
+	if length <= 0 {
+		return decimal.Zero, ErrNoRate
+	}
+
+	// Cash flows are from the point of view of the investor, so money put in
+	// is negative.
+	cashFlowList := []cashFlow{{years: 0, amount: -start.Value.InexactFloat64()}}
+
+	for _, flow := range flowList {
+		if flow.Time.Before(start.Time) || !flow.Time.Before(end.Time) {
+			continue
+		}
+
+		cashFlowList = append(cashFlowList, cashFlow{
+			years:  flow.Time.Sub(start.Time).Hours() / hoursPerYear,
+			amount: -flow.Amount.InexactFloat64(),
+		})
+	}
+
+	cashFlowList = append(cashFlowList, cashFlow{years: length, amount: end.Value.InexactFloat64()})
+
+	hasIn := false
+	hasOut := false
+
+	for _, flow := range cashFlowList {
+		hasIn = hasIn || flow.amount < 0
+		hasOut = hasOut || flow.amount > 0
+	}
+
+	if !hasIn {
+		return decimal.Zero, ErrNoRate
+	}
+
+	// Everything put in was lost.
+	if !hasOut {
+		return one.Neg(), nil
+	}
+
+	rate, ok := solveRate(cashFlowList)
+
+	if !ok {
+		return decimal.Zero, ErrNoRate
+	}
+
+	return decimal.NewFromFloat(rate), nil
+}
@@ -0,0 +1,244 @@
+package finance
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func amount(value string) decimal.Decimal {
+	return decimal.RequireFromString(value)
+}
+
+func TestXIRR(t *testing.T) {
+	testCases := []struct {
+		name     string
+		start    Valuation
+		flowList []Flow
+		end      Valuation
+		expected float64
+	}{
+		{
+			// The example from the documentation of XIRR in Excel, which gives
+			// 0.373362535. Money taken out is a negative flow here.
+			name:  "Excel example",
+			start: Valuation{date(2008, time.January, 1), amount("10000")},
+			flowList: []Flow{
+				{date(2008, time.March, 1), amount("-2750")},
+				{date(2008, time.October, 30), amount("-4250")},
+				{date(2009, time.February, 15), amount("-3250")},
+			},
+			end:      Valuation{date(2009, time.April, 1), amount("2750")},
+			expected: 0.373362535,
+		},
+		{
+			name:     "10% over one year",
+			start:    Valuation{date(2023, time.January, 1), amount("1000")},
+			end:      Valuation{date(2024, time.January, 1), amount("1100")},
+			expected: 0.1,
+		},
+		{
+			name:     "Half lost over one year",
+			start:    Valuation{date(2023, time.January, 1), amount("1000")},
+			end:      Valuation{date(2024, time.January, 1), amount("500")},
+			expected: -0.5,
+		},
+		{
+			// XIRR({-1000, -1000, 2200}, {2023-01-01, 2023-07-02, 2024-01-01})
+			name:     "Deposit half way through the year",
+			start:    Valuation{date(2023, time.January, 1), amount("1000")},
+			flowList: []Flow{{date(2023, time.July, 2), amount("1000")}},
+			end:      Valuation{date(2024, time.January, 1), amount("2200")},
+			expected: 0.134626980,
+		},
+		{
+			name:  "Flows outside of the valuations are left out",
+			start: Valuation{date(2023, time.January, 1), amount("1000")},
+			flowList: []Flow{
+				{date(2022, time.June, 1), amount("5000")},
+				{date(2024, time.January, 1), amount("-5000")},
+			},
+			end:      Valuation{date(2024, time.January, 1), amount("1100")},
+			expected: 0.1,
+		},
+		{
+			name:     "Everything lost",
+			start:    Valuation{date(2023, time.January, 1), amount("1000")},
+			end:      Valuation{date(2024, time.January, 1), amount("0")},
+			expected: -1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rate, err := XIRR(testCase.start, testCase.flowList, testCase.end)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if math.Abs(rate.InexactFloat64()-testCase.expected) > 1e-8 {
+				t.Errorf("got %s, expected %v", rate.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func TestXIRRErrNoRate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		start    Valuation
+		flowList []Flow
+		end      Valuation
+	}{
+		{
+			name:  "The start and end are at the same time",
+			start: Valuation{date(2023, time.January, 1), amount("1000")},
+			end:   Valuation{date(2023, time.January, 1), amount("1100")},
+		},
+		{
+			name:  "The end is before the start",
+			start: Valuation{date(2024, time.January, 1), amount("1000")},
+			end:   Valuation{date(2023, time.January, 1), amount("1100")},
+		},
+		{
+			name:  "Money was never put in",
+			start: Valuation{date(2023, time.January, 1), amount("0")},
+			end:   Valuation{date(2024, time.January, 1), amount("100")},
+		},
+		{
+			// The value of the flows is positive at every rate, so there is no
+			// rate where it is zero.
+			name:  "Money was taken out before it was put in",
+			start: Valuation{date(2023, time.January, 1), amount("0")},
+			flowList: []Flow{
+				{date(2023, time.April, 2), amount("-100")},
+				{date(2023, time.July, 2), amount("10")},
+			},
+			end: Valuation{date(2024, time.January, 1), amount("10")},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := XIRR(testCase.start, testCase.flowList, testCase.end)
+
+			if !errors.Is(err, ErrNoRate) {
+				t.Errorf("got %v, expected ErrNoRate", err)
+			}
+		})
+	}
+}
+
+func TestSolveRateFallsBackToBisection(t *testing.T) {
+	// 1000 put in is worth 1 a year later. The first step of Newton's method
+	// from 10% goes below -100%, so the rate is found by bisection.
+	cashFlowList := []cashFlow{
+		{years: 0, amount: -1000},
+		{years: 1, amount: 1},
+	}
+	value, derivative := netPresentValue(cashFlowList, 0.1)
+
+	if next := 0.1 - value/derivative; next > minRate {
+		t.Fatalf("expected Newton's method to leave the range of rates, got %v", next)
+	}
+
+	rate, ok := solveRate(cashFlowList)
+
+	if !ok {
+		t.Fatal("expected a rate")
+	}
+
+	if math.Abs(rate-(-0.999)) > 1e-9 {
+		t.Errorf("got %v, expected -0.999", rate)
+	}
+}
+
+func TestTimeWeightedReturn(t *testing.T) {
+	testCases := []struct {
+		name          string
+		valuationList []Valuation
+		flowList      []Flow
+		expected      string
+	}{
+		{
+			// The deposit is invested for half of the period, so the gain of
+			// 100 is on 1000 + 500 / 2.
+			name: "Deposit in the middle of a period",
+			valuationList: []Valuation{
+				{date(2024, time.January, 1), amount("1000")},
+				{date(2024, time.January, 31), amount("1600")},
+			},
+			flowList: []Flow{{date(2024, time.January, 16), amount("500")}},
+			expected: "0.08",
+		},
+		{
+			// 8% in January, then 10% on 1600 - 300 / 2 in February.
+			name: "Periods are chained together",
+			valuationList: []Valuation{
+				{date(2024, time.January, 1), amount("1000")},
+				{date(2024, time.January, 31), amount("1600")},
+				{date(2024, time.March, 1), amount("1445")},
+			},
+			flowList: []Flow{
+				{date(2024, time.January, 16), amount("500")},
+				{date(2024, time.February, 15), amount("-300")},
+			},
+			expected: "0.188",
+		},
+		{
+			name: "Flows outside of the valuations are left out",
+			valuationList: []Valuation{
+				{date(2024, time.January, 1), amount("1000")},
+				{date(2024, time.January, 31), amount("1100")},
+			},
+			flowList: []Flow{
+				{date(2023, time.December, 1), amount("5000")},
+				{date(2024, time.January, 31), amount("5000")},
+			},
+			expected: "0.1",
+		},
+		{
+			// Nothing is invested in January. The deposit at the start of
+			// February, after the valuation at that time, is invested for the
+			// whole of February.
+			name: "Periods with nothing invested are skipped",
+			valuationList: []Valuation{
+				{date(2024, time.January, 1), amount("0")},
+				{date(2024, time.February, 1), amount("0")},
+				{date(2024, time.March, 1), amount("1100")},
+			},
+			flowList: []Flow{{date(2024, time.February, 1), amount("1000")}},
+			expected: "0.1",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := TimeWeightedReturn(testCase.valuationList, testCase.flowList)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !result.Round(12).Equal(amount(testCase.expected)) {
+				t.Errorf("got %s, expected %s", result.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func TestTimeWeightedReturnTooFewValuations(t *testing.T) {
+	valuationList := []Valuation{{date(2024, time.January, 1), amount("1000")}}
+
+	if _, err := TimeWeightedReturn(valuationList, nil); !errors.Is(err, ErrTooFewValuations) {
+		t.Errorf("got %v, expected ErrTooFewValuations", err)
+	}
+}
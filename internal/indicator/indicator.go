@@ -0,0 +1,229 @@
+// Package indicator computes technical indicators over price series.
+//
+// Every function takes values ordered oldest first, and returns a series
+// where the last entry lines up with the last input value. Series are shorter
+// than their input, as indicators need a full period of values to start.
+package indicator
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// Names of the supported indicators.
+const (
+	SMA = "sma"
+	EMA = "ema"
+	RSI = "rsi"
+)
+
+var hundred = decimal.NewFromInt(100)
+
+// IsValid returns true if a name is a supported indicator.
+func IsValid(name string) bool {
+	return name == SMA || name == EMA || name == RSI
+}
+
+// Lookback returns how many values should be loaded to compute an indicator
+// reliably for the latest value and the value before it.
+//
+// The EMA and RSI depend on every value before them, so more history is
+// loaded to let earlier values settle.
+func Lookback(name string, period int) int {
+	if name == SMA {
+		return period + 1
+	}
+
+	return period*3 + 1
+}
+
+// SimpleMovingAverage returns the mean of every `period` consecutive values.
+//
+// The result has len(valueList) - period + 1 entries.
+func SimpleMovingAverage(valueList []decimal.Decimal, period int) []decimal.Decimal {
+	if period < 1 || len(valueList) < period {
+		return nil
+	}
+
+	divisor := decimal.NewFromInt(int64(period))
+	averageList := make([]decimal.Decimal, 0, len(valueList)-period+1)
+	sum := decimal.Zero
+
+	for i, value := range valueList {
+		sum = sum.Add(value)
+
+		if i >= period {
+			sum = sum.Sub(valueList[i-period])
+		}
+
+		if i >= period-1 {
+			averageList = append(averageList, sum.Div(divisor))
+		}
+	}
+
+	return averageList
+}
+
+// ExponentialMovingAverage returns the EMA of values with a smoothing factor
+// of 2 / (period + 1), seeded with the simple average of the first period.
+//
+// The result has len(valueList) - period + 1 entries.
+func ExponentialMovingAverage(valueList []decimal.Decimal, period int) []decimal.Decimal {
+	if period < 1 || len(valueList) < period {
+		return nil
+	}
+
+	alpha := decimal.NewFromInt(2).Div(decimal.NewFromInt(int64(period + 1)))
+	averageList := make([]decimal.Decimal, 0, len(valueList)-period+1)
+	average := SimpleMovingAverage(valueList[:period], period)[0]
+	averageList = append(averageList, average)
+
+	for _, value := range valueList[period:] {
+		average = value.Sub(average).Mul(alpha).Add(average)
+		averageList = append(averageList, average)
+	}
+
+	return averageList
+}
+
+// RelativeStrengthIndex returns the RSI of values using Wilder's smoothing.
+//
+// The result has len(valueList) - period entries, from 0 to 100.
+func RelativeStrengthIndex(valueList []decimal.Decimal, period int) []decimal.Decimal {
+	if period < 1 || len(valueList) <= period {
+		return nil
+	}
+
+	divisor := decimal.NewFromInt(int64(period))
+	smoothing := decimal.NewFromInt(int64(period - 1))
+	averageGain := decimal.Zero
+	averageLoss := decimal.Zero
+	rsiList := make([]decimal.Decimal, 0, len(valueList)-period)
+
+	for i := 1; i < len(valueList); i++ {
+		change := valueList[i].Sub(valueList[i-1])
+		gain := decimal.Max(change, decimal.Zero)
+		loss := decimal.Max(change.Neg(), decimal.Zero)
+
+		if i <= period {
+			// The first averages are the simple averages of the first period.
+			averageGain = averageGain.Add(gain.Div(divisor))
+			averageLoss = averageLoss.Add(loss.Div(divisor))
+		} else {
+			averageGain = averageGain.Mul(smoothing).Add(gain).Div(divisor)
+			averageLoss = averageLoss.Mul(smoothing).Add(loss).Div(divisor)
+		}
+
+		if i >= period {
+			rsiList = append(rsiList, relativeStrength(averageGain, averageLoss))
+		}
+	}
+
+	return rsiList
+}
+
+func relativeStrength(averageGain decimal.Decimal, averageLoss decimal.Decimal) decimal.Decimal {
+	if averageLoss.IsZero() {
+		if averageGain.IsZero() {
+			return decimal.NewFromInt(50)
+		}
+
+		return hundred
+	}
+
+	return hundred.Sub(hundred.Div(averageGain.Div(averageLoss).Add(decimal.NewFromInt(1))))
+}
+
+// Crossings returns whether each value in a series of closing prices just
+// crossed an indicator, going above it or below it.
+//
+// Prices cross a moving average when a price is on the other side of the
+// average from the price before it. For the RSI, the RSI itself crosses the
+// threshold, such as rising above 70 or falling below 30. The result has an
+// entry for every closing price, and the indicator is computed only once.
+func Crossings(
+	name string,
+	period int,
+	above bool,
+	threshold decimal.Decimal,
+	closeList []decimal.Decimal,
+) []bool {
+	crossedList := make([]bool, len(closeList))
+	var valueList, lineList []decimal.Decimal
+
+	switch name {
+	case SMA, EMA:
+		if name == SMA {
+			lineList = SimpleMovingAverage(closeList, period)
+		} else {
+			lineList = ExponentialMovingAverage(closeList, period)
+		}
+
+		// Line up the prices with the averages, which start a period later.
+		valueList = closeList[len(closeList)-len(lineList):]
+	case RSI:
+		valueList = RelativeStrengthIndex(closeList, period)
+		lineList = make([]decimal.Decimal, len(valueList))
+
+		for i := range lineList {
+			lineList[i] = threshold
+		}
+	default:
+		return crossedList
+	}
+
+	offset := len(closeList) - len(valueList)
+
+	for i := 1; i < len(valueList); i++ {
+		previous, current := valueList[i-1], valueList[i]
+		previousLine, currentLine := lineList[i-1], lineList[i]
+
+		if above {
+			crossedList[offset+i] = previous.LessThanOrEqual(previousLine) && current.GreaterThan(currentLine)
+		} else {
+			crossedList[offset+i] = previous.GreaterThanOrEqual(previousLine) && current.LessThan(currentLine)
+		}
+	}
+
+	return crossedList
+}
+
+// Crossed returns true if the latest value in a series of closing prices just
+// crossed an indicator, as defined by Crossings.
+func Crossed(
+	name string,
+	period int,
+	above bool,
+	threshold decimal.Decimal,
+	closeList []decimal.Decimal,
+) bool {
+	crossedList := Crossings(name, period, above, threshold, closeList)
+
+	return len(crossedList) > 0 && crossedList[len(crossedList)-1]
+}
+
+// BandExits returns whether each value in a series of closing prices just
+// took the RSI out of a band, rising above upper or falling below lower.
+//
+// The result has an entry for every closing price.
+func BandExits(period int, lower decimal.Decimal, upper decimal.Decimal, closeList []decimal.Decimal) []bool {
+	exitList := make([]bool, len(closeList))
+	valueList := RelativeStrengthIndex(closeList, period)
+	offset := len(closeList) - len(valueList)
+
+	for i := 1; i < len(valueList); i++ {
+		previous, current := valueList[i-1], valueList[i]
+
+		exitList[offset+i] = (previous.LessThanOrEqual(upper) && current.GreaterThan(upper)) ||
+			(previous.GreaterThanOrEqual(lower) && current.LessThan(lower))
+	}
+
+	return exitList
+}
+
+// LeftBand returns true if the latest value in a series of closing prices
+// just took the RSI out of a band, as defined by BandExits.
+func LeftBand(period int, lower decimal.Decimal, upper decimal.Decimal, closeList []decimal.Decimal) bool {
+	exitList := BandExits(period, lower, upper, closeList)
+
+	return len(exitList) > 0 && exitList[len(exitList)-1]
+}
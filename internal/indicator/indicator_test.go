@@ -0,0 +1,322 @@
+package indicator
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func decimalList(valueList ...float64) []decimal.Decimal {
+	result := make([]decimal.Decimal, len(valueList))
+
+	for i, value := range valueList {
+		result[i] = decimal.NewFromFloat(value)
+	}
+
+	return result
+}
+
+// roundedList formats values to two places, as reference tables are printed.
+func roundedList(valueList []decimal.Decimal) []string {
+	result := make([]string, len(valueList))
+
+	for i, value := range valueList {
+		result[i] = value.StringFixed(2)
+	}
+
+	return result
+}
+
+// movingAverageCloses is the 10 day moving average example from the
+// StockCharts ChartSchool spreadsheet.
+var movingAverageCloses = decimalList(
+	22.27, 22.19, 22.08, 22.17, 22.18, 22.13, 22.23, 22.43, 22.24, 22.29,
+	22.15, 22.39, 22.38, 22.61, 23.36, 24.05, 23.75, 23.83, 23.95, 23.63,
+	23.82, 23.87, 23.65, 23.19, 23.10, 23.33, 22.68, 23.10, 22.40, 22.17,
+)
+
+// rsiCloses is the 14 day RSI example from Wilder's book, as used in the
+// StockCharts ChartSchool spreadsheet.
+var rsiCloses = decimalList(
+	44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08,
+	45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64,
+	46.21, 46.25, 45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57,
+	43.42, 42.66, 43.13,
+)
+
+func TestSimpleMovingAverage(t *testing.T) {
+	testCases := []struct {
+		name      string
+		valueList []decimal.Decimal
+		period    int
+		expected  []string
+	}{
+		{
+			name:      "reference series",
+			valueList: movingAverageCloses,
+			period:    10,
+			expected: []string{
+				"22.22", "22.21", "22.23", "22.26", "22.30", "22.42", "22.61",
+				"22.77", "22.91", "23.08", "23.21", "23.38", "23.53", "23.65",
+				"23.71", "23.68", "23.61", "23.51", "23.43", "23.28", "23.13",
+			},
+		},
+		{
+			name:      "period of one",
+			valueList: decimalList(1, 2, 3),
+			period:    1,
+			expected:  []string{"1.00", "2.00", "3.00"},
+		},
+		{
+			name:      "too few values",
+			valueList: decimalList(1, 2),
+			period:    3,
+			expected:  []string{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := roundedList(SimpleMovingAverage(testCase.valueList, testCase.period))
+
+			if !slices.Equal(result, testCase.expected) {
+				t.Errorf("got %v, expected %v", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestExponentialMovingAverage(t *testing.T) {
+	expected := []string{
+		"22.22", "22.21", "22.24", "22.27", "22.33", "22.52", "22.80",
+		"22.97", "23.13", "23.28", "23.34", "23.43", "23.51", "23.53",
+		"23.47", "23.40", "23.39", "23.26", "23.23", "23.08", "22.92",
+	}
+	result := roundedList(ExponentialMovingAverage(movingAverageCloses, 10))
+
+	if !slices.Equal(result, expected) {
+		t.Errorf("got %v, expected %v", result, expected)
+	}
+}
+
+func TestRelativeStrengthIndex(t *testing.T) {
+	// The spreadsheet rounds the average gain and loss before computing the
+	// RSI, so its table is a few hundredths higher than exact values.
+	expected := []string{
+		"70.46", "66.25", "66.48", "69.35", "66.29", "57.92", "62.88",
+		"63.21", "56.01", "62.34", "54.67", "50.39", "40.02", "41.49",
+		"41.90", "45.50", "37.32", "33.09", "37.79",
+	}
+	result := roundedList(RelativeStrengthIndex(rsiCloses, 14))
+
+	if !slices.Equal(result, expected) {
+		t.Errorf("got %v, expected %v", result, expected)
+	}
+}
+
+func TestRelativeStrengthIndexWithoutChanges(t *testing.T) {
+	testCases := []struct {
+		name      string
+		valueList []decimal.Decimal
+		expected  []string
+	}{
+		{"flat prices", decimalList(5, 5, 5, 5), []string{"50.00", "50.00"}},
+		{"only gains", decimalList(1, 2, 3, 4), []string{"100.00", "100.00"}},
+		{"only losses", decimalList(4, 3, 2, 1), []string{"0.00", "0.00"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := roundedList(RelativeStrengthIndex(testCase.valueList, 2))
+
+			if !slices.Equal(result, testCase.expected) {
+				t.Errorf("got %v, expected %v", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestCrossings(t *testing.T) {
+	testCases := []struct {
+		name      string
+		indicator string
+		period    int
+		above     bool
+		threshold decimal.Decimal
+		closeList []decimal.Decimal
+		expected  []bool
+	}{
+		{
+			// The SMA is 2, 2, 2.5 and 3 from the second price.
+			name:      "price rises above the SMA",
+			indicator: SMA,
+			period:    2,
+			above:     true,
+			closeList: decimalList(2, 2, 2, 3, 3),
+			expected:  []bool{false, false, false, true, false},
+		},
+		{
+			name:      "price falls below the SMA",
+			indicator: SMA,
+			period:    2,
+			above:     false,
+			closeList: decimalList(3, 3, 3, 2, 2),
+			expected:  []bool{false, false, false, true, false},
+		},
+		{
+			name:      "rising doesn't count as falling",
+			indicator: SMA,
+			period:    2,
+			above:     false,
+			closeList: decimalList(2, 2, 2, 3, 3),
+			expected:  []bool{false, false, false, false, false},
+		},
+		{
+			// The EMA is 2, 2, 2.67 and 2.89 from the second price.
+			name:      "price rises above the EMA",
+			indicator: EMA,
+			period:    2,
+			above:     true,
+			closeList: decimalList(2, 2, 2, 3, 3),
+			expected:  []bool{false, false, false, true, false},
+		},
+		{
+			// The reference RSI falls from 45.50 to 37.32 at the 31st price.
+			name:      "RSI falls below a threshold",
+			indicator: RSI,
+			period:    14,
+			above:     false,
+			threshold: decimal.NewFromInt(40),
+			closeList: rsiCloses,
+			expected:  append(make([]bool, 30), true, false, false),
+		},
+		{
+			// The reference RSI rises above 60 at the 21st and 24th prices.
+			name:      "RSI rises above a threshold",
+			indicator: RSI,
+			period:    14,
+			above:     true,
+			threshold: decimal.NewFromInt(60),
+			closeList: rsiCloses,
+			expected: append(
+				make([]bool, 20),
+				true, false, false, true, false, false, false,
+				false, false, false, false, false, false,
+			),
+		},
+		{
+			name:      "too few prices",
+			indicator: SMA,
+			period:    5,
+			above:     true,
+			closeList: decimalList(1, 2, 3),
+			expected:  []bool{false, false, false},
+		},
+		{
+			name:      "unknown indicator",
+			indicator: "macd",
+			period:    2,
+			above:     true,
+			closeList: decimalList(2, 2, 3),
+			expected:  []bool{false, false, false},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := Crossings(
+				testCase.indicator,
+				testCase.period,
+				testCase.above,
+				testCase.threshold,
+				testCase.closeList,
+			)
+
+			if !slices.Equal(result, testCase.expected) {
+				t.Errorf("got %v, expected %v", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestCrossed(t *testing.T) {
+	closeList := decimalList(2, 2, 2, 3)
+
+	if !Crossed(SMA, 2, true, decimal.Zero, closeList) {
+		t.Error("expected the last price to cross above the SMA")
+	}
+
+	if Crossed(SMA, 2, true, decimal.Zero, closeList[:3]) {
+		t.Error("expected flat prices not to cross the SMA")
+	}
+
+	if Crossed(SMA, 2, true, decimal.Zero, nil) {
+		t.Error("expected no prices not to cross the SMA")
+	}
+}
+
+func TestBandExits(t *testing.T) {
+	testCases := []struct {
+		name      string
+		lower     decimal.Decimal
+		upper     decimal.Decimal
+		closeList []decimal.Decimal
+		expected  []bool
+	}{
+		{
+			// The reference RSI rises above 60 at the 21st and 24th prices,
+			// and falls below 40 at the 31st price.
+			name:      "RSI leaves the band both ways",
+			lower:     decimal.NewFromInt(40),
+			upper:     decimal.NewFromInt(60),
+			closeList: rsiCloses,
+			expected: append(
+				make([]bool, 20),
+				true, false, false, true, false, false, false,
+				false, false, false, true, false, false,
+			),
+		},
+		{
+			name:      "RSI stays inside the band",
+			lower:     decimal.NewFromInt(20),
+			upper:     decimal.NewFromInt(80),
+			closeList: rsiCloses,
+			expected:  make([]bool, len(rsiCloses)),
+		},
+		{
+			name:      "too few prices",
+			lower:     decimal.NewFromInt(30),
+			upper:     decimal.NewFromInt(70),
+			closeList: decimalList(1, 2, 3),
+			expected:  []bool{false, false, false},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := BandExits(14, testCase.lower, testCase.upper, testCase.closeList)
+
+			if !slices.Equal(result, testCase.expected) {
+				t.Errorf("got %v, expected %v", result, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestLeftBand(t *testing.T) {
+	lower := decimal.NewFromInt(40)
+	upper := decimal.NewFromInt(60)
+
+	if !LeftBand(14, lower, upper, rsiCloses[:31]) {
+		t.Error("expected the last price to take the RSI below the band")
+	}
+
+	if LeftBand(14, lower, upper, rsiCloses[:30]) {
+		t.Error("expected the RSI to stay inside the band")
+	}
+
+	if LeftBand(14, lower, upper, nil) {
+		t.Error("expected no prices not to leave the band")
+	}
+}
@@ -0,0 +1,342 @@
+// Package ledger replays the transactions in a portfolio to work out how much
+// of an asset is held and what it cost.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// Kinds of transactions.
+const (
+	Buy  = "buy"
+	Sell = "sell"
+	// Deposit moves an asset into the portfolio from elsewhere, at a cost.
+	Deposit = "deposit"
+	// Withdrawal moves an asset out of the portfolio without selling it.
+	Withdrawal = "withdrawal"
+)
+
+// KindList lists every kind of transaction.
+var KindList = []string{Buy, Sell, Deposit, Withdrawal}
+
+// Cost basis methods, which choose the lots an asset is removed from.
+const (
+	// Average removes from every lot in proportion to its size, so each unit
+	// removed costs the average cost of the holding.
+	Average = "average"
+	// FIFO removes from the earliest lots first.
+	FIFO = "fifo"
+	// LIFO removes from the latest lots first.
+	LIFO = "lifo"
+	// HIFO removes from the lots with the highest cost per unit first.
+	HIFO = "hifo"
+)
+
+// MethodList lists every cost basis method.
+var MethodList = []string{Average, FIFO, LIFO, HIFO}
+
+// MethodNames are the names of cost basis methods shown to users.
+var MethodNames = map[string]string{
+	Average: "Average cost",
+	FIFO:    "First in, first out",
+	LIFO:    "Last in, first out",
+	HIFO:    "Highest in, first out",
+}
+
+// ErrInsufficientAmount is returned when more of an asset is removed than is
+// held at the time.
+var ErrInsufficientAmount = errors.New("more is removed than is held")
+
+// IsValidKind returns true for a kind of transaction in KindList.
+func IsValidKind(kind string) bool {
+	return slices.Contains(KindList, kind)
+}
+
+// IsValidMethod returns true for a cost basis method in MethodList.
+func IsValidMethod(method string) bool {
+	return slices.Contains(MethodList, method)
+}
+
+// Lot is an amount of an asset acquired in one transaction that is still held.
+type Lot struct {
+	// ID is the ID of the transaction that acquired the lot.
+	ID   int64
+	Time time.Time
+	// Amount is the amount of the lot that is still held.
+	Amount decimal.Decimal
+	// Cost is the cost of acquiring the amount still held.
+	Cost decimal.Decimal
+}
+
+// UnitCost returns the cost of each unit in a lot.
+func (lot Lot) UnitCost() decimal.Decimal {
+	if lot.Amount.IsZero() {
+		return decimal.Zero
+	}
+
+	return lot.Cost.Div(lot.Amount)
+}
+
+// Disposal is a sale of part of a holding, which realizes a gain or loss.
+type Disposal struct {
+	// ID is the ID of the sell transaction.
+	ID     int64
+	Time   time.Time
+	Amount decimal.Decimal
+	// Proceeds is what the sale earned after fees.
+	Proceeds decimal.Decimal
+	// Cost is the cost of the lots the sale was taken from.
+	Cost decimal.Decimal
+}
+
+// Gain returns the gain realized by a disposal, which is negative for a loss.
+func (disposal Disposal) Gain() decimal.Decimal {
+	return disposal.Proceeds.Sub(disposal.Cost)
+}
+
+// Holding is an amount of an asset, the cost of acquiring it, and the lots
+// that make it up in the order they were acquired.
+//
+// DisposalList lists every sale made while replaying transactions.
+type Holding struct {
+	Amount       decimal.Decimal
+	Cost         decimal.Decimal
+	LotList      []Lot
+	DisposalList []Disposal
+}
+
+// Total returns the value of a transaction before fees.
+func Total(transaction *model.Transaction) decimal.Decimal {
+	return transaction.Quantity.Mul(transaction.Price)
+}
+
+// IsAssetFee returns true if the fee for a transaction was paid in the asset.
+func IsAssetFee(transaction *model.Transaction) bool {
+	return transaction.FeeCurrency != "" && transaction.FeeCurrency == transaction.Currency.Ticker
+}
+
+// FiatFee returns the fee for a transaction paid in the portfolio currency.
+func FiatFee(transaction *model.Transaction) decimal.Decimal {
+	if IsAssetFee(transaction) {
+		return decimal.Zero
+	}
+
+	return transaction.Fee
+}
+
+// FeeValue returns the value of the fee for a transaction in the portfolio
+// currency, valuing fees paid in the asset at the price of the transaction.
+func FeeValue(transaction *model.Transaction) decimal.Decimal {
+	if IsAssetFee(transaction) {
+		return transaction.Fee.Mul(transaction.Price)
+	}
+
+	return transaction.Fee
+}
+
+// Amount returns the amount of the asset a transaction adds to or removes from
+// a holding.
+//
+// Fees paid in the asset come out of the amount bought or deposited, and on
+// top of the amount sold or withdrawn.
+func Amount(transaction *model.Transaction) decimal.Decimal {
+	if !IsAssetFee(transaction) {
+		return transaction.Quantity
+	}
+
+	switch transaction.Kind {
+	case Buy, Deposit:
+		return transaction.Quantity.Sub(transaction.Fee)
+	}
+
+	return transaction.Quantity.Add(transaction.Fee)
+}
+
+// Cost returns the cost of acquiring an asset in a buy or deposit.
+//
+// Fees paid in fiat add to the cost of buying.
+func Cost(transaction *model.Transaction) decimal.Decimal {
+	if transaction.Kind == Buy {
+		return Total(transaction).Add(FiatFee(transaction))
+	}
+
+	return Total(transaction)
+}
+
+// Proceeds returns what a sale earned after fees paid in fiat.
+func Proceeds(transaction *model.Transaction) decimal.Decimal {
+	return Total(transaction).Sub(FiatFee(transaction))
+}
+
+// CashChange returns the change in portfolio cash from a transaction.
+//
+// Buying spends cash, selling earns cash, and fees paid in fiat are paid from
+// cash. Deposits and withdrawals move assets without touching cash.
+func CashChange(transaction *model.Transaction) decimal.Decimal {
+	switch transaction.Kind {
+	case Buy:
+		return Cost(transaction).Neg()
+	case Sell:
+		return Proceeds(transaction)
+	}
+
+	return decimal.Zero
+}
+
+// Sort sorts transactions into the order they happened, keeping the order of
+// transactions at the same time.
+func Sort(transactionList []model.Transaction) {
+	slices.SortStableFunc(transactionList, func(a, b model.Transaction) int {
+		return a.Time.Compare(b.Time)
+	})
+}
+
+// lotOrder returns the order in which lots are removed for a cost basis method.
+func lotOrder(lotList []Lot, method string) []int {
+	order := make([]int, len(lotList))
+
+	for i := range order {
+		order[i] = i
+	}
+
+	switch method {
+	case LIFO:
+		slices.Reverse(order)
+	case HIFO:
+		slices.SortStableFunc(order, func(a, b int) int {
+			return lotList[b].UnitCost().Cmp(lotList[a].UnitCost())
+		})
+	}
+
+	return order
+}
+
+// takeFromLot removes an amount from a lot and returns the cost removed.
+func takeFromLot(lot *Lot, amount decimal.Decimal) decimal.Decimal {
+	if amount.GreaterThanOrEqual(lot.Amount) {
+		cost := lot.Cost
+		lot.Amount = decimal.Zero
+		lot.Cost = decimal.Zero
+
+		return cost
+	}
+
+	cost := lot.Cost.Mul(amount).Div(lot.Amount)
+	lot.Amount = lot.Amount.Sub(amount)
+	lot.Cost = lot.Cost.Sub(cost)
+
+	return cost
+}
+
+// remove removes an amount from a holding using a cost basis method, and
+// returns the cost of the amount removed.
+//
+// The amount must not be more than the holding.
+func (holding *Holding) remove(amount decimal.Decimal, method string) decimal.Decimal {
+	removedCost := decimal.Zero
+	remaining := amount
+
+	if method == Average && amount.LessThan(holding.Amount) {
+		// Take the same share of every lot, with the last lot taking whatever
+		// is left after rounding.
+		for i := range holding.LotList {
+			take := remaining
+
+			if i < len(holding.LotList)-1 {
+				take = holding.LotList[i].Amount.Mul(amount).Div(holding.Amount)
+			}
+
+			take = decimal.Min(take, remaining, holding.LotList[i].Amount)
+			removedCost = removedCost.Add(takeFromLot(&holding.LotList[i], take))
+			remaining = remaining.Sub(take)
+		}
+	} else {
+		for _, i := range lotOrder(holding.LotList, method) {
+			if !remaining.IsPositive() {
+				break
+			}
+
+			take := decimal.Min(remaining, holding.LotList[i].Amount)
+			removedCost = removedCost.Add(takeFromLot(&holding.LotList[i], take))
+			remaining = remaining.Sub(take)
+		}
+	}
+
+	holding.LotList = slices.DeleteFunc(holding.LotList, func(lot Lot) bool {
+		return lot.Amount.IsZero()
+	})
+	holding.Amount = holding.Amount.Sub(amount)
+	holding.Cost = decimal.Zero
+
+	for _, lot := range holding.LotList {
+		holding.Cost = holding.Cost.Add(lot.Cost)
+	}
+
+	return removedCost
+}
+
+// Replay works out the holding after a list of sorted transactions, removing
+// from lots with a cost basis method.
+//
+// Buying adds a lot costing the total and any fee paid in fiat. Deposits add
+// a lot costing the total, so a deposit priced at zero adds an asset at no cost.
+func Replay(transactionList []model.Transaction, method string) (Holding, error) {
+	holding := Holding{Amount: decimal.Zero, Cost: decimal.Zero}
+
+	if !IsValidMethod(method) {
+		return holding, fmt.Errorf("unknown cost basis method: %s", method)
+	}
+
+	for _, transaction := range transactionList {
+		switch transaction.Kind {
+		case Buy, Deposit:
+			amount := Amount(&transaction)
+			cost := Cost(&transaction)
+
+			holding.Amount = holding.Amount.Add(amount)
+			holding.Cost = holding.Cost.Add(cost)
+			holding.LotList = append(holding.LotList, Lot{
+				ID:     transaction.ID,
+				Time:   transaction.Time,
+				Amount: amount,
+				Cost:   cost,
+			})
+		case Sell, Withdrawal:
+			amount := Amount(&transaction)
+
+			if amount.GreaterThan(holding.Amount) {
+				return holding, fmt.Errorf(
+					"%w: %s %s of %s on %s",
+					ErrInsufficientAmount,
+					transaction.Kind,
+					transaction.Quantity.String(),
+					transaction.Currency.Ticker,
+					transaction.Time.UTC().Format("2006-01-02 15:04"),
+				)
+			}
+
+			cost := holding.remove(amount, method)
+
+			// Withdrawals move an asset elsewhere, so they don't realize a gain.
+			if transaction.Kind == Sell {
+				holding.DisposalList = append(holding.DisposalList, Disposal{
+					ID:       transaction.ID,
+					Time:     transaction.Time,
+					Amount:   amount,
+					Proceeds: Proceeds(&transaction),
+					Cost:     cost,
+				})
+			}
+		default:
+			return holding, fmt.Errorf("unknown transaction kind: %s", transaction.Kind)
+		}
+	}
+
+	return holding, nil
+}
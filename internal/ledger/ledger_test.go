@@ -0,0 +1,250 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// newTransaction returns a transaction for BTC on a day of January 2024.
+func newTransaction(id int64, kind string, day int, quantity string, price string) model.Transaction {
+	return model.Transaction{
+		ID:       id,
+		Currency: model.Currency{Ticker: "BTC", Name: "Bitcoin"},
+		Kind:     kind,
+		Time:     time.Date(2024, time.January, day, 0, 0, 0, 0, time.UTC),
+		Quantity: decimal.RequireFromString(quantity),
+		Price:    decimal.RequireFromString(price),
+		Fee:      decimal.Zero,
+	}
+}
+
+// expectedLot is the amount and cost expected for a lot still held.
+type expectedLot struct {
+	id     int64
+	amount string
+	cost   string
+}
+
+func checkDecimal(t *testing.T, name string, actual decimal.Decimal, expected string) {
+	t.Helper()
+
+	if !actual.Equal(decimal.RequireFromString(expected)) {
+		t.Errorf("%s: got %s, expected %s", name, actual.String(), expected)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	// Lots of 1 at 100, then 2 at 200.
+	rising := []model.Transaction{
+		newTransaction(1, Buy, 1, "1", "100"),
+		newTransaction(2, Buy, 2, "2", "200"),
+	}
+	// Lots of 1 at 300, 1 at 100 and 1 at 200.
+	mixed := []model.Transaction{
+		newTransaction(1, Buy, 1, "1", "300"),
+		newTransaction(2, Buy, 2, "1", "100"),
+		newTransaction(3, Deposit, 3, "1", "200"),
+	}
+	// Three equal lots, which can't be split into exact thirds.
+	thirds := []model.Transaction{
+		newTransaction(1, Buy, 1, "1", "10"),
+		newTransaction(2, Buy, 2, "1", "10"),
+		newTransaction(3, Buy, 3, "1", "10"),
+	}
+
+	testCases := []struct {
+		name         string
+		method       string
+		transactions []model.Transaction
+		sell         model.Transaction
+		disposalCost string
+		lotList      []expectedLot
+	}{
+		{
+			name:         "FIFO takes part of the first lot",
+			method:       FIFO,
+			transactions: rising,
+			sell:         newTransaction(9, Sell, 9, "0.5", "400"),
+			disposalCost: "50",
+			lotList:      []expectedLot{{1, "0.5", "50"}, {2, "2", "400"}},
+		},
+		{
+			name:         "FIFO spans lots",
+			method:       FIFO,
+			transactions: rising,
+			sell:         newTransaction(9, Sell, 9, "1.5", "400"),
+			disposalCost: "200",
+			lotList:      []expectedLot{{2, "1.5", "300"}},
+		},
+		{
+			name:         "LIFO takes part of the last lot",
+			method:       LIFO,
+			transactions: rising,
+			sell:         newTransaction(9, Sell, 9, "0.5", "400"),
+			disposalCost: "100",
+			lotList:      []expectedLot{{1, "1", "100"}, {2, "1.5", "300"}},
+		},
+		{
+			name:         "LIFO spans lots",
+			method:       LIFO,
+			transactions: rising,
+			sell:         newTransaction(9, Sell, 9, "2.5", "400"),
+			disposalCost: "450",
+			lotList:      []expectedLot{{1, "0.5", "50"}},
+		},
+		{
+			name:         "HIFO takes part of the most expensive lot",
+			method:       HIFO,
+			transactions: mixed,
+			sell:         newTransaction(9, Sell, 9, "0.5", "400"),
+			disposalCost: "150",
+			lotList:      []expectedLot{{1, "0.5", "150"}, {2, "1", "100"}, {3, "1", "200"}},
+		},
+		{
+			name:         "HIFO spans lots from the highest cost down",
+			method:       HIFO,
+			transactions: mixed,
+			sell:         newTransaction(9, Sell, 9, "1.5", "400"),
+			disposalCost: "400",
+			lotList:      []expectedLot{{2, "1", "100"}, {3, "0.5", "100"}},
+		},
+		{
+			name:         "Average takes the same share of every lot",
+			method:       Average,
+			transactions: rising,
+			sell:         newTransaction(9, Sell, 9, "1.5", "400"),
+			disposalCost: "250",
+			lotList:      []expectedLot{{1, "0.5", "50"}, {2, "1", "200"}},
+		},
+		{
+			name:         "Average leaves the rounding remainder to the last lot",
+			method:       Average,
+			transactions: thirds,
+			sell:         newTransaction(9, Sell, 9, "1", "20"),
+			disposalCost: "10",
+			lotList: []expectedLot{
+				{1, "0.6666666666666667", "6.666666666666667"},
+				{2, "0.6666666666666667", "6.666666666666667"},
+				{3, "0.6666666666666666", "6.666666666666666"},
+			},
+		},
+		{
+			name:         "Average sells the whole holding",
+			method:       Average,
+			transactions: rising,
+			sell:         newTransaction(9, Sell, 9, "3", "400"),
+			disposalCost: "500",
+			lotList:      []expectedLot{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			transactionList := append(
+				append([]model.Transaction{}, testCase.transactions...),
+				testCase.sell,
+			)
+			holding, err := Replay(transactionList, testCase.method)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(holding.DisposalList) != 1 {
+				t.Fatalf("got %d disposals, expected 1", len(holding.DisposalList))
+			}
+
+			disposal := holding.DisposalList[0]
+			checkDecimal(t, "disposal amount", disposal.Amount, testCase.sell.Quantity.String())
+			checkDecimal(t, "disposal cost", disposal.Cost, testCase.disposalCost)
+
+			if len(holding.LotList) != len(testCase.lotList) {
+				t.Fatalf("got %d lots, expected %d", len(holding.LotList), len(testCase.lotList))
+			}
+
+			amount := decimal.Zero
+			cost := decimal.Zero
+
+			for i, expected := range testCase.lotList {
+				lot := holding.LotList[i]
+
+				if lot.ID != expected.id {
+					t.Errorf("lot %d: got ID %d, expected %d", i, lot.ID, expected.id)
+				}
+
+				checkDecimal(t, "lot amount", lot.Amount, expected.amount)
+				checkDecimal(t, "lot cost", lot.Cost, expected.cost)
+				amount = amount.Add(lot.Amount)
+				cost = cost.Add(lot.Cost)
+			}
+
+			// The lots must always add up to the holding exactly.
+			checkDecimal(t, "holding amount", holding.Amount, amount.String())
+			checkDecimal(t, "holding cost", holding.Cost, cost.String())
+		})
+	}
+}
+
+func TestReplayFees(t *testing.T) {
+	buy := newTransaction(1, Buy, 1, "2", "100")
+	buy.Fee = decimal.RequireFromString("0.1")
+	buy.FeeCurrency = "BTC"
+	sell := newTransaction(2, Sell, 2, "1", "150")
+	sell.Fee = decimal.RequireFromString("5")
+	sell.FeeCurrency = "USD"
+
+	holding, err := Replay([]model.Transaction{buy, sell}, FIFO)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The fee in BTC comes out of the amount bought, so each unit cost more.
+	checkDecimal(t, "holding amount", holding.Amount, "0.9")
+	checkDecimal(t, "disposal proceeds", holding.DisposalList[0].Proceeds, "145")
+	checkDecimal(t, "disposal cost", holding.DisposalList[0].Cost.Round(8), "105.26315789")
+}
+
+func TestReplayWithdrawalIsNotADisposal(t *testing.T) {
+	transactionList := []model.Transaction{
+		newTransaction(1, Buy, 1, "2", "100"),
+		newTransaction(2, Withdrawal, 2, "1", "100"),
+	}
+	holding, err := Replay(transactionList, FIFO)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(holding.DisposalList) != 0 {
+		t.Errorf("got %d disposals, expected none", len(holding.DisposalList))
+	}
+
+	checkDecimal(t, "holding amount", holding.Amount, "1")
+	checkDecimal(t, "holding cost", holding.Cost, "100")
+}
+
+func TestReplayErrors(t *testing.T) {
+	oversold := []model.Transaction{
+		newTransaction(1, Buy, 1, "1", "100"),
+		newTransaction(2, Sell, 2, "1.5", "100"),
+	}
+
+	if _, err := Replay(oversold, FIFO); !errors.Is(err, ErrInsufficientAmount) {
+		t.Errorf("got %v, expected ErrInsufficientAmount", err)
+	}
+
+	if _, err := Replay(nil, "random"); err == nil {
+		t.Error("expected an error for an unknown cost basis method")
+	}
+
+	unknown := []model.Transaction{newTransaction(1, "gift", 1, "1", "100")}
+
+	if _, err := Replay(unknown, FIFO); err == nil {
+		t.Error("expected an error for an unknown transaction kind")
+	}
+}
@@ -36,12 +36,53 @@ type Alert struct {
 	Above bool
 	Time  time.Time
 	Sent  bool
+	// ActiveFrom is when the alert is armed, or nil to arm it immediately.
+	ActiveFrom *time.Time
+	// ExpiresAt is when the alert is retired, or nil to never retire it.
+	ExpiresAt *time.Time
+	// Note is free text included in notifications for the alert.
+	Note   string
+	Labels []string
+	// LadderID is the ID of the Ladder the alert is a rung of, or 0.
+	LadderID int64
+	// Indicator is the indicator to alert on, or "" to alert on the price.
+	//
+	// Moving average alerts fire when the price crosses the average, and RSI
+	// alerts fire when the RSI crosses Value, or leaves the band from Value
+	// to Upper when Upper is set.
+	Indicator string
+	// Period is the number of days the indicator is computed over.
+	Period int
+	// Upper is the top of the band for RSI band alerts, or 0 for other alerts.
+	Upper decimal.Decimal
+	// Rule is how prices are compared with Value, as defined by the backtest
+	// package. Percent and trailing rules take Value as a percentage.
+	Rule string
+	// Recurring alerts are armed again after they are sent.
+	Recurring bool
+}
+
+// Ladder is a set of alerts at regular steps between two prices
+type Ladder struct {
+	ID   int64
+	From Currency
+	To   Currency
+	Low  decimal.Decimal
+	High decimal.Decimal
+	Step decimal.Decimal
+	Note string
 }
 
 // Portfolio represents portfolio data for a user
 type Portfolio struct {
+	// ID is 0 for the first portfolio of a user, which was their only
+	// portfolio before users could have several.
+	ID       int64
+	Name     string
 	Currency Currency
 	Cash     decimal.Decimal
+	// CostBasisMethod is how the cost of assets sold is worked out.
+	CostBasisMethod string
 }
 
 // Asset represents the value and purchased amount of a crypto asset
@@ -50,3 +91,74 @@ type Asset struct {
 	Purchased decimal.Decimal
 	Amount    decimal.Decimal
 }
+
+// APIToken is a personal token for authenticating with the JSON API.
+type APIToken struct {
+	ID     int64
+	UserID int64
+	// Username is the name of the user the token belongs to.
+	Username string
+	Name     string
+	// Scope is "read" for read-only tokens, or "read-write".
+	Scope string
+	// Hash is the SHA-256 hash of the token, as tokens are only shown once.
+	Hash       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// PortfolioSnapshot is the value of a portfolio at the end of a day
+type PortfolioSnapshot struct {
+	Date     time.Time
+	Currency Currency
+	// Value is the value of every asset and the cash in the portfolio.
+	Value decimal.Decimal
+	// Purchased is the cost basis of the assets, not including cash.
+	Purchased decimal.Decimal
+	Cash      decimal.Decimal
+}
+
+// CashFlow is cash deposited into or withdrawn from a portfolio
+type CashFlow struct {
+	ID          int64
+	PortfolioID int64
+	// Kind is "deposit" or "withdrawal".
+	Kind string
+	Time time.Time
+	// Currency is the currency of the portfolio when the cash was moved.
+	Currency Currency
+	Amount   decimal.Decimal
+	Note     string
+	// TransferID links the two sides of a transfer between portfolios, and is
+	// zero for other cash flows.
+	TransferID int64
+}
+
+// Transaction is an entry in the ledger of trades for a portfolio
+type Transaction struct {
+	ID int64
+	// PortfolioID is the portfolio the transaction was made in.
+	PortfolioID int64
+	Currency    Currency
+	// Kind is "buy", "sell", "deposit" or "withdrawal".
+	Kind string
+	Time time.Time
+	// Quantity is the amount of the asset bought, sold or moved.
+	Quantity decimal.Decimal
+	// Price is the price of one unit of the asset in the portfolio currency.
+	Price decimal.Decimal
+	// Fee is the fee paid, in the portfolio currency unless FeeCurrency is set.
+	Fee decimal.Decimal
+	// FeeCurrency is the ticker of the asset when the fee was paid in the
+	// asset, and empty when it was paid in the portfolio currency.
+	FeeCurrency string
+	Note        string
+	// ExternalID identifies a transaction imported from an exchange.
+	ExternalID string
+	// TransferID links every transaction in a transfer between portfolios,
+	// and is zero for other transactions.
+	TransferID int64
+	// SwapID links the sale and purchase in a swap of one asset for another,
+	// and is zero for other transactions.
+	SwapID int64
+}
@@ -0,0 +1,120 @@
+// Package openapi serves the OpenAPI document for the JSON API, and checks
+// that it matches the routes the server registers.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// pathPrefix is the prefix of every route described by the document.
+const pathPrefix = "/api/v1/"
+
+//go:embed openapi.json
+var document []byte
+
+// operationMethods are the keys of a path item that describe operations.
+var operationMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// documentRoutes returns "METHOD /path" for every operation in the document.
+func documentRoutes() ([]string, error) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	if err := json.Unmarshal(document, &spec); err != nil {
+		return nil, err
+	}
+
+	var routeList []string
+
+	for path, item := range spec.Paths {
+		for key := range item {
+			if slices.Contains(operationMethods, key) {
+				routeList = append(routeList, strings.ToUpper(key)+" "+path)
+			}
+		}
+	}
+
+	return routeList, nil
+}
+
+// routerRoutes returns "METHOD /path" for every API route on a router.
+func routerRoutes(router *mux.Router) ([]string, error) {
+	var routeList []string
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+
+		if err != nil || !strings.HasPrefix(path, pathPrefix) {
+			return nil
+		}
+
+		methodList, err := route.GetMethods()
+
+		if err != nil {
+			return fmt.Errorf("API route %s has no methods", path)
+		}
+
+		for _, method := range methodList {
+			routeList = append(routeList, method+" "+path)
+		}
+
+		return nil
+	})
+
+	return routeList, err
+}
+
+// VerifyRoutes returns an error if the API routes on a router don't match
+// the operations in the OpenAPI document.
+//
+// The tests for the server run this, so the document can't silently fall
+// behind the routes.
+func VerifyRoutes(router *mux.Router) error {
+	documentList, err := documentRoutes()
+
+	if err != nil {
+		return fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+
+	routeList, err := routerRoutes(router)
+
+	if err != nil {
+		return err
+	}
+
+	var problemList []string
+
+	for _, route := range routeList {
+		if !slices.Contains(documentList, route) {
+			problemList = append(problemList, "undocumented route: "+route)
+		}
+	}
+
+	for _, route := range documentList {
+		if !slices.Contains(routeList, route) {
+			problemList = append(problemList, "documented route not registered: "+route)
+		}
+	}
+
+	if len(problemList) > 0 {
+		slices.Sort(problemList)
+
+		return fmt.Errorf("OpenAPI document doesn't match routes:\n%s", strings.Join(problemList, "\n"))
+	}
+
+	return nil
+}
+
+// HandleDocument serves the OpenAPI document.
+func HandleDocument(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(document)
+}
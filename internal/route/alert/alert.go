@@ -2,10 +2,17 @@
 package alert
 
 import (
+	"maps"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/dense-analysis/pricewarp/internal/backtest"
 	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/indicator"
 	"github.com/dense-analysis/pricewarp/internal/model"
 	"github.com/dense-analysis/pricewarp/internal/route/query"
 	"github.com/dense-analysis/pricewarp/internal/route/util"
@@ -26,6 +33,16 @@ select
 	from_currency_name,
 	to_currency_ticker,
 	to_currency_name,
+	active_from,
+	expires_at,
+	note,
+	labels,
+	ladder_id,
+	indicator,
+	period,
+	rule,
+	recurring,
+	upper,
 	is_deleted
 from (
 	select
@@ -38,6 +55,16 @@ from (
 		from_currency_name,
 		to_currency_ticker,
 		to_currency_name,
+		active_from,
+		expires_at,
+		note,
+		labels,
+		ladder_id,
+		indicator,
+		period,
+		rule,
+		recurring,
+		upper,
 		is_deleted
 	from crypto_alert
 	where user_id = ?
@@ -50,6 +77,8 @@ func scanAlert(row database.Row, alert *model.Alert) error {
 	var value decimal.Decimal
 	var above uint8
 	var sent uint8
+	var period uint16
+	var recurring uint8
 	var isDeleted uint8
 
 	if err := row.Scan(
@@ -62,6 +91,16 @@ func scanAlert(row database.Row, alert *model.Alert) error {
 		&alert.From.Name,
 		&alert.To.Ticker,
 		&alert.To.Name,
+		&alert.ActiveFrom,
+		&alert.ExpiresAt,
+		&alert.Note,
+		&alert.Labels,
+		&alert.LadderID,
+		&alert.Indicator,
+		&period,
+		&alert.Rule,
+		&recurring,
+		&alert.Upper,
 		&isDeleted,
 	); err != nil {
 		return err
@@ -74,6 +113,8 @@ func scanAlert(row database.Row, alert *model.Alert) error {
 	alert.Above = above == 1
 	alert.Sent = sent == 1
 	alert.Value = value
+	alert.Period = int(period)
+	alert.Recurring = recurring == 1
 
 	return nil
 }
@@ -88,6 +129,113 @@ func scanCurrency(row database.Row, currency *model.Currency) error {
 	return nil
 }
 
+var alertInsertQuery = `
+insert into crypto_alert
+	(alert_id, user_id, username, above, alert_time, sent, value,
+	 from_currency_ticker, from_currency_name,
+	 to_currency_ticker, to_currency_name,
+	 active_from, expires_at,
+	 note, labels, ladder_id,
+	 indicator, period, rule, recurring, upper,
+	 updated_at, is_deleted)
+values (?, ?, ?, ?, ?, ?, ?,
+	?, ?,
+	?, ?,
+	?, ?,
+	?, ?, ?,
+	?, ?, ?, ?, ?,
+	now64(9), ?)
+`
+
+// alertBatchQuery inserts alerts in a batch, where values can't be expressions.
+var alertBatchQuery = `
+insert into crypto_alert
+	(alert_id, user_id, username, above, alert_time, sent, value,
+	 from_currency_ticker, from_currency_name,
+	 to_currency_ticker, to_currency_name,
+	 active_from, expires_at,
+	 note, labels, ladder_id,
+	 indicator, period, rule, recurring, upper,
+	 updated_at, is_deleted)
+`
+
+// saveAlert writes a new version of an alert, or marks it as deleted.
+func saveAlert(conn database.Queryable, user *model.User, alert *model.Alert, isDeleted bool) error {
+	return conn.Exec(
+		alertInsertQuery,
+		alert.ID,
+		user.ID,
+		user.Username,
+		database.BoolToUint(alert.Above),
+		alert.Time,
+		database.BoolToUint(alert.Sent),
+		alert.Value,
+		alert.From.Ticker,
+		alert.From.Name,
+		alert.To.Ticker,
+		alert.To.Name,
+		alert.ActiveFrom,
+		alert.ExpiresAt,
+		alert.Note,
+		alert.Labels,
+		alert.LadderID,
+		alert.Indicator,
+		uint16(alert.Period),
+		alert.Rule,
+		database.BoolToUint(alert.Recurring),
+		alert.Upper,
+		database.BoolToUint(isDeleted),
+	)
+}
+
+// saveAlertList writes new versions of many alerts in a single batch, or marks
+// them all as deleted.
+func saveAlertList(conn *database.Conn, user *model.User, alertList []model.Alert, isDeleted bool) error {
+	if len(alertList) == 0 {
+		return nil
+	}
+
+	batch, err := conn.PrepareBatch(alertBatchQuery)
+
+	if err != nil {
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+
+	for _, alert := range alertList {
+		if err := batch.Append(
+			alert.ID,
+			user.ID,
+			user.Username,
+			database.BoolToUint(alert.Above),
+			alert.Time,
+			database.BoolToUint(alert.Sent),
+			alert.Value,
+			alert.From.Ticker,
+			alert.From.Name,
+			alert.To.Ticker,
+			alert.To.Name,
+			alert.ActiveFrom,
+			alert.ExpiresAt,
+			alert.Note,
+			alert.Labels,
+			alert.LadderID,
+			alert.Indicator,
+			uint16(alert.Period),
+			alert.Rule,
+			database.BoolToUint(alert.Recurring),
+			alert.Upper,
+			updatedAt,
+			database.BoolToUint(isDeleted),
+		); err != nil {
+			return err
+		}
+	}
+
+	return batch.Send()
+}
+
 func loadAlertList(conn *database.Conn, userID int64, alertList *[]model.Alert) error {
 	return model.LoadList(
 		conn,
@@ -128,9 +276,114 @@ type AlertPageData struct {
 	ToCurrencyList   []model.Currency
 }
 
+// AlertGroup is a named group of alerts shown together on the alert list.
+type AlertGroup struct {
+	Name      string
+	AlertList []model.Alert
+}
+
 type AlertListPageData struct {
 	AlertPageData
-	AlertList []model.Alert
+	AlertList        []model.Alert
+	AlertGroupList   []AlertGroup
+	ExpiredAlertList []model.Alert
+	LadderList       []LadderProgress
+	// Ladder is an empty ladder for the ladder form.
+	Ladder    model.Ladder
+	LabelList []string
+	PairList  []string
+	// The label, pair and grouping selected for filtering the list.
+	Label string
+	Pair  string
+	Group string
+}
+
+// alertPair returns the name of the pair for an alert, such as "BTC/USD".
+func alertPair(alert *model.Alert) string {
+	return alert.From.Ticker + "/" + alert.To.Ticker
+}
+
+// filterAlertList keeps only alerts with the selected label and pair.
+//
+// The labels and pairs of all alerts are collected first so the filters can
+// still be changed after they are applied.
+func filterAlertList(data *AlertListPageData) {
+	labelSet := map[string]bool{}
+	pairSet := map[string]bool{}
+	filteredList := make([]model.Alert, 0, len(data.AlertList))
+
+	for _, alert := range data.AlertList {
+		for _, label := range alert.Labels {
+			labelSet[label] = true
+		}
+
+		pairSet[alertPair(&alert)] = true
+
+		if data.Label != "" && !slices.Contains(alert.Labels, data.Label) {
+			continue
+		}
+
+		if data.Pair != "" && alertPair(&alert) != data.Pair {
+			continue
+		}
+
+		filteredList = append(filteredList, alert)
+	}
+
+	data.LabelList = slices.Sorted(maps.Keys(labelSet))
+	data.PairList = slices.Sorted(maps.Keys(pairSet))
+	data.AlertList = filteredList
+}
+
+// groupAlertList splits alerts into groups by label or by pair.
+//
+// Alerts with many labels appear under each of their labels.
+func groupAlertList(data *AlertListPageData) {
+	if data.Group != "label" && data.Group != "pair" {
+		data.AlertGroupList = []AlertGroup{{AlertList: data.AlertList}}
+
+		return
+	}
+
+	groupMap := map[string][]model.Alert{}
+
+	for _, alert := range data.AlertList {
+		if data.Group == "pair" {
+			pair := alertPair(&alert)
+			groupMap[pair] = append(groupMap[pair], alert)
+		} else if len(alert.Labels) == 0 {
+			groupMap[""] = append(groupMap[""], alert)
+		} else {
+			for _, label := range alert.Labels {
+				groupMap[label] = append(groupMap[label], alert)
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(groupMap)) {
+		group := AlertGroup{Name: name, AlertList: groupMap[name]}
+
+		if name == "" {
+			group.Name = "Unlabelled"
+		}
+
+		data.AlertGroupList = append(data.AlertGroupList, group)
+	}
+}
+
+// splitExpiredAlerts moves alerts which have expired into a separate list.
+func splitExpiredAlerts(data *AlertListPageData, now time.Time) {
+	activeList := make([]model.Alert, 0, len(data.AlertList))
+
+	for _, alert := range data.AlertList {
+		if alert.ExpiresAt != nil && !alert.ExpiresAt.After(now) {
+			data.ExpiredAlertList = append(data.ExpiredAlertList, alert)
+		} else {
+			activeList = append(activeList, alert)
+		}
+	}
+
+	data.AlertList = activeList
 }
 
 func HandleAlertList(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
@@ -149,6 +402,21 @@ func HandleAlertList(conn *database.Conn, writer http.ResponseWriter, request *h
 		return
 	}
 
+	if err := loadLadderProgressList(conn, &data); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	values := request.URL.Query()
+	data.Label = values.Get("label")
+	data.Pair = values.Get("pair")
+	data.Group = values.Get("group")
+
+	filterAlertList(&data)
+	splitExpiredAlerts(&data, time.Now())
+	groupAlertList(&data)
+
 	if err := loadCurrencyList(conn, &data.FromCurrencyList); err != nil {
 		util.RespondInternalServerError(writer, err)
 
@@ -159,6 +427,21 @@ func HandleAlertList(conn *database.Conn, writer http.ResponseWriter, request *h
 	template.Render(template.AlertList, writer, data)
 }
 
+// loadAlertByRouteID loads the user's alert with the ID in the route.
+//
+// database.ErrNoRows is returned for invalid IDs, and deleted alerts.
+func loadAlertByRouteID(conn *database.Conn, request *http.Request, user *model.User, alert *model.Alert) error {
+	alertID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+
+	if err != nil {
+		return database.ErrNoRows
+	}
+
+	row := conn.QueryRow(alertQuery+"where alert_id = ?", user.ID, alertID)
+
+	return scanAlert(row, alert)
+}
+
 func loadAlertForRequest(
 	conn *database.Conn,
 	writer http.ResponseWriter,
@@ -166,35 +449,7 @@ func loadAlertForRequest(
 	user *model.User,
 	alert *model.Alert,
 ) bool {
-	alertID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
-
-	if err != nil {
-		util.RespondNotFound(writer)
-
-		return false
-	}
-
-	row := conn.QueryRow(
-		`select
-			alert_id,
-			above,
-			alert_time,
-			sent,
-			value,
-			from_currency_ticker,
-			from_currency_name,
-			to_currency_ticker,
-			to_currency_name,
-			is_deleted
-		from crypto_alert
-		where user_id = ? and alert_id = ?
-		order by updated_at desc
-		limit 1`,
-		user.ID,
-		alertID,
-	)
-
-	if err := scanAlert(row, alert); err != nil {
+	if err := loadAlertByRouteID(conn, request, user, alert); err != nil {
 		if err == database.ErrNoRows {
 			util.RespondNotFound(writer)
 		} else {
@@ -227,68 +482,177 @@ func HandleAlert(conn *database.Conn, writer http.ResponseWriter, request *http.
 	}
 }
 
-func loadAlertFromForm(
-	conn *database.Conn,
-	writer http.ResponseWriter,
-	request *http.Request,
-	alert *model.Alert,
-) bool {
-	var err error
-	request.ParseForm()
+// loadAlertCurrency loads a currency for an alert, which must exist.
+func loadAlertCurrency(conn database.Queryable, currency *model.Currency, ticker string) error {
+	row := conn.QueryRow(currencyQuery+"where ticker = ?", ticker)
 
-	fromTicker := request.Form.Get("from")
-	toTicker := request.Form.Get("to")
+	if err := scanCurrency(row, currency); err != nil {
+		if err == database.ErrNoRows {
+			return util.ValidationError("Unknown currency ticker " + ticker)
+		}
 
-	if fromTicker == "" || toTicker == "" {
-		util.RespondValidationError(writer, "Invalid currency ticker")
+		return err
+	}
 
-		return false
+	return nil
+}
+
+// parseAlert validates the fields for an alert and loads its currencies.
+//
+// Invalid values are reported with a util.ValidationError.
+func parseAlert(conn database.Queryable, values url.Values, alert *model.Alert) error {
+	fromTicker := values.Get("from")
+	toTicker := values.Get("to")
+
+	if fromTicker == "" || toTicker == "" {
+		return util.ValidationError("Invalid currency ticker")
 	}
 
 	if fromTicker == toTicker {
-		util.RespondValidationError(writer, "From and to currencies cannot be the same")
+		return util.ValidationError("From and to currencies cannot be the same")
+	}
 
-		return false
+	indicatorName := values.Get("indicator")
+	period := 0
+
+	if indicatorName != "" {
+		if !indicator.IsValid(indicatorName) {
+			return util.ValidationError("Invalid indicator")
+		}
+
+		var err error
+		period, err = strconv.Atoi(values.Get("period"))
+
+		if err != nil || period < 2 || period > maxIndicatorPeriod {
+			return util.ValidationError("The period must be between 2 and 200 days")
+		}
 	}
 
-	value, err := decimal.NewFromString(request.Form.Get("value"))
+	value := decimal.Zero
+	valueString := values.Get("value")
 
-	if err != nil {
-		util.RespondValidationError(writer, "Invalid value")
+	// Moving average alerts compare against the average, so need no value.
+	if valueString != "" || (indicatorName != indicator.SMA && indicatorName != indicator.EMA) {
+		var err error
+		value, err = decimal.NewFromString(valueString)
 
-		return false
+		if err != nil {
+			return util.ValidationError("Invalid value")
+		}
+	}
+
+	if indicatorName == indicator.RSI && (value.IsNegative() || value.GreaterThan(decimal.NewFromInt(100))) {
+		return util.ValidationError("RSI values must be between 0 and 100")
 	}
 
-	direction := request.Form.Get("direction")
+	upper := decimal.Zero
+
+	// RSI alerts can fire when the RSI leaves a band, from the value up to
+	// the upper value.
+	if upperString := values.Get("upper"); upperString != "" {
+		var err error
+		upper, err = decimal.NewFromString(upperString)
+
+		if err != nil {
+			return util.ValidationError("Invalid upper value")
+		}
+
+		if indicatorName != indicator.RSI {
+			return util.ValidationError("Only RSI alerts can have an upper value")
+		}
+
+		if !upper.GreaterThan(value) || upper.GreaterThan(decimal.NewFromInt(100)) {
+			return util.ValidationError("The upper value must be above the value and at most 100")
+		}
+	}
+
+	direction := values.Get("direction")
 
 	if direction != "above" && direction != "below" {
-		util.RespondValidationError(writer, "Invalid direction")
+		return util.ValidationError("Invalid direction")
+	}
 
-		return false
+	rule := values.Get("rule")
+
+	if !backtest.IsValidRule(rule) {
+		return util.ValidationError("Invalid rule")
 	}
 
-	alert.Value = value
+	if rule != backtest.Threshold {
+		if indicatorName != "" {
+			return util.ValidationError("Indicator alerts can't use percent or trailing rules")
+		}
 
-	if direction == "above" {
-		alert.Above = true
-	} else {
-		alert.Above = false
+		if !value.IsPositive() {
+			return util.ValidationError("Percentages must be more than 0")
+		}
+
+		if direction == "below" && value.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+			return util.ValidationError("Prices can't fall by 100% or more")
+		}
 	}
 
-	var row database.Row
+	recurring, err := parseFormBool(values.Get("recurring"))
 
-	row = conn.QueryRow(currencyQuery+"where ticker = ?", fromTicker)
+	if err != nil {
+		return util.ValidationError("Invalid recurring value")
+	}
 
-	if err := scanCurrency(row, &alert.From); err != nil {
-		util.RespondInternalServerError(writer, err)
+	activeFrom, err := parseFormTime(values.Get("active_from"))
 
-		return false
+	if err != nil {
+		return util.ValidationError("Invalid active from time")
 	}
 
-	row = conn.QueryRow(currencyQuery+"where ticker = ?", toTicker)
+	expiresAt, err := parseFormTime(values.Get("expires_at"))
 
-	if err := scanCurrency(row, &alert.To); err != nil {
-		util.RespondInternalServerError(writer, err)
+	if err != nil {
+		return util.ValidationError("Invalid expiry time")
+	}
+
+	if activeFrom != nil && expiresAt != nil && !expiresAt.After(*activeFrom) {
+		return util.ValidationError("Alerts must expire after they become active")
+	}
+
+	note := strings.TrimSpace(values.Get("note"))
+
+	if len(note) > maxNoteLength {
+		return util.ValidationError("Notes must be at most 500 characters")
+	}
+
+	alert.Value = value
+	alert.Above = direction == "above"
+	alert.ActiveFrom = activeFrom
+	alert.ExpiresAt = expiresAt
+	alert.Note = note
+	alert.Labels = parseLabels(values.Get("labels"))
+	alert.Indicator = indicatorName
+	alert.Period = period
+	alert.Rule = rule
+	alert.Recurring = recurring
+	alert.Upper = upper
+
+	if err := loadAlertCurrency(conn, &alert.From, fromTicker); err != nil {
+		return err
+	}
+
+	if err := loadAlertCurrency(conn, &alert.To, toTicker); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func loadAlertFromForm(
+	conn *database.Conn,
+	writer http.ResponseWriter,
+	request *http.Request,
+	alert *model.Alert,
+) bool {
+	request.ParseForm()
+
+	if err := parseAlert(conn, request.Form, alert); err != nil {
+		util.RespondError(writer, err)
 
 		return false
 	}
@@ -296,6 +660,61 @@ func loadAlertFromForm(
 	return true
 }
 
+// maxIndicatorPeriod is the longest period in days for indicator alerts.
+const maxIndicatorPeriod = 200
+
+// maxNoteLength is the longest note that can be set on an alert.
+const maxNoteLength = 500
+
+// parseLabels splits a comma separated list of labels, dropping duplicates.
+func parseLabels(value string) []string {
+	labelList := []string{}
+
+	for label := range strings.SplitSeq(value, ",") {
+		label = strings.TrimSpace(label)
+
+		if label != "" && !slices.Contains(labelList, label) {
+			labelList = append(labelList, label)
+		}
+	}
+
+	return labelList
+}
+
+// formTimeLayout is the layout of datetime-local inputs, read as UTC.
+const formTimeLayout = "2006-01-02T15:04"
+
+// parseFormTime parses an optional time from a form, returning nil when blank.
+//
+// RFC 3339 times are accepted too, for times sent through the API.
+func parseFormTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.ParseInLocation(formTimeLayout, value, time.UTC)
+
+	if err != nil {
+		parsed, err = time.Parse(time.RFC3339, value)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &parsed, nil
+}
+
+// parseFormBool parses an optional boolean from a form, returning false when
+// blank.
+func parseFormBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
 func HandleSubmitAlert(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
 	var user model.User
 	var alert model.Alert
@@ -307,7 +726,8 @@ func HandleSubmitAlert(conn *database.Conn, writer http.ResponseWriter, request
 	}
 
 	if loadAlertFromForm(conn, writer, request, &alert) {
-		alertID, err := database.RandomID()
+		var err error
+		alert.ID, err = database.RandomID()
 
 		if err != nil {
 			util.RespondInternalServerError(writer, err)
@@ -315,30 +735,10 @@ func HandleSubmitAlert(conn *database.Conn, writer http.ResponseWriter, request
 			return
 		}
 
-		insertSQL := `
-		insert into crypto_alert
-			(alert_id, user_id, username, above, alert_time, sent, value,
-			 from_currency_ticker, from_currency_name,
-			 to_currency_ticker, to_currency_name,
-			 updated_at, is_deleted)
-		values (?, ?, ?, ?, now64(9), 0, ?,
-			?, ?,
-			?, ?,
-			now64(9), 0)
-		`
-
-		if err := conn.Exec(
-			insertSQL,
-			alertID,
-			user.ID,
-			user.Username,
-			boolToUint(alert.Above),
-			alert.Value,
-			alert.From.Ticker,
-			alert.From.Name,
-			alert.To.Ticker,
-			alert.To.Name,
-		); err != nil {
+		alert.Time = time.Now().UTC()
+		alert.Sent = false
+
+		if err := saveAlert(conn, &user, &alert, false); err != nil {
 			util.RespondInternalServerError(writer, err)
 		} else {
 			http.Redirect(writer, request, "/alert", http.StatusFound)
@@ -357,30 +757,11 @@ func HandleUpdateAlert(conn *database.Conn, writer http.ResponseWriter, request
 	}
 
 	if loadAlertForRequest(conn, writer, request, &user, &alert) && loadAlertFromForm(conn, writer, request, &alert) {
-		updateSQL := `
-		insert into crypto_alert
-			(alert_id, user_id, username, above, alert_time, sent, value,
-			 from_currency_ticker, from_currency_name,
-			 to_currency_ticker, to_currency_name,
-			 updated_at, is_deleted)
-		values (?, ?, ?, ?, now64(9), 0, ?,
-			?, ?,
-			?, ?,
-			now64(9), 0)
-		`
-
-		if err := conn.Exec(
-			updateSQL,
-			alert.ID,
-			user.ID,
-			user.Username,
-			boolToUint(alert.Above),
-			alert.Value,
-			alert.From.Ticker,
-			alert.From.Name,
-			alert.To.Ticker,
-			alert.To.Name,
-		); err != nil {
+		// Updating an alert arms it again from now.
+		alert.Time = time.Now().UTC()
+		alert.Sent = false
+
+		if err := saveAlert(conn, &user, &alert, false); err != nil {
 			util.RespondInternalServerError(writer, err)
 		} else {
 			http.Redirect(writer, request, "/alert", http.StatusFound)
@@ -399,32 +780,7 @@ func HandleDeleteAlert(conn *database.Conn, writer http.ResponseWriter, request
 	}
 
 	if loadAlertForRequest(conn, writer, request, &user, &alert) {
-		deleteSQL := `
-		insert into crypto_alert
-			(alert_id, user_id, username, above, alert_time, sent, value,
-			 from_currency_ticker, from_currency_name,
-			 to_currency_ticker, to_currency_name,
-			 updated_at, is_deleted)
-		values (?, ?, ?, ?, ?, ?, ?,
-			?, ?,
-			?, ?,
-			now64(9), 1)
-		`
-
-		if err := conn.Exec(
-			deleteSQL,
-			alert.ID,
-			user.ID,
-			user.Username,
-			boolToUint(alert.Above),
-			alert.Time,
-			boolToUint(alert.Sent),
-			alert.Value,
-			alert.From.Ticker,
-			alert.From.Name,
-			alert.To.Ticker,
-			alert.To.Name,
-		); err != nil {
+		if err := saveAlert(conn, &user, &alert, true); err != nil {
 			util.RespondInternalServerError(writer, err)
 		} else {
 			writer.WriteHeader(http.StatusNoContent)
@@ -432,10 +788,120 @@ func HandleDeleteAlert(conn *database.Conn, writer http.ResponseWriter, request
 	}
 }
 
-func boolToUint(value bool) uint8 {
-	if value {
-		return 1
+// The default and maximum number of days to replay for a backtest.
+const defaultBacktestDays = 30
+const maxBacktestDays = 365
+
+// backtestIntervalList is the interval of the closing prices replayed for
+// backtests up to a number of days, so longer backtests load fewer prices.
+var backtestIntervalList = []struct {
+	Days     int
+	Interval time.Duration
+}{
+	{Days: 1, Interval: time.Minute},
+	{Days: 7, Interval: 5 * time.Minute},
+	{Days: 30, Interval: 15 * time.Minute},
+	{Days: 90, Interval: time.Hour},
+	{Days: maxBacktestDays, Interval: 4 * time.Hour},
+}
+
+// backtestInterval returns the interval of closing prices for a backtest.
+func backtestInterval(days int) time.Duration {
+	for _, item := range backtestIntervalList {
+		if days <= item.Days {
+			return item.Interval
+		}
+	}
+
+	return backtestIntervalList[len(backtestIntervalList)-1].Interval
+}
+
+type AlertBacktestPageData struct {
+	AlertPageData
+	Days        int
+	PriceCount  int
+	TriggerList []backtest.Trigger
+}
+
+// HandleBacktestAlert replays historical prices against an alert from the form.
+func HandleBacktestAlert(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := AlertBacktestPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	if !loadAlertFromForm(conn, writer, request, &data.Alert) {
+		return
+	}
+
+	// Keep the ID of an alert being edited so the form can still update it.
+	data.Alert.ID, _ = strconv.ParseInt(request.Form.Get("id"), 10, 64)
+	data.Days = defaultBacktestDays
+
+	if daysString := request.Form.Get("days"); daysString != "" {
+		days, err := strconv.Atoi(daysString)
+
+		if err != nil || days < 1 || days > maxBacktestDays {
+			util.RespondValidationError(writer, "Days must be between 1 and 365")
+
+			return
+		}
+
+		data.Days = days
 	}
 
-	return 0
+	end := time.Now().UTC()
+	start := end.AddDate(0, 0, -data.Days)
+	var priceList []model.Price
+
+	if data.Alert.Indicator != "" {
+		// Indicators are computed over daily closing prices from before the start.
+		historyStart := start.AddDate(0, 0, -indicator.Lookback(data.Alert.Indicator, data.Alert.Period))
+
+		if err := query.LoadClosingPrices(
+			conn,
+			&priceList,
+			data.Alert.From.Ticker,
+			data.Alert.To.Ticker,
+			historyStart,
+			end,
+			24*time.Hour,
+		); err != nil {
+			util.RespondInternalServerError(writer, err)
+
+			return
+		}
+
+		data.TriggerList = backtest.RunIndicator(&data.Alert, priceList, start)
+	} else {
+		if err := query.LoadClosingPrices(
+			conn,
+			&priceList,
+			data.Alert.From.Ticker,
+			data.Alert.To.Ticker,
+			start,
+			end,
+			backtestInterval(data.Days),
+		); err != nil {
+			util.RespondInternalServerError(writer, err)
+
+			return
+		}
+
+		data.TriggerList = backtest.Run(&data.Alert, priceList)
+	}
+
+	data.PriceCount = len(priceList)
+
+	if err := loadCurrencyList(conn, &data.FromCurrencyList); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	data.ToCurrencyList = query.GetToCurrencyList()
+	template.Render(template.AlertBacktest, writer, data)
 }
@@ -0,0 +1,231 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/token"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/shopspring/decimal"
+)
+
+// maxAPIBodySize is the largest request body accepted by the API.
+const maxAPIBodySize = 1 << 20
+
+// APIAlert is an alert in the JSON API.
+//
+// IDs are strings, as they can be too large for JavaScript numbers, and
+// decimals are strings to keep their precision.
+type APIAlert struct {
+	ID         string           `json:"id"`
+	From       util.APICurrency `json:"from"`
+	To         util.APICurrency `json:"to"`
+	Direction  string           `json:"direction"`
+	Value      decimal.Decimal  `json:"value"`
+	Indicator  string           `json:"indicator"`
+	Period     int              `json:"period"`
+	Upper      decimal.Decimal  `json:"upper"`
+	Rule       string           `json:"rule"`
+	Recurring  bool             `json:"recurring"`
+	ActiveFrom *time.Time       `json:"active_from"`
+	ExpiresAt  *time.Time       `json:"expires_at"`
+	Note       string           `json:"note"`
+	Labels     []string         `json:"labels"`
+	LadderID   string           `json:"ladder_id,omitempty"`
+	Sent       bool             `json:"sent"`
+	Time       time.Time        `json:"time"`
+}
+
+// APIAlertList is the response for listing alerts.
+type APIAlertList struct {
+	Alerts []APIAlert `json:"alerts"`
+}
+
+func newAPIAlert(alert *model.Alert) APIAlert {
+	apiAlert := APIAlert{
+		ID:         strconv.FormatInt(alert.ID, 10),
+		From:       util.NewAPICurrency(&alert.From),
+		To:         util.NewAPICurrency(&alert.To),
+		Direction:  "below",
+		Value:      alert.Value,
+		Indicator:  alert.Indicator,
+		Period:     alert.Period,
+		Upper:      alert.Upper,
+		Rule:       alert.Rule,
+		Recurring:  alert.Recurring,
+		ActiveFrom: alert.ActiveFrom,
+		ExpiresAt:  alert.ExpiresAt,
+		Note:       alert.Note,
+		Labels:     alert.Labels,
+		Sent:       alert.Sent,
+		Time:       alert.Time,
+	}
+
+	if alert.Above {
+		apiAlert.Direction = "above"
+	}
+
+	if alert.LadderID != 0 {
+		apiAlert.LadderID = strconv.FormatInt(alert.LadderID, 10)
+	}
+
+	if apiAlert.Labels == nil {
+		apiAlert.Labels = []string{}
+	}
+
+	return apiAlert
+}
+
+func loadAPIAlertForRequest(
+	conn *database.Conn,
+	writer http.ResponseWriter,
+	request *http.Request,
+	user *model.User,
+	alert *model.Alert,
+) bool {
+	if err := loadAlertByRouteID(conn, request, user, alert); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondJSONStatus(writer, http.StatusNotFound, "Alert not found")
+		} else {
+			util.RespondJSONError(writer, err)
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// loadAlertFromJSON validates an alert from a JSON request body.
+//
+// The body uses the same fields as alert imports, and is validated with the
+// same rules as the alert form.
+func loadAlertFromJSON(
+	conn *database.Conn,
+	writer http.ResponseWriter,
+	request *http.Request,
+	alert *model.Alert,
+) bool {
+	var record alertRecord
+
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxAPIBodySize))
+
+	if err := decoder.Decode(&record); err != nil {
+		util.RespondJSONStatus(writer, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+
+		return false
+	}
+
+	if err := parseAlert(conn, record.values(), alert); err != nil {
+		util.RespondJSONError(writer, err)
+
+		return false
+	}
+
+	return true
+}
+
+// HandleAPIAlertList lists a user's alerts.
+func HandleAPIAlertList(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var alertList []model.Alert
+
+	if !token.LoadAPIUser(conn, writer, request, &user) {
+		return
+	}
+
+	if err := loadAlertList(conn, user.ID, &alertList); err != nil {
+		util.RespondJSONError(writer, err)
+
+		return
+	}
+
+	response := APIAlertList{Alerts: make([]APIAlert, len(alertList))}
+
+	for i := range alertList {
+		response.Alerts[i] = newAPIAlert(&alertList[i])
+	}
+
+	util.RespondJSON(writer, http.StatusOK, response)
+}
+
+// HandleAPIAlert gets a single alert.
+func HandleAPIAlert(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var alert model.Alert
+
+	if token.LoadAPIUser(conn, writer, request, &user) &&
+		loadAPIAlertForRequest(conn, writer, request, &user, &alert) {
+		util.RespondJSON(writer, http.StatusOK, newAPIAlert(&alert))
+	}
+}
+
+// HandleAPICreateAlert creates an alert.
+func HandleAPICreateAlert(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var alert model.Alert
+
+	if !token.LoadAPIUser(conn, writer, request, &user) || !loadAlertFromJSON(conn, writer, request, &alert) {
+		return
+	}
+
+	var err error
+	alert.ID, err = database.RandomID()
+
+	if err != nil {
+		util.RespondJSONError(writer, err)
+
+		return
+	}
+
+	alert.Time = time.Now().UTC()
+	alert.Sent = false
+
+	if err := saveAlert(conn, &user, &alert, false); err != nil {
+		util.RespondJSONError(writer, err)
+	} else {
+		util.RespondJSON(writer, http.StatusCreated, newAPIAlert(&alert))
+	}
+}
+
+// HandleAPIUpdateAlert replaces the fields of an alert, arming it again.
+func HandleAPIUpdateAlert(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var alert model.Alert
+
+	if !token.LoadAPIUser(conn, writer, request, &user) ||
+		!loadAPIAlertForRequest(conn, writer, request, &user, &alert) ||
+		!loadAlertFromJSON(conn, writer, request, &alert) {
+		return
+	}
+
+	alert.Time = time.Now().UTC()
+	alert.Sent = false
+
+	if err := saveAlert(conn, &user, &alert, false); err != nil {
+		util.RespondJSONError(writer, err)
+	} else {
+		util.RespondJSON(writer, http.StatusOK, newAPIAlert(&alert))
+	}
+}
+
+// HandleAPIDeleteAlert deletes an alert.
+func HandleAPIDeleteAlert(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var alert model.Alert
+
+	if !token.LoadAPIUser(conn, writer, request, &user) ||
+		!loadAPIAlertForRequest(conn, writer, request, &user, &alert) {
+		return
+	}
+
+	if err := saveAlert(conn, &user, &alert, true); err != nil {
+		util.RespondJSONError(writer, err)
+	} else {
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
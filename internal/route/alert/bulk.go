@@ -0,0 +1,496 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/dense-analysis/pricewarp/internal/template"
+)
+
+// maxImportSize is the largest file that can be uploaded for an import.
+const maxImportSize = 1 << 20
+
+// alertRecord is the format of an alert for importing and exporting.
+//
+// The fields use the same names and formats as the alert form.
+type alertRecord struct {
+	ID         string      `json:"id"`
+	From       string      `json:"from"`
+	To         string      `json:"to"`
+	Direction  string      `json:"direction"`
+	Value      json.Number `json:"value"`
+	ActiveFrom string      `json:"active_from"`
+	ExpiresAt  string      `json:"expires_at"`
+	Labels     []string    `json:"labels"`
+	Note       string      `json:"note"`
+	Indicator  string      `json:"indicator"`
+	Period     json.Number `json:"period"`
+	Upper      json.Number `json:"upper"`
+	Rule       string      `json:"rule"`
+	Recurring  bool        `json:"recurring"`
+	// Sent is nil when a file doesn't say if an alert was sent.
+	Sent *bool `json:"sent"`
+	// Time is when the alert was armed, which is kept for sent alerts.
+	Time string `json:"time"`
+	// row is the position of the record in the file, for reporting errors.
+	row int
+	// err is an error reading the record from the file.
+	err error
+}
+
+// alertRecordColumns is the header row for alerts in CSV files.
+var alertRecordColumns = []string{
+	"id",
+	"from",
+	"to",
+	"direction",
+	"value",
+	"active_from",
+	"expires_at",
+	"labels",
+	"note",
+	"indicator",
+	"period",
+	"upper",
+	"rule",
+	"recurring",
+	"sent",
+	"time",
+}
+
+func newAlertRecord(alert *model.Alert) alertRecord {
+	record := alertRecord{
+		ID:        strconv.FormatInt(alert.ID, 10),
+		From:      alert.From.Ticker,
+		To:        alert.To.Ticker,
+		Direction: "below",
+		Value:     json.Number(alert.Value.String()),
+		Labels:    alert.Labels,
+		Note:      alert.Note,
+		Indicator: alert.Indicator,
+		Rule:      alert.Rule,
+		Recurring: alert.Recurring,
+		Sent:      &alert.Sent,
+		Time:      alert.Time.UTC().Format(time.RFC3339Nano),
+	}
+
+	if alert.Period != 0 {
+		record.Period = json.Number(strconv.Itoa(alert.Period))
+	}
+
+	if alert.Upper.IsPositive() {
+		record.Upper = json.Number(alert.Upper.String())
+	}
+
+	if alert.Above {
+		record.Direction = "above"
+	}
+
+	if alert.ActiveFrom != nil {
+		record.ActiveFrom = alert.ActiveFrom.UTC().Format(formTimeLayout)
+	}
+
+	if alert.ExpiresAt != nil {
+		record.ExpiresAt = alert.ExpiresAt.UTC().Format(formTimeLayout)
+	}
+
+	return record
+}
+
+// values returns the record as form values for parseAlert.
+func (record *alertRecord) values() url.Values {
+	return url.Values{
+		"from":        {record.From},
+		"to":          {record.To},
+		"direction":   {record.Direction},
+		"value":       {record.Value.String()},
+		"active_from": {record.ActiveFrom},
+		"expires_at":  {record.ExpiresAt},
+		"labels":      {strings.Join(record.Labels, ",")},
+		"note":        {record.Note},
+		"indicator":   {record.Indicator},
+		"period":      {record.Period.String()},
+		"upper":       {record.Upper.String()},
+		"rule":        {record.Rule},
+		"recurring":   {strconv.FormatBool(record.Recurring)},
+	}
+}
+
+func (record *alertRecord) csvRow() []string {
+	sent := ""
+
+	if record.Sent != nil {
+		sent = strconv.FormatBool(*record.Sent)
+	}
+
+	return []string{
+		record.ID,
+		record.From,
+		record.To,
+		record.Direction,
+		record.Value.String(),
+		record.ActiveFrom,
+		record.ExpiresAt,
+		strings.Join(record.Labels, ","),
+		record.Note,
+		record.Indicator,
+		record.Period.String(),
+		record.Upper.String(),
+		record.Rule,
+		strconv.FormatBool(record.Recurring),
+		sent,
+		record.Time,
+	}
+}
+
+// HandleExportAlerts downloads all of a user's alerts as CSV or JSON.
+func HandleExportAlerts(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var alertList []model.Alert
+
+	if !loadUser(conn, writer, request, &user) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	format := request.URL.Query().Get("format")
+
+	if format == "" {
+		format = "csv"
+	}
+
+	if format != "csv" && format != "json" {
+		util.RespondValidationError(writer, "Invalid format")
+
+		return
+	}
+
+	if err := loadAlertList(conn, user.ID, &alertList); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	recordList := make([]alertRecord, len(alertList))
+
+	for i := range alertList {
+		recordList[i] = newAlertRecord(&alertList[i])
+	}
+
+	writer.Header().Set("Content-Disposition", "attachment; filename=alerts."+format)
+
+	if format == "json" {
+		writer.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(recordList)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/csv")
+	csvWriter := csv.NewWriter(writer)
+	_ = csvWriter.Write(alertRecordColumns)
+
+	for _, record := range recordList {
+		_ = csvWriter.Write(record.csvRow())
+	}
+
+	csvWriter.Flush()
+}
+
+// readAlertRecords reads alert records from CSV or JSON content.
+//
+// JSON is detected by the content starting with an array.
+func readAlertRecords(content []byte) ([]alertRecord, error) {
+	content = bytes.TrimSpace(content)
+
+	if bytes.HasPrefix(content, []byte("[")) {
+		var recordList []alertRecord
+
+		if err := json.Unmarshal(content, &recordList); err != nil {
+			return nil, util.ValidationError("Invalid JSON: " + err.Error())
+		}
+
+		for i := range recordList {
+			recordList[i].row = i + 1
+		}
+
+		return recordList, nil
+	}
+
+	csvReader := csv.NewReader(bytes.NewReader(content))
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+
+	if err != nil {
+		return nil, util.ValidationError("Invalid CSV: missing header row")
+	}
+
+	// Map column names to their positions so columns can be in any order.
+	columnMap := map[string]int{}
+
+	for i, name := range header {
+		columnMap[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	var recordList []alertRecord
+
+	for {
+		row, err := csvReader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		// Count rows like a spreadsheet, where the header is row 1.
+		rowNumber := len(recordList) + 2
+
+		// Rows with syntax errors are reported, and reading carries on with
+		// the next row.
+		if err != nil {
+			recordList = append(recordList, alertRecord{
+				row: rowNumber,
+				err: util.ValidationError("Invalid CSV: " + err.Error()),
+			})
+
+			continue
+		}
+
+		column := func(name string) string {
+			if i, ok := columnMap[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+
+			return ""
+		}
+
+		record := alertRecord{
+			ID:         column("id"),
+			From:       column("from"),
+			To:         column("to"),
+			Direction:  column("direction"),
+			Value:      json.Number(column("value")),
+			ActiveFrom: column("active_from"),
+			ExpiresAt:  column("expires_at"),
+			Labels:     parseLabels(column("labels")),
+			Note:       column("note"),
+			Indicator:  column("indicator"),
+			Period:     json.Number(column("period")),
+			Upper:      json.Number(column("upper")),
+			Rule:       column("rule"),
+			Time:       column("time"),
+			row:        rowNumber,
+		}
+
+		if sentString := column("sent"); sentString != "" {
+			if value, err := strconv.ParseBool(sentString); err == nil {
+				record.Sent = &value
+			} else {
+				record.err = util.ValidationError("Invalid sent value")
+			}
+		}
+
+		if recurring, err := parseFormBool(column("recurring")); err == nil {
+			record.Recurring = recurring
+		} else {
+			record.err = util.ValidationError("Invalid recurring value")
+		}
+
+		recordList = append(recordList, record)
+	}
+
+	return recordList, nil
+}
+
+// AlertImportResult is the outcome of importing a single row.
+type AlertImportResult struct {
+	Row     int
+	Status  string
+	Message string
+	Alert   model.Alert
+}
+
+type AlertImportPageData struct {
+	User       model.User
+	ResultList []AlertImportResult
+	Created    int
+	Updated    int
+	Failed     int
+}
+
+// parseAlertRecord validates a single imported record as an alert.
+//
+// Records with the ID of an existing alert update that alert, and everything
+// else creates a new alert. Alerts keep the sent state from the file, and
+// updated alerts keep their own when the file doesn't include it. Sent alerts
+// keep the time they were armed, and other alerts are armed from now.
+//
+// IDs are recorded in idRowMap, so a later row with the same ID is an error.
+func parseAlertRecord(
+	conn *database.Conn,
+	existingMap map[int64]model.Alert,
+	idRowMap map[string]int,
+	record *alertRecord,
+	result *AlertImportResult,
+) error {
+	alert := model.Alert{}
+	result.Status = "created"
+
+	if record.err != nil {
+		return record.err
+	}
+
+	if record.ID != "" {
+		if row, ok := idRowMap[record.ID]; ok {
+			return util.ValidationError(fmt.Sprintf("The ID %s is already used on row %d", record.ID, row))
+		}
+
+		idRowMap[record.ID] = record.row
+
+		if alertID, err := strconv.ParseInt(record.ID, 10, 64); err == nil {
+			if existing, ok := existingMap[alertID]; ok {
+				alert = existing
+				result.Status = "updated"
+			}
+		}
+	}
+
+	if err := parseAlert(conn, record.values(), &alert); err != nil {
+		return err
+	}
+
+	armedAt, err := parseFormTime(record.Time)
+
+	if err != nil {
+		return util.ValidationError("Invalid time")
+	}
+
+	if alert.ID == 0 {
+		if alert.ID, err = database.RandomID(); err != nil {
+			return err
+		}
+	}
+
+	if record.Sent != nil {
+		alert.Sent = *record.Sent
+	}
+
+	if !alert.Sent {
+		alert.Time = time.Now().UTC()
+	} else if armedAt != nil {
+		alert.Time = *armedAt
+	} else if alert.Time.IsZero() {
+		alert.Time = time.Now().UTC()
+	}
+
+	result.Alert = alert
+
+	return nil
+}
+
+// HandleImportAlerts creates or updates alerts from an uploaded CSV or JSON file.
+//
+// Every row is validated before anything is saved, and the valid rows are
+// then saved together, so a failure can't leave a file half imported.
+func HandleImportAlerts(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := AlertImportPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, maxImportSize)
+	file, _, err := request.FormFile("file")
+
+	if err != nil {
+		util.RespondValidationError(writer, "Missing or oversized import file")
+
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+
+	if err != nil {
+		util.RespondValidationError(writer, "Missing or oversized import file")
+
+		return
+	}
+
+	recordList, err := readAlertRecords(content)
+
+	if err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	var alertList []model.Alert
+
+	if err := loadAlertList(conn, data.User.ID, &alertList); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	existingMap := make(map[int64]model.Alert, len(alertList))
+	idRowMap := make(map[string]int, len(recordList))
+
+	for _, alert := range alertList {
+		existingMap[alert.ID] = alert
+	}
+
+	data.ResultList = make([]AlertImportResult, len(recordList))
+	saveList := make([]model.Alert, 0, len(recordList))
+
+	for i := range recordList {
+		result := &data.ResultList[i]
+		result.Row = recordList[i].row
+		err := parseAlertRecord(conn, existingMap, idRowMap, &recordList[i], result)
+
+		if err != nil {
+			if _, ok := err.(util.ValidationError); !ok {
+				util.RespondInternalServerError(writer, err)
+
+				return
+			}
+
+			result.Status = "error"
+			result.Message = err.Error()
+			data.Failed++
+
+			continue
+		}
+
+		if result.Status == "updated" {
+			data.Updated++
+		} else {
+			data.Created++
+		}
+
+		saveList = append(saveList, result.Alert)
+	}
+
+	if err := saveAlertList(conn, &data.User, saveList, false); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	template.Render(template.AlertImport, writer, data)
+}
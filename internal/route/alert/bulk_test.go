@@ -0,0 +1,68 @@
+package alert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadAlertRecords(t *testing.T) {
+	content := "id,from,to,direction,value,sent,time\n" +
+		"1,BTC,USD,above,50000,true,2024-01-02T03:04:05Z\n" +
+		"2,ETH,USD,below,\"2000\"x,false,\n" +
+		"3,ETH,USD,below,2000,maybe,\n" +
+		"4,SOL,USD,above,100,,\n"
+	recordList, err := readAlertRecords([]byte(content))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		row int
+		id  string
+		err string
+	}{
+		{row: 2, id: "1"},
+		{row: 3, err: "Invalid CSV"},
+		{row: 4, err: "Invalid sent value"},
+		{row: 5, id: "4"},
+	}
+
+	if len(recordList) != len(testCases) {
+		t.Fatalf("got %d records, expected %d", len(recordList), len(testCases))
+	}
+
+	for i, expected := range testCases {
+		record := recordList[i]
+
+		if record.row != expected.row {
+			t.Errorf("record %d: got row %d, expected %d", i, record.row, expected.row)
+		}
+
+		if expected.err != "" {
+			if record.err == nil || !strings.Contains(record.err.Error(), expected.err) {
+				t.Errorf("row %d: got error %v, expected %q", expected.row, record.err, expected.err)
+			}
+
+			continue
+		}
+
+		if record.err != nil {
+			t.Errorf("row %d: unexpected error: %s", expected.row, record.err)
+		}
+
+		if record.ID != expected.id {
+			t.Errorf("row %d: got ID %q, expected %q", expected.row, record.ID, expected.id)
+		}
+	}
+
+	if first := recordList[0]; first.Sent == nil || !*first.Sent || first.Time != "2024-01-02T03:04:05Z" {
+		t.Errorf("row 2: got sent %v at %q, expected true at 2024-01-02T03:04:05Z", first.Sent, first.Time)
+	}
+}
+
+func TestReadAlertRecordsWithoutHeader(t *testing.T) {
+	if _, err := readAlertRecords([]byte("")); err == nil {
+		t.Error("expected an error for a file without a header row")
+	}
+}
@@ -0,0 +1,509 @@
+package alert
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/query"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/dense-analysis/pricewarp/internal/template"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// maxLadderRungs limits how many alerts a single ladder can create.
+const maxLadderRungs = 100
+
+var ladderQuery = `
+select
+	ladder_id,
+	from_currency_ticker,
+	from_currency_name,
+	to_currency_ticker,
+	to_currency_name,
+	low,
+	high,
+	step,
+	note,
+	is_deleted
+from (
+	select *
+	from crypto_alert_ladder
+	where user_id = ?
+	order by updated_at desc
+	limit 1 by ladder_id
+)
+`
+
+func scanLadder(row database.Row, ladder *model.Ladder) error {
+	var isDeleted uint8
+
+	if err := row.Scan(
+		&ladder.ID,
+		&ladder.From.Ticker,
+		&ladder.From.Name,
+		&ladder.To.Ticker,
+		&ladder.To.Name,
+		&ladder.Low,
+		&ladder.High,
+		&ladder.Step,
+		&ladder.Note,
+		&isDeleted,
+	); err != nil {
+		return err
+	}
+
+	if isDeleted == 1 {
+		return database.ErrNoRows
+	}
+
+	return nil
+}
+
+func loadLadderList(conn *database.Conn, userID int64, ladderList *[]model.Ladder) error {
+	return model.LoadList(
+		conn,
+		ladderList,
+		1,
+		scanLadder,
+		ladderQuery+"where is_deleted = 0 order by from_currency_ticker, to_currency_ticker, low",
+		userID,
+	)
+}
+
+var ladderInsertQuery = `
+insert into crypto_alert_ladder
+	(ladder_id, user_id, username,
+	 from_currency_ticker, from_currency_name,
+	 to_currency_ticker, to_currency_name,
+	 low, high, step, note,
+	 updated_at, is_deleted)
+values (?, ?, ?,
+	?, ?,
+	?, ?,
+	?, ?, ?, ?,
+	now64(9), ?)
+`
+
+// saveLadder writes a new version of a ladder, or marks it as deleted.
+func saveLadder(conn database.Queryable, user *model.User, ladder *model.Ladder, isDeleted bool) error {
+	return conn.Exec(
+		ladderInsertQuery,
+		ladder.ID,
+		user.ID,
+		user.Username,
+		ladder.From.Ticker,
+		ladder.From.Name,
+		ladder.To.Ticker,
+		ladder.To.Name,
+		ladder.Low,
+		ladder.High,
+		ladder.Step,
+		ladder.Note,
+		database.BoolToUint(isDeleted),
+	)
+}
+
+// ladderRungValues returns the price for every rung of a ladder, lowest first.
+func ladderRungValues(ladder *model.Ladder) []decimal.Decimal {
+	var valueList []decimal.Decimal
+
+	for value := ladder.Low; value.LessThanOrEqual(ladder.High); value = value.Add(ladder.Step) {
+		valueList = append(valueList, value)
+	}
+
+	return valueList
+}
+
+// parseLadder validates the fields for a ladder and loads its currencies.
+func parseLadder(conn database.Queryable, values url.Values, ladder *model.Ladder) error {
+	fromTicker := values.Get("from")
+	toTicker := values.Get("to")
+
+	if fromTicker == "" || toTicker == "" {
+		return util.ValidationError("Invalid currency ticker")
+	}
+
+	if fromTicker == toTicker {
+		return util.ValidationError("From and to currencies cannot be the same")
+	}
+
+	low, err := decimal.NewFromString(values.Get("low"))
+
+	if err != nil || !low.IsPositive() {
+		return util.ValidationError("Invalid low value")
+	}
+
+	high, err := decimal.NewFromString(values.Get("high"))
+
+	if err != nil || high.LessThan(low) {
+		return util.ValidationError("The high value must be at least the low value")
+	}
+
+	step, err := decimal.NewFromString(values.Get("step"))
+
+	if err != nil || !step.IsPositive() {
+		return util.ValidationError("The step must be positive")
+	}
+
+	if high.Sub(low).Div(step).GreaterThanOrEqual(decimal.NewFromInt(maxLadderRungs)) {
+		return util.ValidationError("Ladders can have at most 100 rungs")
+	}
+
+	note := strings.TrimSpace(values.Get("note"))
+
+	if len(note) > maxNoteLength {
+		return util.ValidationError("Notes must be at most 500 characters")
+	}
+
+	ladder.Low = low
+	ladder.High = high
+	ladder.Step = step
+	ladder.Note = note
+
+	if err := loadAlertCurrency(conn, &ladder.From, fromTicker); err != nil {
+		return err
+	}
+
+	return loadAlertCurrency(conn, &ladder.To, toTicker)
+}
+
+// buildLadderAlerts returns an alert for every rung of a ladder.
+//
+// Rungs above the latest price alert when the price rises to them, and rungs
+// below the latest price alert when the price falls to them. A rung at the
+// latest price has already been reached, so no alert is made for it.
+func buildLadderAlerts(conn database.Queryable, ladder *model.Ladder) ([]model.Alert, error) {
+	var price model.Price
+
+	if err := query.LoadLatestPrice(conn, &price, ladder.From.Ticker, ladder.To.Ticker); err != nil {
+		if err == database.ErrNoRows {
+			return nil, util.ValidationError("No recent price for " + ladder.From.Ticker + "/" + ladder.To.Ticker)
+		}
+
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var alertList []model.Alert
+
+	for _, value := range ladderRungValues(ladder) {
+		if value.Equal(price.Value) {
+			continue
+		}
+
+		alertID, err := database.RandomID()
+
+		if err != nil {
+			return nil, err
+		}
+
+		alertList = append(alertList, model.Alert{
+			ID:       alertID,
+			From:     ladder.From,
+			To:       ladder.To,
+			Value:    value,
+			Above:    value.GreaterThan(price.Value),
+			Time:     now,
+			Note:     ladder.Note,
+			Labels:   []string{},
+			LadderID: ladder.ID,
+		})
+	}
+
+	if len(alertList) == 0 {
+		return nil, util.ValidationError("The only rung of the ladder is at the latest price")
+	}
+
+	return alertList, nil
+}
+
+// loadLadderAlertList loads the alerts for the rungs of a ladder.
+func loadLadderAlertList(conn *database.Conn, user *model.User, ladderID int64, alertList *[]model.Alert) error {
+	return model.LoadList(
+		conn,
+		alertList,
+		maxLadderRungs,
+		scanAlert,
+		alertQuery+"where is_deleted = 0 and ladder_id = ? order by value",
+		user.ID,
+		ladderID,
+	)
+}
+
+// deleteLadderAlerts deletes the alerts for every rung of a ladder.
+func deleteLadderAlerts(conn *database.Conn, user *model.User, ladderID int64) error {
+	var alertList []model.Alert
+
+	if err := loadLadderAlertList(conn, user, ladderID, &alertList); err != nil {
+		return err
+	}
+
+	return saveAlertList(conn, user, alertList, true)
+}
+
+// LadderProgress is a ladder with counts of how many rungs have been reached.
+type LadderProgress struct {
+	Ladder  model.Ladder
+	Total   int
+	Reached int
+}
+
+// loadLadderProgressList loads ladders for the alert list page.
+//
+// Alerts for ladder rungs are counted for progress and removed from the
+// main alert list, so the list isn't flooded with rungs.
+func loadLadderProgressList(conn *database.Conn, data *AlertListPageData) error {
+	var ladderList []model.Ladder
+
+	if err := loadLadderList(conn, data.User.ID, &ladderList); err != nil {
+		return err
+	}
+
+	progressMap := make(map[int64]*LadderProgress, len(ladderList))
+	data.LadderList = make([]LadderProgress, len(ladderList))
+
+	for i, ladder := range ladderList {
+		data.LadderList[i].Ladder = ladder
+		progressMap[ladder.ID] = &data.LadderList[i]
+	}
+
+	alertList := make([]model.Alert, 0, len(data.AlertList))
+
+	for _, alert := range data.AlertList {
+		if alert.LadderID == 0 {
+			alertList = append(alertList, alert)
+		} else if progress, ok := progressMap[alert.LadderID]; ok {
+			progress.Total++
+
+			if alert.Sent {
+				progress.Reached++
+			}
+		}
+	}
+
+	data.AlertList = alertList
+
+	return nil
+}
+
+type AlertLadderPageData struct {
+	User             model.User
+	Ladder           model.Ladder
+	Progress         LadderProgress
+	RungList         []model.Alert
+	FromCurrencyList []model.Currency
+	ToCurrencyList   []model.Currency
+}
+
+func loadLadderForRequest(
+	conn *database.Conn,
+	writer http.ResponseWriter,
+	request *http.Request,
+	user *model.User,
+	ladder *model.Ladder,
+) bool {
+	ladderID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+
+	if err != nil {
+		util.RespondNotFound(writer)
+
+		return false
+	}
+
+	row := conn.QueryRow(ladderQuery+"where ladder_id = ?", user.ID, ladderID)
+
+	if err := scanLadder(row, ladder); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// HandleLadder shows a ladder with the progress of each rung.
+func HandleLadder(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := AlertLadderPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		http.Redirect(writer, request, "/login", http.StatusFound)
+
+		return
+	}
+
+	if !loadLadderForRequest(conn, writer, request, &data.User, &data.Ladder) {
+		return
+	}
+
+	if err := loadLadderAlertList(conn, &data.User, data.Ladder.ID, &data.RungList); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	data.Progress.Ladder = data.Ladder
+	data.Progress.Total = len(data.RungList)
+
+	for _, alert := range data.RungList {
+		if alert.Sent {
+			data.Progress.Reached++
+		}
+	}
+
+	if err := loadCurrencyList(conn, &data.FromCurrencyList); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	data.ToCurrencyList = query.GetToCurrencyList()
+	template.Render(template.AlertLadder, writer, data)
+}
+
+// HandleSubmitLadder creates a ladder and an alert for each of its rungs.
+func HandleSubmitLadder(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var ladder model.Ladder
+
+	if !loadUser(conn, writer, request, &user) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	request.ParseForm()
+
+	if err := parseLadder(conn, request.Form, &ladder); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	var err error
+	ladder.ID, err = database.RandomID()
+
+	if err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	alertList, err := buildLadderAlerts(conn, &ladder)
+
+	if err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	// The ladder is saved first, so rungs are never left without a ladder.
+	if err := saveLadder(conn, &user, &ladder, false); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	if err := saveAlertList(conn, &user, alertList, false); err != nil {
+		util.RespondInternalServerError(writer, err)
+	} else {
+		http.Redirect(writer, request, "/alert", http.StatusFound)
+	}
+}
+
+// HandleUpdateLadder replaces the rungs of a ladder with new ones.
+func HandleUpdateLadder(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var ladder model.Ladder
+
+	if !loadUser(conn, writer, request, &user) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	if !loadLadderForRequest(conn, writer, request, &user, &ladder) {
+		return
+	}
+
+	request.ParseForm()
+
+	if err := parseLadder(conn, request.Form, &ladder); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	alertList, err := buildLadderAlerts(conn, &ladder)
+
+	if err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	// The old rungs are only deleted once the new rungs are saved, so a
+	// failure can't leave the ladder without any rungs.
+	var oldList []model.Alert
+
+	if err := loadLadderAlertList(conn, &user, ladder.ID, &oldList); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	if err := saveLadder(conn, &user, &ladder, false); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	if err := saveAlertList(conn, &user, alertList, false); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	if err := saveAlertList(conn, &user, oldList, true); err != nil {
+		util.RespondInternalServerError(writer, err)
+	} else {
+		http.Redirect(writer, request, "/alert/ladder/"+strconv.FormatInt(ladder.ID, 10), http.StatusFound)
+	}
+}
+
+// HandleDeleteLadder deletes a ladder and all of its rungs.
+func HandleDeleteLadder(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var ladder model.Ladder
+
+	if !loadUser(conn, writer, request, &user) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	if !loadLadderForRequest(conn, writer, request, &user, &ladder) {
+		return
+	}
+
+	if err := deleteLadderAlerts(conn, &user, ladder.ID); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	if err := saveLadder(conn, &user, &ladder, true); err != nil {
+		util.RespondInternalServerError(writer, err)
+	} else {
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
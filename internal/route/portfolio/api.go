@@ -0,0 +1,277 @@
+package portfolio
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/token"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// maxAPIBodySize is the largest request body accepted by the API.
+const maxAPIBodySize = 1 << 20
+
+// APIAsset is a valued asset in the JSON API.
+type APIAsset struct {
+	Currency         util.APICurrency `json:"currency"`
+	Amount           decimal.Decimal  `json:"amount"`
+	Purchased        decimal.Decimal  `json:"purchased"`
+	Value            decimal.Decimal  `json:"value"`
+	ShareOfPortfolio decimal.Decimal  `json:"share_of_portfolio"`
+	Performance      decimal.Decimal  `json:"performance"`
+}
+
+// APIPortfolio is a portfolio with its totals in the JSON API.
+//
+// Values are in the portfolio currency, and percentages are from 0 to 100.
+type APIPortfolio struct {
+	ID                 string           `json:"id"`
+	Name               string           `json:"name"`
+	Currency           util.APICurrency `json:"currency"`
+	Cash               decimal.Decimal  `json:"cash"`
+	CostBasisMethod    string           `json:"cost_basis_method"`
+	TotalPurchased     decimal.Decimal  `json:"total_purchased"`
+	TotalValue         decimal.Decimal  `json:"total_value"`
+	TotalProfit        decimal.Decimal  `json:"total_profit"`
+	TotalFees          decimal.Decimal  `json:"total_fees"`
+	AveragePerformance decimal.Decimal  `json:"average_performance"`
+	Assets             []APIAsset       `json:"assets"`
+}
+
+func newAPIPortfolio(summary *PortfolioSummary) APIPortfolio {
+	portfolio := APIPortfolio{
+		ID:                 strconv.FormatInt(summary.Portfolio.ID, 10),
+		Name:               summary.Portfolio.Name,
+		Currency:           util.NewAPICurrency(&summary.Portfolio.Currency),
+		Cash:               summary.Portfolio.Cash,
+		CostBasisMethod:    summary.Portfolio.CostBasisMethod,
+		TotalPurchased:     summary.TotalPurchased,
+		TotalValue:         summary.TotalValue,
+		TotalProfit:        summary.TotalProfit,
+		TotalFees:          summary.TotalFees,
+		AveragePerformance: summary.AveragePerformance,
+		Assets:             make([]APIAsset, len(summary.AssetList)),
+	}
+
+	for i, asset := range summary.AssetList {
+		portfolio.Assets[i] = APIAsset{
+			Currency:         util.NewAPICurrency(&asset.Currency),
+			Amount:           asset.Amount,
+			Purchased:        asset.Purchased,
+			Value:            asset.Value,
+			ShareOfPortfolio: asset.ShareOfPortfolio,
+			Performance:      asset.Performance,
+		}
+	}
+
+	return portfolio
+}
+
+// apiTrade is the request body for buying or selling an asset.
+//
+// FeeCurrency is "fiat" or "crypto", and defaults to "fiat".
+type apiTrade struct {
+	Crypto      json.Number `json:"crypto"`
+	Fiat        json.Number `json:"fiat"`
+	Fee         json.Number `json:"fee"`
+	FeeCurrency string      `json:"fee_currency"`
+}
+
+// values converts a trade to the values submitted by the trade form.
+func (trade *apiTrade) values() url.Values {
+	return url.Values{
+		"crypto":       {trade.Crypto.String()},
+		"fiat":         {trade.Fiat.String()},
+		"fee":          {trade.Fee.String()},
+		"fee_currency": {trade.FeeCurrency},
+	}
+}
+
+// apiSwap is the request body for swapping an asset for another.
+//
+// Received is optional, and is worked out from the latest prices if missing.
+type apiSwap struct {
+	To       string      `json:"to"`
+	Crypto   json.Number `json:"crypto"`
+	Received json.Number `json:"received"`
+}
+
+// values converts a swap to the values submitted by the swap form.
+func (swap *apiSwap) values() url.Values {
+	return url.Values{
+		"to":       {swap.To},
+		"crypto":   {swap.Crypto.String()},
+		"received": {swap.Received.String()},
+	}
+}
+
+// loadAPIPortfolioID reads the portfolio a request is for from the
+// `portfolio` query parameter, which defaults to the first portfolio.
+func loadAPIPortfolioID(writer http.ResponseWriter, request *http.Request, portfolioID *int64) bool {
+	value := request.URL.Query().Get("portfolio")
+
+	if value == "" {
+		*portfolioID = 0
+
+		return true
+	}
+
+	var err error
+
+	if *portfolioID, err = strconv.ParseInt(value, 10, 64); err != nil {
+		util.RespondJSONStatus(writer, http.StatusBadRequest, "Invalid portfolio")
+
+		return false
+	}
+
+	return true
+}
+
+func respondAPIPortfolio(conn *database.Conn, writer http.ResponseWriter, user *model.User, portfolioID int64) {
+	var summary PortfolioSummary
+
+	if err := LoadPortfolioSummary(conn, user, portfolioID, &summary); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondJSONStatus(writer, http.StatusNotFound, "Portfolio not configured")
+		} else {
+			util.RespondJSONError(writer, err)
+		}
+
+		return
+	}
+
+	util.RespondJSON(writer, http.StatusOK, newAPIPortfolio(&summary))
+}
+
+// HandleAPIPortfolio gets a portfolio of a user with the value of each asset.
+func HandleAPIPortfolio(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var portfolioID int64
+
+	if token.LoadAPIUser(conn, writer, request, &user) && loadAPIPortfolioID(writer, request, &portfolioID) {
+		respondAPIPortfolio(conn, writer, &user, portfolioID)
+	}
+}
+
+// HandleAPIPortfolioList lists every portfolio of a user with the value of
+// each asset.
+func HandleAPIPortfolioList(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+
+	if !token.LoadAPIUser(conn, writer, request, &user) {
+		return
+	}
+
+	var portfolioList []model.Portfolio
+
+	if err := loadPortfolioList(conn, &user, &portfolioList); err != nil {
+		util.RespondJSONError(writer, err)
+
+		return
+	}
+
+	apiPortfolioList := make([]APIPortfolio, len(portfolioList))
+
+	for i, portfolio := range portfolioList {
+		var summary PortfolioSummary
+
+		if err := LoadPortfolioSummary(conn, &user, portfolio.ID, &summary); err != nil {
+			util.RespondJSONError(writer, err)
+
+			return
+		}
+
+		apiPortfolioList[i] = newAPIPortfolio(&summary)
+	}
+
+	util.RespondJSON(writer, http.StatusOK, apiPortfolioList)
+}
+
+func handleAPIAssetAdjustment(
+	conn *database.Conn,
+	writer http.ResponseWriter,
+	request *http.Request,
+	adjust func(data *AssetAdjustData) error,
+) {
+	data := AssetAdjustData{}
+
+	var portfolioID int64
+
+	if !token.LoadAPIUser(conn, writer, request, &data.User) || !loadAPIPortfolioID(writer, request, &portfolioID) {
+		return
+	}
+
+	var trade apiTrade
+
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxAPIBodySize))
+
+	if err := decoder.Decode(&trade); err != nil {
+		util.RespondJSONStatus(writer, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+
+		return
+	}
+
+	if err := adjustAsset(conn, portfolioID, mux.Vars(request)["ticker"], trade.values(), &data, adjust); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondJSONStatus(writer, http.StatusNotFound, "Unknown currency")
+		} else {
+			util.RespondJSONError(writer, err)
+		}
+
+		return
+	}
+
+	respondAPIPortfolio(conn, writer, &data.User, portfolioID)
+}
+
+// HandleAPIAssetBuy swaps some cash for a cryptocurrency asset, responding
+// with the updated portfolio.
+func HandleAPIAssetBuy(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	handleAPIAssetAdjustment(conn, writer, request, buyAsset)
+}
+
+// HandleAPIAssetSell swaps some cryptocurrency asset for cash, responding
+// with the updated portfolio.
+func HandleAPIAssetSell(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	handleAPIAssetAdjustment(conn, writer, request, sellAsset)
+}
+
+// HandleAPIAssetSwap swaps some cryptocurrency asset for another asset,
+// responding with the updated portfolio.
+func HandleAPIAssetSwap(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := AssetAdjustData{}
+
+	var portfolioID int64
+
+	if !token.LoadAPIUser(conn, writer, request, &data.User) || !loadAPIPortfolioID(writer, request, &portfolioID) {
+		return
+	}
+
+	var swap apiSwap
+
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxAPIBodySize))
+
+	if err := decoder.Decode(&swap); err != nil {
+		util.RespondJSONStatus(writer, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+
+		return
+	}
+
+	if err := swapAssets(conn, portfolioID, mux.Vars(request)["ticker"], swap.values(), &data); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondJSONStatus(writer, http.StatusNotFound, "Unknown currency")
+		} else {
+			util.RespondJSONError(writer, err)
+		}
+
+		return
+	}
+
+	respondAPIPortfolio(conn, writer, &data.User, portfolioID)
+}
@@ -0,0 +1,172 @@
+package portfolio
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// BackfillOpeningBalances adds "Opening balance" deposits to a portfolio set
+// up before transactions and cash flows were recorded.
+//
+// Every asset without transactions gets a deposit of the whole holding, and a
+// portfolio without cash flows gets a deposit of the cash it started with.
+// Only the latest holdings were stored before, so the real acquisition dates
+// are unknown. Deposits are dated at `openedAt` when it is set, or else at the
+// earliest time the portfolio or asset was recorded. Running this again
+// changes nothing, as deposits are only added where none exist.
+func BackfillOpeningBalances(conn *database.Conn, user *model.User, portfolioID int64, openedAt *time.Time) error {
+	var portfolio model.Portfolio
+	var assetList []TrackedAsset
+
+	if err := loadPortfolio(conn, user, portfolioID, &portfolio); err != nil {
+		return err
+	}
+
+	if err := loadAssetList(conn, user.ID, portfolio.ID, &assetList); err != nil {
+		return err
+	}
+
+	for _, trackedAsset := range assetList {
+		if err := backfillAssetLedger(conn, user, &portfolio, &trackedAsset.Asset, openedAt); err != nil {
+			return err
+		}
+	}
+
+	return backfillCashFlows(conn, user, &portfolio, openedAt)
+}
+
+// backfillAssetLedger adds an opening deposit of the whole holding of an
+// asset if it has no transactions.
+func backfillAssetLedger(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	asset *model.Asset,
+	openedAt *time.Time,
+) error {
+	var transactionList []model.Transaction
+
+	if err := queryLedger(conn, user, portfolio, &asset.Currency, &transactionList); err != nil {
+		return err
+	}
+
+	if len(transactionList) > 0 || !asset.Amount.IsPositive() {
+		return nil
+	}
+
+	var depositTime time.Time
+
+	if openedAt != nil {
+		depositTime = *openedAt
+	} else {
+		row := conn.QueryRow(
+			`select min(updated_at)
+			from crypto_asset
+			where user_id = ? and portfolio_id = ? and currency_ticker = ?`,
+			user.ID,
+			portfolio.ID,
+			asset.Currency.Ticker,
+		)
+
+		if err := row.Scan(&depositTime); err != nil {
+			return err
+		}
+	}
+
+	opening := model.Transaction{
+		// The ID is fixed, so running the backfill twice at once can't add two deposits.
+		ID:          database.HashID(fmt.Sprintf("opening:%d:%d:%s", user.ID, portfolio.ID, asset.Currency.Ticker)),
+		PortfolioID: portfolio.ID,
+		Currency:    asset.Currency,
+		Kind:        ledger.Deposit,
+		Time:        depositTime,
+		Quantity:    asset.Amount,
+		Price:       asset.Purchased.DivRound(asset.Amount, priceScale),
+		Fee:         decimal.Zero,
+		Note:        "Opening balance",
+	}
+
+	return saveTransaction(conn, user, &opening, false)
+}
+
+// backfillCashFlows adds an opening deposit of the cash a portfolio started
+// with if it has no cash flows.
+//
+// The opening cash is the cash held now, less the cash every trade has added
+// or spent since. The deposit is made no later than the first trade, and is
+// recorded even when there was no cash, so every portfolio has one.
+func backfillCashFlows(conn *database.Conn, user *model.User, portfolio *model.Portfolio, openedAt *time.Time) error {
+	var flowList []model.CashFlow
+	var transactionList []model.Transaction
+
+	if err := queryCashFlowList(conn, user, portfolio, &flowList); err != nil {
+		return err
+	}
+
+	if len(flowList) > 0 {
+		return nil
+	}
+
+	if err := model.LoadList(
+		conn,
+		&transactionList,
+		10,
+		scanTransaction,
+		transactionQuery+`where is_deleted = 0 and portfolio_id = ?`,
+		user.ID,
+		portfolio.ID,
+	); err != nil {
+		return err
+	}
+
+	var depositTime time.Time
+
+	if openedAt != nil {
+		depositTime = *openedAt
+	} else {
+		row := conn.QueryRow(
+			`select min(updated_at)
+			from crypto_portfolio
+			where user_id = ? and portfolio_id = ?`,
+			user.ID,
+			portfolio.ID,
+		)
+
+		if err := row.Scan(&depositTime); err != nil {
+			return err
+		}
+	}
+
+	amount := portfolio.Cash
+
+	for i := range transactionList {
+		transaction := &transactionList[i]
+		amount = amount.Sub(ledger.CashChange(transaction))
+
+		if transaction.Time.Before(depositTime) {
+			depositTime = transaction.Time
+		}
+	}
+
+	if amount.IsNegative() {
+		amount = decimal.Zero
+	}
+
+	opening := model.CashFlow{
+		// The ID is fixed, so running the backfill twice at once can't add two deposits.
+		ID:          database.HashID(fmt.Sprintf("opening-cash:%d:%d", user.ID, portfolio.ID)),
+		PortfolioID: portfolio.ID,
+		Kind:        ledger.Deposit,
+		Time:        depositTime,
+		Currency:    portfolio.Currency,
+		Amount:      amount,
+		Note:        "Opening balance",
+	}
+
+	return saveCashFlow(conn, user, &opening, false)
+}
@@ -0,0 +1,426 @@
+package portfolio
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+var cashFlowQuery = `
+select
+	cash_flow_id,
+	portfolio_id,
+	kind,
+	flow_time,
+	currency_ticker,
+	currency_name,
+	amount,
+	note,
+	transfer_id,
+	is_deleted
+from (
+	select *
+	from crypto_cash_flow
+	where user_id = ?
+	order by updated_at desc
+	limit 1 by cash_flow_id
+)
+`
+
+func scanCashFlow(row database.Row, flow *model.CashFlow) error {
+	var isDeleted uint8
+
+	if err := row.Scan(
+		&flow.ID,
+		&flow.PortfolioID,
+		&flow.Kind,
+		&flow.Time,
+		&flow.Currency.Ticker,
+		&flow.Currency.Name,
+		&flow.Amount,
+		&flow.Note,
+		&flow.TransferID,
+		&isDeleted,
+	); err != nil {
+		return err
+	}
+
+	if isDeleted == 1 {
+		return database.ErrNoRows
+	}
+
+	return nil
+}
+
+var cashFlowInsertQuery = `
+insert into crypto_cash_flow
+	(cash_flow_id, user_id, portfolio_id, username, kind, flow_time,
+	 currency_ticker, currency_name, amount, note, transfer_id, updated_at, is_deleted)
+values (?, ?, ?, ?, ?, ?,
+	?, ?, ?, ?, ?, now64(9), ?)
+`
+
+// saveCashFlow writes a new version of a cash flow, or marks it as deleted.
+func saveCashFlow(conn database.Queryable, user *model.User, flow *model.CashFlow, isDeleted bool) error {
+	return conn.Exec(
+		cashFlowInsertQuery,
+		flow.ID,
+		user.ID,
+		flow.PortfolioID,
+		user.Username,
+		flow.Kind,
+		flow.Time,
+		flow.Currency.Ticker,
+		flow.Currency.Name,
+		flow.Amount,
+		flow.Note,
+		flow.TransferID,
+		database.BoolToUint(isDeleted),
+	)
+}
+
+// cashFlowChange returns the change in the cash of a portfolio from a cash flow.
+func cashFlowChange(flow *model.CashFlow) decimal.Decimal {
+	if flow.Kind == ledger.Withdrawal {
+		return flow.Amount.Neg()
+	}
+
+	return flow.Amount
+}
+
+// queryCashFlowList loads every cash flow for a portfolio, in the order they
+// happened.
+func queryCashFlowList(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	flowList *[]model.CashFlow,
+) error {
+	return model.LoadList(
+		conn,
+		flowList,
+		10,
+		scanCashFlow,
+		cashFlowQuery+`where is_deleted = 0 and portfolio_id = ?
+		order by flow_time, cash_flow_id`,
+		user.ID,
+		portfolio.ID,
+	)
+}
+
+// loadCashFlowList loads every cash flow for a portfolio, in the order they
+// happened.
+//
+// errNotBackfilled is returned for a portfolio without any cash flows, as
+// every portfolio starts with an opening balance once it has been recorded.
+func loadCashFlowList(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	flowList *[]model.CashFlow,
+) error {
+	if err := queryCashFlowList(conn, user, portfolio, flowList); err != nil {
+		return err
+	}
+
+	if len(*flowList) == 0 {
+		return errNotBackfilled
+	}
+
+	return nil
+}
+
+// errOpeningBalance is returned for deleting the opening balance of a
+// portfolio, which is its first cash flow. Keeping it means a portfolio always
+// has a cash flow, and never looks like it needs to be backfilled.
+var errOpeningBalance = util.ValidationError("The opening balance of a portfolio can't be deleted")
+
+// saveCashFlowChange replaces one cash flow for a portfolio with another, and
+// saves the cash flow and the cash left in the portfolio.
+//
+// `previous` is nil when adding a cash flow, and `next` is nil when deleting
+// one. Changes that would leave less than no cash are rejected.
+func saveCashFlowChange(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	previous *model.CashFlow,
+	next *model.CashFlow,
+) error {
+	if err := applyCashFlowChange(conn, user, portfolio, previous, next); err != nil {
+		return err
+	}
+
+	return writeCashFlowChange(conn, user, portfolio, previous, next)
+}
+
+// applyCashFlowChange works out the cash left in a portfolio after replacing
+// one cash flow with another, without saving anything.
+//
+// The opening balance of a portfolio can't be deleted.
+func applyCashFlowChange(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	previous *model.CashFlow,
+	next *model.CashFlow,
+) error {
+	var flowList []model.CashFlow
+
+	// Cash can't change until the opening balance has been recorded.
+	if err := loadCashFlowList(conn, user, portfolio, &flowList); err != nil {
+		return err
+	}
+
+	if next == nil && previous != nil && flowList[0].ID == previous.ID {
+		return errOpeningBalance
+	}
+
+	cash := portfolio.Cash
+
+	if previous != nil {
+		cash = cash.Sub(cashFlowChange(previous))
+	}
+
+	if next != nil {
+		next.PortfolioID = portfolio.ID
+		cash = cash.Add(cashFlowChange(next))
+	}
+
+	if cash.IsNegative() && cash.LessThan(portfolio.Cash) {
+		return util.ValidationError("You can't withdraw more cash than you have")
+	}
+
+	portfolio.Cash = cash
+
+	return nil
+}
+
+// writeCashFlowChange saves a change made by applyCashFlowChange.
+func writeCashFlowChange(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	previous *model.CashFlow,
+	next *model.CashFlow,
+) error {
+	if next == nil && previous != nil {
+		if err := saveCashFlow(conn, user, previous, true); err != nil {
+			return err
+		}
+	}
+
+	if next != nil {
+		if err := saveCashFlow(conn, user, next, false); err != nil {
+			return err
+		}
+	}
+
+	return updatePortfolio(conn, user, portfolio)
+}
+
+// newCashFlow creates a cash flow for a portfolio now.
+func newCashFlow(portfolio *model.Portfolio, kind string, amount decimal.Decimal, note string) (model.CashFlow, error) {
+	var err error
+
+	flow := model.CashFlow{
+		PortfolioID: portfolio.ID,
+		Kind:        kind,
+		Time:        time.Now().UTC(),
+		Currency:    portfolio.Currency,
+		Amount:      amount,
+		Note:        note,
+	}
+
+	flow.ID, err = database.RandomID()
+
+	return flow, err
+}
+
+// parseCash reads the optional cash a new portfolio starts with.
+func parseCash(values url.Values) (decimal.Decimal, error) {
+	if values.Get("cash") == "" {
+		return decimal.Zero, nil
+	}
+
+	cash, err := decimal.NewFromString(values.Get("cash"))
+
+	if err != nil {
+		return decimal.Zero, util.ValidationError("Invalid cash value")
+	}
+
+	if cash.IsNegative() {
+		return decimal.Zero, util.ValidationError("Cash must be non-negative")
+	}
+
+	return cash, nil
+}
+
+// openPortfolio saves a new portfolio, with the cash it starts with recorded
+// as a deposit.
+//
+// The deposit is recorded even when there is no cash, so every portfolio has
+// an opening balance.
+func openPortfolio(conn *database.Conn, user *model.User, portfolio *model.Portfolio, cash decimal.Decimal) error {
+	flow, err := newCashFlow(portfolio, ledger.Deposit, cash, "Opening balance")
+
+	if err != nil {
+		return err
+	}
+
+	if err := saveCashFlow(conn, user, &flow, false); err != nil {
+		return err
+	}
+
+	portfolio.Cash = cash
+
+	return updatePortfolio(conn, user, portfolio)
+}
+
+// parseCashFlow reads the amount, time and note of a cash flow.
+//
+// The time defaults to now.
+func parseCashFlow(values url.Values, flow *model.CashFlow) error {
+	var err error
+
+	if flow.Amount, err = decimal.NewFromString(values.Get("amount")); err != nil {
+		return util.ValidationError("Invalid amount")
+	}
+
+	if !flow.Amount.IsPositive() {
+		return util.ValidationError("Amount must be positive")
+	}
+
+	if value := values.Get("time"); value != "" {
+		if flow.Time, err = parseTransactionTime(value); err != nil {
+			return err
+		}
+
+		if flow.Time.After(time.Now()) {
+			return util.ValidationError("Cash flows can't be in the future")
+		}
+	}
+
+	flow.Note = strings.TrimSpace(values.Get("note"))
+
+	if len(flow.Note) > maxTransactionNoteLength {
+		return util.ValidationError("Notes must be at most 500 characters")
+	}
+
+	return nil
+}
+
+// addCashFlow deposits cash into a portfolio or withdraws cash from it.
+func addCashFlow(
+	conn *database.Conn,
+	portfolioID int64,
+	kind string,
+	values url.Values,
+	data *PortfolioPageData,
+) error {
+	if err := loadPortfolio(conn, &data.User, portfolioID, &data.Portfolio); err != nil {
+		if err == database.ErrNoRows {
+			return util.ValidationError("Portfolio not configured")
+		}
+
+		return err
+	}
+
+	flow, err := newCashFlow(&data.Portfolio, kind, decimal.Zero, "")
+
+	if err != nil {
+		return err
+	}
+
+	if err := parseCashFlow(values, &flow); err != nil {
+		return err
+	}
+
+	return saveCashFlowChange(conn, &data.User, &data.Portfolio, nil, &flow)
+}
+
+func handleCashFlow(conn *database.Conn, writer http.ResponseWriter, request *http.Request, kind string) {
+	data := PortfolioPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	request.ParseForm()
+
+	if err := addCashFlow(conn, selectedPortfolioID(request), kind, request.Form, &data); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	http.Redirect(writer, request, "/portfolio", http.StatusFound)
+}
+
+// HandleCashDeposit adds cash to the selected portfolio.
+func HandleCashDeposit(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	handleCashFlow(conn, writer, request, ledger.Deposit)
+}
+
+// HandleCashWithdrawal takes cash out of the selected portfolio.
+func HandleCashWithdrawal(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	handleCashFlow(conn, writer, request, ledger.Withdrawal)
+}
+
+// HandleDeleteCashFlow removes a mistaken deposit or withdrawal.
+//
+// Deleting a cash flow in a transfer deletes both sides of the transfer.
+func HandleDeleteCashFlow(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := PortfolioPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	flowID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+
+	if err != nil {
+		util.RespondNotFound(writer)
+
+		return
+	}
+
+	var previous model.CashFlow
+
+	err = scanCashFlow(conn.QueryRow(cashFlowQuery+"where cash_flow_id = ?", data.User.ID, flowID), &previous)
+
+	if err == nil && previous.TransferID != 0 {
+		err = deleteTransfer(conn, &data.User, previous.TransferID)
+	} else if err == nil {
+		err = loadPortfolio(conn, &data.User, previous.PortfolioID, &data.Portfolio)
+
+		if err == nil {
+			err = saveCashFlowChange(conn, &data.User, &data.Portfolio, &previous, nil)
+		}
+	}
+
+	if err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondError(writer, err)
+		}
+
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package portfolio
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/chart"
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/query"
+)
+
+// ChartRange is a range of time a chart can show.
+type ChartRange struct {
+	Name     string
+	Duration time.Duration
+	// Interval is the time between each point on the chart.
+	Interval   time.Duration
+	TimeFormat string
+}
+
+var chartRangeList = []ChartRange{
+	{Name: "24h", Duration: 24 * time.Hour, Interval: 15 * time.Minute, TimeFormat: "Jan 2 15:04"},
+	{Name: "7d", Duration: 7 * 24 * time.Hour, Interval: 2 * time.Hour, TimeFormat: "Jan 2"},
+	{Name: "30d", Duration: 30 * 24 * time.Hour, Interval: 6 * time.Hour, TimeFormat: "Jan 2"},
+	{Name: "1y", Duration: 365 * 24 * time.Hour, Interval: 24 * time.Hour, TimeFormat: "Jan 2 2006"},
+}
+
+// defaultChartRange is the range shown when none is selected.
+const defaultChartRange = 2
+
+// parseChartRange returns the range selected with `?range=`.
+func parseChartRange(values url.Values) ChartRange {
+	name := values.Get("range")
+
+	for _, chartRange := range chartRangeList {
+		if chartRange.Name == name {
+			return chartRange
+		}
+	}
+
+	return chartRangeList[defaultChartRange]
+}
+
+// loadAssetChart loads a chart of the price of an asset in the portfolio currency.
+func loadAssetChart(
+	conn *database.Conn,
+	asset *model.Asset,
+	currency *model.Currency,
+	chartRange *ChartRange,
+	assetChart *chart.Chart,
+) error {
+	var priceList []model.Price
+
+	end := time.Now().UTC()
+	start := end.Add(-chartRange.Duration)
+
+	if _, err := query.LoadConvertedClosingPrices(
+		conn,
+		&priceList,
+		asset.Currency.Ticker,
+		currency.Ticker,
+		start,
+		end,
+		chartRange.Interval,
+	); err != nil {
+		return err
+	}
+
+	series := chart.Series{
+		Name:      asset.Currency.Ticker + "/" + currency.Ticker,
+		Class:     "price",
+		PointList: make([]chart.Point, len(priceList)),
+	}
+
+	for i, price := range priceList {
+		series.PointList[i] = chart.Point{Time: price.Time, Value: price.Value}
+	}
+
+	assetChart.SeriesList = []chart.Series{series}
+	assetChart.TimeFormat = chartRange.TimeFormat
+	assetChart.EmptyText = "No prices for this range"
+
+	return nil
+}
+
+// addTradeMarkers marks the buys and sells in the range of a chart.
+func addTradeMarkers(assetChart *chart.Chart, transactionList []model.Transaction, chartRange *ChartRange) {
+	start := time.Now().UTC().Add(-chartRange.Duration)
+
+	for _, transaction := range transactionList {
+		if transaction.Kind != ledger.Buy && transaction.Kind != ledger.Sell {
+			continue
+		}
+
+		if transaction.Time.Before(start) {
+			continue
+		}
+
+		assetChart.MarkerList = append(assetChart.MarkerList, chart.Marker{
+			Time:  transaction.Time,
+			Value: transaction.Price,
+			Class: transaction.Kind,
+			Label: fmt.Sprintf(
+				"%s %s at %s",
+				transaction.Kind,
+				transaction.Quantity.String(),
+				transaction.Price.StringFixed(2),
+			),
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package portfolio
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/query"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/dense-analysis/pricewarp/internal/template"
+	"github.com/shopspring/decimal"
+)
+
+// exchangeTicker is the currency amounts are converted between portfolio
+// currencies through, as every portfolio currency has prices against it.
+const exchangeTicker = "BTC"
+
+// loadBTCValue loads the latest value of one BTC in a currency.
+func loadBTCValue(conn *database.Conn, currency *model.Currency) (decimal.Decimal, error) {
+	if currency.Ticker == exchangeTicker {
+		return One, nil
+	}
+
+	var price model.Price
+
+	if err := query.LoadLatestPrice(conn, &price, exchangeTicker, currency.Ticker); err != nil {
+		return decimal.Zero, err
+	}
+
+	return price.Value, nil
+}
+
+// loadExchangeRate loads the rate for converting amounts in one currency to
+// another, worked out from the latest prices of BTC in both.
+func loadExchangeRate(conn *database.Conn, from *model.Currency, to *model.Currency) (decimal.Decimal, error) {
+	if from.Ticker == to.Ticker {
+		return One, nil
+	}
+
+	fromValue, err := loadBTCValue(conn, from)
+
+	if err != nil && err != database.ErrNoRows {
+		return decimal.Zero, err
+	}
+
+	toValue, err := loadBTCValue(conn, to)
+
+	if err != nil && err != database.ErrNoRows {
+		return decimal.Zero, err
+	}
+
+	if !fromValue.IsPositive() || !toValue.IsPositive() {
+		return decimal.Zero, util.ValidationError("There is no recent price to convert " + from.Ticker + " to " + to.Ticker)
+	}
+
+	return toValue.Div(fromValue), nil
+}
+
+// dailyRates maps the start of each day to the rate for converting one
+// currency to another at the close of the day.
+type dailyRates map[int64]decimal.Decimal
+
+// loadDailyRates loads the closing rate of each day between two times for
+// converting amounts in one currency to another.
+func loadDailyRates(
+	conn *database.Conn,
+	from *model.Currency,
+	to *model.Currency,
+	start time.Time,
+	end time.Time,
+) (dailyRates, error) {
+	var priceList []model.Price
+
+	if _, err := query.LoadConvertedClosingPrices(
+		conn,
+		&priceList,
+		from.Ticker,
+		to.Ticker,
+		start.Truncate(24*time.Hour),
+		end,
+		24*time.Hour,
+	); err != nil {
+		return nil, err
+	}
+
+	rates := make(dailyRates, len(priceList))
+
+	for _, price := range priceList {
+		rates[price.Time.UnixNano()] = price.Value
+	}
+
+	return rates, nil
+}
+
+// at returns the closing rate on the day of a time.
+func (rates dailyRates) at(t time.Time) (decimal.Decimal, bool) {
+	rate, ok := rates[t.Truncate(24*time.Hour).UnixNano()]
+
+	return rate, ok
+}
+
+// CombinedPortfolio is one portfolio in a combined view, with its totals
+// converted to the currency of the view.
+type CombinedPortfolio struct {
+	PortfolioSummary
+	Cash      decimal.Decimal
+	Purchased decimal.Decimal
+	Value     decimal.Decimal
+	// ShareOfTotal is the share of the combined value in the portfolio.
+	ShareOfTotal decimal.Decimal
+}
+
+// CombinedSummary is every portfolio of a user valued in one currency.
+//
+// Amounts in other currencies are converted at the latest exchange rates,
+// including the amounts that were paid for assets.
+type CombinedSummary struct {
+	Currency      model.Currency
+	PortfolioList []CombinedPortfolio
+	// AssetList is the holdings of each asset across every portfolio.
+	AssetList          []TrackedAsset
+	TotalCash          decimal.Decimal
+	TotalPurchased     decimal.Decimal
+	TotalValue         decimal.Decimal
+	TotalProfit        decimal.Decimal
+	AveragePerformance decimal.Decimal
+}
+
+// LoadCombinedSummary loads every portfolio of a user, and adds them up in
+// the currency of the summary.
+func LoadCombinedSummary(conn *database.Conn, user *model.User, summary *CombinedSummary) error {
+	var portfolioList []model.Portfolio
+
+	if err := loadPortfolioList(conn, user, &portfolioList); err != nil {
+		return err
+	}
+
+	summary.PortfolioList = make([]CombinedPortfolio, len(portfolioList))
+	summary.AssetList = nil
+	summary.TotalCash = decimal.Zero
+	summary.TotalPurchased = decimal.Zero
+	summary.TotalValue = decimal.Zero
+	assetIndex := map[string]int{}
+
+	for i, portfolio := range portfolioList {
+		combined := &summary.PortfolioList[i]
+
+		if err := LoadPortfolioSummary(conn, user, portfolio.ID, &combined.PortfolioSummary); err != nil {
+			return err
+		}
+
+		rate, err := loadExchangeRate(conn, &portfolio.Currency, &summary.Currency)
+
+		if err != nil {
+			return err
+		}
+
+		combined.Cash = combined.Portfolio.Cash.Mul(rate)
+		combined.Purchased = combined.TotalPurchased.Mul(rate)
+		combined.Value = combined.TotalValue.Mul(rate)
+
+		summary.TotalCash = summary.TotalCash.Add(combined.Cash)
+		summary.TotalPurchased = summary.TotalPurchased.Add(combined.Purchased)
+		summary.TotalValue = summary.TotalValue.Add(combined.Value)
+
+		for _, asset := range combined.AssetList {
+			index, ok := assetIndex[asset.Currency.Ticker]
+
+			if !ok {
+				index = len(summary.AssetList)
+				assetIndex[asset.Currency.Ticker] = index
+				summary.AssetList = append(summary.AssetList, TrackedAsset{
+					Asset: model.Asset{
+						Currency:  asset.Currency,
+						Purchased: decimal.Zero,
+						Amount:    decimal.Zero,
+					},
+				})
+			}
+
+			total := &summary.AssetList[index]
+			total.Amount = total.Amount.Add(asset.Amount)
+			total.Purchased = total.Purchased.Add(asset.Purchased.Mul(rate))
+		}
+	}
+
+	if err := loadAssetPrices(conn, &summary.Currency, summary.AssetList); err != nil {
+		return err
+	}
+
+	sort.Sort(byValueOrder(summary.AssetList))
+
+	for i := range summary.PortfolioList {
+		combined := &summary.PortfolioList[i]
+
+		if summary.TotalValue.IsZero() {
+			combined.ShareOfTotal = decimal.Zero
+		} else {
+			combined.ShareOfTotal = combined.Value.Div(summary.TotalValue).Mul(Hundred)
+		}
+	}
+
+	summary.TotalProfit = summary.TotalValue.Sub(summary.TotalPurchased)
+
+	if summary.TotalPurchased.IsZero() {
+		summary.AveragePerformance = decimal.Zero
+	} else {
+		summary.AveragePerformance = summary.TotalValue.Div(summary.TotalPurchased).Sub(One).Mul(Hundred)
+	}
+
+	return nil
+}
+
+type CombinedPageData struct {
+	PortfolioPageData
+	CombinedSummary
+	ToCurrencyList []model.Currency
+}
+
+// HandleCombinedPortfolio shows every portfolio of a user added up in one
+// currency, which defaults to the currency of the selected portfolio.
+func HandleCombinedPortfolio(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := CombinedPageData{ToCurrencyList: query.GetToCurrencyList()}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		http.Redirect(writer, request, "/login", http.StatusFound)
+
+		return
+	}
+
+	if err := loadPortfolio(conn, &data.User, selectedPortfolioID(request), &data.Portfolio); err != nil {
+		if err == database.ErrNoRows {
+			http.Redirect(writer, request, "/portfolio", http.StatusFound)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return
+	}
+
+	data.Currency = data.Portfolio.Currency
+
+	if ticker := request.URL.Query().Get("currency"); ticker != "" {
+		found := false
+
+		for _, currency := range data.ToCurrencyList {
+			if currency.Ticker == ticker {
+				data.Currency = currency
+				found = true
+			}
+		}
+
+		if !found {
+			util.RespondValidationError(writer, "Invalid currency")
+
+			return
+		}
+	}
+
+	if err := LoadCombinedSummary(conn, &data.User, &data.CombinedSummary); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	template.Render(template.CombinedPortfolio, writer, data)
+}
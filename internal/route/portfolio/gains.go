@@ -0,0 +1,239 @@
+package portfolio
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/dense-analysis/pricewarp/internal/template"
+	"github.com/shopspring/decimal"
+)
+
+// AssetGains is the gains on one asset in a portfolio.
+//
+// Realized gains are from sales in the range of a report. Unrealized gains
+// are on the amount still held, at the latest prices.
+type AssetGains struct {
+	TrackedAsset
+	Proceeds     decimal.Decimal
+	RealizedCost decimal.Decimal
+	Realized     decimal.Decimal
+	Unrealized   decimal.Decimal
+	// DisposalList lists the sales in the range of the report.
+	DisposalList []ledger.Disposal
+}
+
+// GainsReport is the realized and unrealized gains of a portfolio.
+//
+// A zero Start includes every sale up to End.
+type GainsReport struct {
+	Start           time.Time
+	End             time.Time
+	AssetList       []AssetGains
+	TotalProceeds   decimal.Decimal
+	TotalRealized   decimal.Decimal
+	TotalUnrealized decimal.Decimal
+	TotalGain       decimal.Decimal
+}
+
+func scanCurrency(row database.Row, currency *model.Currency) error {
+	return row.Scan(&currency.Ticker, &currency.Name)
+}
+
+// loadLedgerCurrencyList loads every currency a portfolio has transactions
+// for, or holds from before transactions were recorded.
+func loadLedgerCurrencyList(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	currencyList *[]model.Currency,
+) error {
+	return model.LoadList(
+		conn,
+		currencyList,
+		10,
+		scanCurrency,
+		`
+		select currency_ticker, any(currency_name)
+		from (
+			select currency_ticker, currency_name
+			from (`+transactionQuery+`)
+			where is_deleted = 0 and portfolio_id = ?
+			union all
+			select currency_ticker, currency_name
+			from (
+				select *
+				from crypto_asset
+				where user_id = ? and portfolio_id = ?
+				order by updated_at desc
+				limit 1 by currency_ticker
+			)
+			where amount > 0
+		)
+		group by currency_ticker
+		order by currency_ticker
+		`,
+		user.ID,
+		portfolio.ID,
+		user.ID,
+		portfolio.ID,
+	)
+}
+
+// LoadGainsReport works out the gains of a user's portfolio, with realized
+// gains from sales between two times.
+func LoadGainsReport(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	report *GainsReport,
+) error {
+	var currencyList []model.Currency
+
+	if err := loadLedgerCurrencyList(conn, user, portfolio, &currencyList); err != nil {
+		return err
+	}
+
+	report.AssetList = make([]AssetGains, len(currencyList))
+	assetList := make([]TrackedAsset, len(currencyList))
+
+	for i := range currencyList {
+		var transactionList []model.Transaction
+
+		gains := &report.AssetList[i]
+		gains.Currency = currencyList[i]
+
+		if err := loadLedger(conn, user, portfolio, &gains.Currency, &transactionList); err != nil {
+			return err
+		}
+
+		holding, err := ledger.Replay(transactionList, portfolio.CostBasisMethod)
+
+		if err != nil {
+			return err
+		}
+
+		gains.Amount = holding.Amount
+		gains.Purchased = holding.Cost
+		gains.Proceeds = decimal.Zero
+		gains.RealizedCost = decimal.Zero
+
+		for _, disposal := range holding.DisposalList {
+			if disposal.Time.Before(report.Start) || !disposal.Time.Before(report.End) {
+				continue
+			}
+
+			gains.DisposalList = append(gains.DisposalList, disposal)
+			gains.Proceeds = gains.Proceeds.Add(disposal.Proceeds)
+			gains.RealizedCost = gains.RealizedCost.Add(disposal.Cost)
+		}
+
+		gains.Realized = gains.Proceeds.Sub(gains.RealizedCost)
+		assetList[i] = gains.TrackedAsset
+	}
+
+	if err := loadAssetPrices(conn, &portfolio.Currency, assetList); err != nil {
+		return err
+	}
+
+	report.TotalProceeds = decimal.Zero
+	report.TotalRealized = decimal.Zero
+	report.TotalUnrealized = decimal.Zero
+
+	for i := range report.AssetList {
+		gains := &report.AssetList[i]
+		gains.TrackedAsset = assetList[i]
+		gains.Unrealized = gains.Value.Sub(gains.Purchased)
+
+		report.TotalProceeds = report.TotalProceeds.Add(gains.Proceeds)
+		report.TotalRealized = report.TotalRealized.Add(gains.Realized)
+		report.TotalUnrealized = report.TotalUnrealized.Add(gains.Unrealized)
+	}
+
+	report.TotalGain = report.TotalRealized.Add(report.TotalUnrealized)
+
+	return nil
+}
+
+// parseReportRange reads the dates for a report, with the end date included.
+//
+// Without a start date the report covers every sale up to the end date, and
+// without an end date the report runs until now.
+func parseReportRange(values url.Values) (time.Time, time.Time, error) {
+	var start time.Time
+	var err error
+	end := time.Now().UTC()
+
+	if value := values.Get("start"); value != "" {
+		if start, err = time.Parse(time.DateOnly, value); err != nil {
+			return time.Time{}, time.Time{}, util.ValidationError("Invalid start date")
+		}
+	}
+
+	if value := values.Get("end"); value != "" {
+		if end, err = time.Parse(time.DateOnly, value); err != nil {
+			return time.Time{}, time.Time{}, util.ValidationError("Invalid end date")
+		}
+
+		end = end.AddDate(0, 0, 1)
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, util.ValidationError("The start date must be before the end date")
+	}
+
+	return start, end, nil
+}
+
+type GainsPageData struct {
+	PortfolioPageData
+	GainsReport
+	// StartDate and EndDate are the dates entered for the range, if any.
+	StartDate string
+	EndDate   string
+}
+
+// HandleGains shows the realized and unrealized gains of a portfolio.
+func HandleGains(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := GainsPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		http.Redirect(writer, request, "/login", http.StatusFound)
+
+		return
+	}
+
+	values := request.URL.Query()
+	data.StartDate = values.Get("start")
+	data.EndDate = values.Get("end")
+
+	var err error
+
+	if data.Start, data.End, err = parseReportRange(values); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	if err := loadPortfolio(conn, &data.User, selectedPortfolioID(request), &data.Portfolio); err != nil {
+		if err == database.ErrNoRows {
+			http.Redirect(writer, request, "/portfolio", http.StatusFound)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return
+	}
+
+	if err := LoadGainsReport(conn, &data.User, &data.Portfolio, &data.GainsReport); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	template.Render(template.Gains, writer, data)
+}
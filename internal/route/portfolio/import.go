@@ -0,0 +1,445 @@
+package portfolio
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/dense-analysis/pricewarp/internal/template"
+	"github.com/dense-analysis/pricewarp/internal/tradeimport"
+	"github.com/shopspring/decimal"
+)
+
+// maxImportSize is the largest trade history that can be uploaded.
+const maxImportSize = 1 << 20
+
+// Statuses of rows in a trade import.
+const (
+	importNew       = "new"
+	importImported  = "imported"
+	importDuplicate = "duplicate"
+	importSkipped   = "skipped"
+	importError     = "error"
+)
+
+// TradeImportRow is what happens to one row of a trade history.
+type TradeImportRow struct {
+	Number      int
+	Status      string
+	Message     string
+	Transaction model.Transaction
+}
+
+type TradeImportPageData struct {
+	PortfolioPageData
+	FormatList  []string
+	FormatNames map[string]string
+	Format      string
+	Mapping     tradeimport.Mapping
+	// Content is the uploaded file, encoded so it can be sent again to
+	// confirm the import.
+	Content  string
+	RowList  []TradeImportRow
+	Imported bool
+	// Counts of rows by their status.
+	New       int
+	Duplicate int
+	Skipped   int
+	Failed    int
+}
+
+func newTradeImportPageData() TradeImportPageData {
+	return TradeImportPageData{
+		FormatList:  tradeimport.FormatList,
+		FormatNames: tradeimport.FormatNames,
+		Format:      tradeimport.Binance,
+		Mapping:     tradeimport.DefaultMapping,
+	}
+}
+
+func scanExternalID(row database.Row, externalID *string) error {
+	return row.Scan(externalID)
+}
+
+// loadExternalIDSet loads the IDs of every transaction imported into a portfolio.
+func loadExternalIDSet(conn *database.Conn, user *model.User, portfolio *model.Portfolio) (map[string]bool, error) {
+	var externalIDList []string
+
+	if err := model.LoadList(
+		conn,
+		&externalIDList,
+		100,
+		scanExternalID,
+		`select external_id from (`+transactionQuery+`)
+		where is_deleted = 0 and portfolio_id = ? and external_id != ''`,
+		user.ID,
+		portfolio.ID,
+	); err != nil {
+		return nil, err
+	}
+
+	externalIDSet := make(map[string]bool, len(externalIDList))
+
+	for _, externalID := range externalIDList {
+		externalIDSet[externalID] = true
+	}
+
+	return externalIDSet, nil
+}
+
+// parseTradeImportForm reads the format and column mapping for an import.
+func parseTradeImportForm(values url.Values, data *TradeImportPageData) error {
+	data.Format = values.Get("format")
+
+	if !tradeimport.IsValidFormat(data.Format) {
+		return util.ValidationError("Invalid format")
+	}
+
+	data.Mapping = tradeimport.Mapping{
+		ID:       values.Get("map_id"),
+		Time:     values.Get("map_time"),
+		Side:     values.Get("map_side"),
+		Asset:    values.Get("map_asset"),
+		Quote:    values.Get("map_quote"),
+		Quantity: values.Get("map_quantity"),
+		Price:    values.Get("map_price"),
+		Fee:      values.Get("map_fee"),
+	}
+
+	return nil
+}
+
+// newImportedTransaction converts a trade to a transaction in the portfolio
+// currency.
+func newImportedTransaction(
+	trade *tradeimport.Trade,
+	format string,
+	portfolio *model.Portfolio,
+	currency *model.Currency,
+	transaction *model.Transaction,
+) error {
+	quote := trade.Quote
+
+	if quote == "" {
+		quote = portfolio.Currency.Ticker
+	}
+
+	if quote != portfolio.Currency.Ticker {
+		return util.ValidationError("Priced in " + quote + ", but the portfolio is in " + portfolio.Currency.Ticker)
+	}
+
+	*transaction = model.Transaction{
+		Currency:   *currency,
+		Kind:       ledger.Buy,
+		Time:       trade.Time,
+		Quantity:   trade.Quantity,
+		Price:      trade.Price,
+		Fee:        decimal.Zero,
+		Note:       "Imported from " + tradeimport.FormatNames[format],
+		ExternalID: trade.ExternalID,
+	}
+
+	if trade.Side == tradeimport.Sell {
+		transaction.Kind = ledger.Sell
+	}
+
+	if !transaction.Quantity.IsPositive() {
+		return util.ValidationError("Quantity must be positive")
+	}
+
+	switch trade.FeeCurrency {
+	case "", quote:
+		return setFee(transaction, trade.Fee, false)
+	case trade.Base:
+		return setFee(transaction, trade.Fee, true)
+	}
+
+	if trade.Fee.IsPositive() {
+		transaction.Note += ", fee of " + trade.Fee.String() + " " + trade.FeeCurrency + " not included"
+	}
+
+	return nil
+}
+
+// prepareTradeImport reads an uploaded trade history and works out what would
+// happen to each row.
+func prepareTradeImport(conn *database.Conn, content []byte, data *TradeImportPageData) error {
+	rowList, err := tradeimport.Read(data.Format, content, data.Mapping)
+
+	if err != nil {
+		return util.ValidationError("Couldn't read the file: " + err.Error())
+	}
+
+	externalIDSet, err := loadExternalIDSet(conn, &data.User, &data.Portfolio)
+
+	if err != nil {
+		return err
+	}
+
+	currencyMap := map[string]*model.Currency{}
+	data.RowList = make([]TradeImportRow, len(rowList))
+
+	for i := range rowList {
+		row := &rowList[i]
+		result := &data.RowList[i]
+		result.Number = row.Number
+
+		if row.Err != nil {
+			if errors.Is(row.Err, tradeimport.ErrSkipped) {
+				result.Status = importSkipped
+				result.Message = "Not a buy or sell"
+				data.Skipped++
+			} else {
+				result.Status = importError
+				result.Message = row.Err.Error()
+				data.Failed++
+			}
+
+			continue
+		}
+
+		if externalIDSet[row.Trade.ExternalID] {
+			result.Status = importDuplicate
+			result.Message = "Already imported"
+			data.Duplicate++
+
+			continue
+		}
+
+		currency, ok := currencyMap[row.Trade.Base]
+
+		if !ok {
+			currency = &model.Currency{}
+
+			if err := loadCurrencyByTicker(conn, currency, row.Trade.Base); err != nil {
+				if err != database.ErrNoRows {
+					return err
+				}
+
+				currency = nil
+			}
+
+			currencyMap[row.Trade.Base] = currency
+		}
+
+		if currency == nil {
+			result.Status = importError
+			result.Message = "Unknown currency: " + row.Trade.Base
+			data.Failed++
+
+			continue
+		}
+
+		if err := newImportedTransaction(
+			&row.Trade,
+			data.Format,
+			&data.Portfolio,
+			currency,
+			&result.Transaction,
+		); err != nil {
+			result.Status = importError
+			result.Message = err.Error()
+			data.Failed++
+
+			continue
+		}
+
+		// Rows repeated in the same file are duplicates too.
+		externalIDSet[row.Trade.ExternalID] = true
+		result.Status = importNew
+		data.New++
+	}
+
+	return nil
+}
+
+// saveTradeImport adds the new transactions in an import to the ledgers of
+// their assets.
+//
+// Each asset is saved on its own, so an asset that can't be imported, such as
+// one sold before it was bought, doesn't stop the others from being imported.
+func saveTradeImport(conn *database.Conn, data *TradeImportPageData) error {
+	tickerRowMap := map[string][]*TradeImportRow{}
+	var tickerList []string
+
+	for i := range data.RowList {
+		result := &data.RowList[i]
+
+		if result.Status == importNew {
+			ticker := result.Transaction.Currency.Ticker
+
+			if _, ok := tickerRowMap[ticker]; !ok {
+				tickerList = append(tickerList, ticker)
+			}
+
+			tickerRowMap[ticker] = append(tickerRowMap[ticker], result)
+		}
+	}
+
+	for _, ticker := range tickerList {
+		resultList := tickerRowMap[ticker]
+		transactionList := make([]model.Transaction, len(resultList))
+
+		for i, result := range resultList {
+			var err error
+
+			if result.Transaction.ID, err = database.RandomID(); err != nil {
+				return err
+			}
+
+			transactionList[i] = result.Transaction
+		}
+
+		adjustData := AssetAdjustData{}
+		adjustData.User = data.User
+		err := loadAssetAdjustData(conn, data.Portfolio.ID, ticker, &adjustData)
+
+		if err == nil {
+			err = saveLedgerChanges(conn, &adjustData, nil, transactionList)
+		}
+
+		for _, result := range resultList {
+			if err == nil {
+				result.Status = importImported
+			} else {
+				result.Status = importError
+				result.Message = err.Error()
+			}
+		}
+
+		if err != nil {
+			if _, ok := err.(util.ValidationError); !ok {
+				return err
+			}
+
+			data.New -= len(resultList)
+			data.Failed += len(resultList)
+		}
+	}
+
+	data.Imported = true
+
+	return nil
+}
+
+// loadTradeImportUser loads the user and portfolio for an import.
+func loadTradeImportUser(conn *database.Conn, writer http.ResponseWriter, request *http.Request, data *TradeImportPageData) bool {
+	if !loadUser(conn, writer, request, &data.User) {
+		http.Redirect(writer, request, "/login", http.StatusFound)
+
+		return false
+	}
+
+	if err := loadPortfolio(conn, &data.User, selectedPortfolioID(request), &data.Portfolio); err != nil {
+		if err == database.ErrNoRows {
+			http.Redirect(writer, request, "/portfolio", http.StatusFound)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// HandleTradeImportForm shows the form for uploading a trade history.
+func HandleTradeImportForm(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := newTradeImportPageData()
+
+	if !loadTradeImportUser(conn, writer, request, &data) {
+		return
+	}
+
+	template.Render(template.TradeImport, writer, data)
+}
+
+// HandleTradeImportPreview shows what importing an uploaded trade history
+// would do, without saving anything.
+func HandleTradeImportPreview(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := newTradeImportPageData()
+
+	if !loadTradeImportUser(conn, writer, request, &data) {
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, maxImportSize)
+	file, _, err := request.FormFile("file")
+
+	if err != nil {
+		util.RespondValidationError(writer, "Missing or oversized import file")
+
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+
+	if err != nil {
+		util.RespondValidationError(writer, "Missing or oversized import file")
+
+		return
+	}
+
+	if err := parseTradeImportForm(request.Form, &data); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	if err := prepareTradeImport(conn, content, &data); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	data.Content = base64.StdEncoding.EncodeToString(content)
+
+	template.Render(template.TradeImport, writer, data)
+}
+
+// HandleTradeImport imports the new trades from a previewed trade history.
+func HandleTradeImport(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := newTradeImportPageData()
+
+	if !loadTradeImportUser(conn, writer, request, &data) {
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, maxImportSize*2)
+	request.ParseForm()
+
+	content, err := base64.StdEncoding.DecodeString(request.Form.Get("content"))
+
+	if err != nil {
+		util.RespondValidationError(writer, "Invalid import file")
+
+		return
+	}
+
+	if err := parseTradeImportForm(request.Form, &data); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	if err := prepareTradeImport(conn, content, &data); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	if err := saveTradeImport(conn, &data); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	template.Render(template.TradeImport, writer, data)
+}
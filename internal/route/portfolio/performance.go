@@ -0,0 +1,381 @@
+package portfolio
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/finance"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/query"
+	"github.com/shopspring/decimal"
+)
+
+// PerformancePeriod is a period of time returns can be worked out for.
+type PerformancePeriod struct {
+	Name string
+	// Duration is how far back the period goes, or 0 for all time.
+	Duration time.Duration
+}
+
+var performancePeriodList = []PerformancePeriod{
+	{Name: "30d", Duration: 30 * 24 * time.Hour},
+	{Name: "90d", Duration: 90 * 24 * time.Hour},
+	{Name: "1y", Duration: 365 * 24 * time.Hour},
+	{Name: "all"},
+}
+
+// defaultPerformancePeriod is the period shown when none is selected.
+const defaultPerformancePeriod = 2
+
+// parsePerformancePeriod returns the period selected with `?period=`.
+func parsePerformancePeriod(values url.Values) PerformancePeriod {
+	name := values.Get("period")
+
+	for _, period := range performancePeriodList {
+		if period.Name == name {
+			return period
+		}
+	}
+
+	return performancePeriodList[defaultPerformancePeriod]
+}
+
+// periodStart returns the start of a period ending now.
+func periodStart(period *PerformancePeriod, now time.Time) time.Time {
+	if period.Duration == 0 {
+		return time.Unix(0, 0).UTC()
+	}
+
+	return now.Add(-period.Duration)
+}
+
+// Performance is the return of an investment over a period, leaving out the
+// effect of money moved in and out of it.
+type Performance struct {
+	Period     PerformancePeriod
+	PeriodList []PerformancePeriod
+	// Path is the page showing the returns, for links to other periods.
+	Path string
+	// Start is when the returns are worked out from, which is later than the
+	// start of the period if there's no record of the value before then.
+	Start time.Time
+	// TimeWeighted is the time-weighted return over the period, as a percentage.
+	TimeWeighted    decimal.Decimal
+	HasTimeWeighted bool
+	// MoneyWeighted is the annual internal rate of return, as a percentage.
+	MoneyWeighted    decimal.Decimal
+	HasMoneyWeighted bool
+	EmptyText        string
+}
+
+func newPerformance(values url.Values, path string, emptyText string) Performance {
+	return Performance{
+		Period:     parsePerformancePeriod(values),
+		PeriodList: performancePeriodList,
+		Path:       path,
+		EmptyText:  emptyText,
+	}
+}
+
+// calculatePerformance works out returns from valuations, oldest first, and
+// the flows between them.
+//
+// Returns that can't be worked out, such as when there's only one valuation,
+// are left out.
+func calculatePerformance(valuationList []finance.Valuation, flowList []finance.Flow, performance *Performance) {
+	if len(valuationList) < 2 {
+		return
+	}
+
+	performance.Start = valuationList[0].Time
+
+	if timeWeighted, err := finance.TimeWeightedReturn(valuationList, flowList); err == nil {
+		performance.TimeWeighted = timeWeighted.Mul(Hundred)
+		performance.HasTimeWeighted = true
+	}
+
+	if moneyWeighted, err := finance.XIRR(valuationList[0], flowList, valuationList[len(valuationList)-1]); err == nil {
+		performance.MoneyWeighted = moneyWeighted.Mul(Hundred)
+		performance.HasMoneyWeighted = true
+	}
+}
+
+// loadPortfolioFlowList loads the money moved in and out of a portfolio since
+// a time: deposits and withdrawals of cash, and of assets at their value then.
+//
+// Cash in other currencies and assets are valued at the closing rate of the
+// day they moved. Cash without a rate that day is converted at the latest
+// rate, and assets without a price that day are valued at their own price.
+// Trades only move value between cash and assets, so they aren't flows.
+func loadPortfolioFlowList(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	start time.Time,
+	flowList *[]finance.Flow,
+) error {
+	now := time.Now().UTC()
+	rateMap := make(map[string]dailyRates)
+
+	// ratesFor loads the daily rates of a currency in the portfolio currency
+	// once for the whole period.
+	ratesFor := func(currency *model.Currency) (dailyRates, error) {
+		if rates, ok := rateMap[currency.Ticker]; ok {
+			return rates, nil
+		}
+
+		rates, err := loadDailyRates(conn, currency, &portfolio.Currency, start, now)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rateMap[currency.Ticker] = rates
+
+		return rates, nil
+	}
+
+	var cashFlowList []model.CashFlow
+
+	if err := loadCashFlowList(conn, user, portfolio, &cashFlowList); err != nil {
+		return err
+	}
+
+	for _, cashFlow := range cashFlowList {
+		if cashFlow.Time.Before(start) {
+			continue
+		}
+
+		amount := cashFlowChange(&cashFlow)
+
+		// Cash moved before the portfolio currency changed is converted.
+		if cashFlow.Currency.Ticker != portfolio.Currency.Ticker {
+			rates, err := ratesFor(&cashFlow.Currency)
+
+			if err != nil {
+				return err
+			}
+
+			rate, ok := rates.at(cashFlow.Time)
+
+			if !ok {
+				if rate, err = loadExchangeRate(conn, &cashFlow.Currency, &portfolio.Currency); err != nil {
+					return err
+				}
+			}
+
+			amount = amount.Mul(rate)
+		}
+
+		*flowList = append(*flowList, finance.Flow{Time: cashFlow.Time, Amount: amount})
+	}
+
+	var transactionList []model.Transaction
+
+	if err := model.LoadList(
+		conn,
+		&transactionList,
+		10,
+		scanTransaction,
+		transactionQuery+`where is_deleted = 0 and portfolio_id = ? and kind in (?, ?) and transaction_time >= ?`,
+		user.ID,
+		portfolio.ID,
+		ledger.Deposit,
+		ledger.Withdrawal,
+		start,
+	); err != nil {
+		return err
+	}
+
+	for i := range transactionList {
+		transaction := &transactionList[i]
+		rates, err := ratesFor(&transaction.Currency)
+
+		if err != nil {
+			return err
+		}
+
+		price, ok := rates.at(transaction.Time)
+
+		if !ok {
+			price = transaction.Price
+		}
+
+		value := ledger.Amount(transaction).Mul(price)
+
+		if transaction.Kind == ledger.Withdrawal {
+			value = value.Neg()
+		}
+
+		*flowList = append(*flowList, finance.Flow{Time: transaction.Time, Amount: value})
+	}
+
+	return nil
+}
+
+// loadPortfolioPerformance works out the returns of a portfolio from its
+// daily snapshots and its value now.
+//
+// Snapshots are saved during their day, so each one counts as the value at
+// the end of the day. Today's snapshot is replaced by the value now.
+func loadPortfolioPerformance(
+	conn *database.Conn,
+	user *model.User,
+	summary *PortfolioSummary,
+	performance *Performance,
+) error {
+	var snapshotList []model.PortfolioSnapshot
+
+	now := time.Now().UTC()
+	start := periodStart(&performance.Period, now)
+
+	if err := loadSnapshotList(conn, user.ID, &summary.Portfolio, start, &snapshotList); err != nil {
+		return err
+	}
+
+	valuationList := make([]finance.Valuation, 0, len(snapshotList)+1)
+
+	for _, snapshot := range snapshotList {
+		if endOfDay := snapshot.Date.Add(24 * time.Hour); endOfDay.Before(now) {
+			valuationList = append(valuationList, finance.Valuation{Time: endOfDay, Value: snapshot.Value})
+		}
+	}
+
+	if len(valuationList) == 0 {
+		return nil
+	}
+
+	valuationList = append(valuationList, finance.Valuation{Time: now, Value: summary.TotalValue})
+
+	var flowList []finance.Flow
+
+	if err := loadPortfolioFlowList(conn, user, &summary.Portfolio, valuationList[0].Time, &flowList); err != nil {
+		return err
+	}
+
+	calculatePerformance(valuationList, flowList, performance)
+
+	return nil
+}
+
+// loadAssetPerformance works out the returns of an asset in a portfolio from
+// its ledger and the daily closing prices of the asset.
+//
+// Buying or depositing the asset puts money into it, and selling or
+// withdrawing it takes money out. Deposits and withdrawals are valued at the
+// closing price of their day, or their own price if there's no price then.
+func loadAssetPerformance(
+	conn *database.Conn,
+	asset *TrackedAsset,
+	currency *model.Currency,
+	transactionList []model.Transaction,
+	performance *Performance,
+) error {
+	if len(transactionList) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	start := periodStart(&performance.Period, now)
+
+	// Nothing was held before the first transaction.
+	if first := transactionList[0].Time; start.Before(first) {
+		start = first
+	}
+
+	var priceList []model.Price
+
+	if _, err := query.LoadConvertedClosingPrices(
+		conn,
+		&priceList,
+		asset.Currency.Ticker,
+		currency.Ticker,
+		start,
+		now,
+		24*time.Hour,
+	); err != nil {
+		return err
+	}
+
+	if len(priceList) == 0 {
+		return nil
+	}
+
+	dayPriceMap := make(map[int64]decimal.Decimal, len(priceList))
+
+	for _, price := range priceList {
+		dayPriceMap[price.Time.UnixNano()] = price.Value
+	}
+
+	amount := decimal.Zero
+	transactionIndex := 0
+
+	// amountAt returns the amount held just before a time, for times in order.
+	amountAt := func(at time.Time) decimal.Decimal {
+		for ; transactionIndex < len(transactionList) && transactionList[transactionIndex].Time.Before(at); transactionIndex++ {
+			transaction := &transactionList[transactionIndex]
+
+			switch transaction.Kind {
+			case ledger.Buy, ledger.Deposit:
+				amount = amount.Add(ledger.Amount(transaction))
+			default:
+				amount = amount.Sub(ledger.Amount(transaction))
+			}
+		}
+
+		return amount
+	}
+
+	valuationList := make([]finance.Valuation, 0, len(priceList)+2)
+	valuationList = append(valuationList, finance.Valuation{
+		Time:  start,
+		Value: amountAt(start).Mul(priceList[0].Value),
+	})
+
+	for _, price := range priceList {
+		if endOfDay := price.Time.Add(24 * time.Hour); endOfDay.After(start) && endOfDay.Before(now) {
+			valuationList = append(valuationList, finance.Valuation{
+				Time:  endOfDay,
+				Value: amountAt(endOfDay).Mul(price.Value),
+			})
+		}
+	}
+
+	valuationList = append(valuationList, finance.Valuation{Time: now, Value: asset.Value})
+	flowList := make([]finance.Flow, 0, len(transactionList))
+
+	for i := range transactionList {
+		transaction := &transactionList[i]
+
+		if transaction.Time.Before(start) {
+			continue
+		}
+
+		var value decimal.Decimal
+
+		switch transaction.Kind {
+		case ledger.Buy, ledger.Sell:
+			value = ledger.CashChange(transaction).Neg()
+		default:
+			price, ok := dayPriceMap[transaction.Time.Truncate(24*time.Hour).UnixNano()]
+
+			if !ok {
+				price = transaction.Price
+			}
+
+			value = ledger.Amount(transaction).Mul(price)
+
+			if transaction.Kind == ledger.Withdrawal {
+				value = value.Neg()
+			}
+		}
+
+		flowList = append(flowList, finance.Flow{Time: transaction.Time, Amount: value})
+	}
+
+	calculatePerformance(valuationList, flowList, performance)
+
+	return nil
+}
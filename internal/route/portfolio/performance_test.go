@@ -0,0 +1,100 @@
+package portfolio
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/finance"
+	"github.com/shopspring/decimal"
+)
+
+func TestParsePerformancePeriod(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+	}{
+		{"30d", "30d"},
+		{"90d", "90d"},
+		{"all", "all"},
+		{"", "1y"},
+		{"5y", "1y"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.value, func(t *testing.T) {
+			period := parsePerformancePeriod(url.Values{"period": {testCase.value}})
+
+			if period.Name != testCase.expected {
+				t.Errorf("got %q, expected %q", period.Name, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestCalculatePerformance(t *testing.T) {
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	middle := start.AddDate(0, 6, 0)
+	end := start.AddDate(1, 0, 0)
+
+	testCases := []struct {
+		name          string
+		valuationList []finance.Valuation
+		flowList      []finance.Flow
+		timeWeighted  string
+		moneyWeighted string
+	}{
+		{
+			name:          "One valuation",
+			valuationList: []finance.Valuation{{Time: start, Value: decimal.NewFromInt(100)}},
+		},
+		{
+			name: "Growth without flows",
+			valuationList: []finance.Valuation{
+				{Time: start, Value: decimal.NewFromInt(100)},
+				{Time: end, Value: decimal.NewFromInt(110)},
+			},
+			timeWeighted:  "10.0",
+			moneyWeighted: "10.0",
+		},
+		{
+			// Doubling the money put in doesn't count as a return.
+			name: "Deposit without growth",
+			valuationList: []finance.Valuation{
+				{Time: start, Value: decimal.NewFromInt(100)},
+				{Time: end, Value: decimal.NewFromInt(200)},
+			},
+			flowList:      []finance.Flow{{Time: middle, Amount: decimal.NewFromInt(100)}},
+			timeWeighted:  "0.0",
+			moneyWeighted: "0.0",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var performance Performance
+
+			calculatePerformance(testCase.valuationList, testCase.flowList, &performance)
+
+			if testCase.timeWeighted == "" {
+				if performance.HasTimeWeighted || performance.HasMoneyWeighted || !performance.Start.IsZero() {
+					t.Errorf("expected no returns, got %+v", performance)
+				}
+
+				return
+			}
+
+			if !performance.Start.Equal(start) {
+				t.Errorf("got start %s, expected %s", performance.Start, start)
+			}
+
+			if !performance.HasTimeWeighted || performance.TimeWeighted.StringFixed(1) != testCase.timeWeighted {
+				t.Errorf("got time-weighted %s, expected %s", performance.TimeWeighted, testCase.timeWeighted)
+			}
+
+			if !performance.HasMoneyWeighted || performance.MoneyWeighted.StringFixed(1) != testCase.moneyWeighted {
+				t.Errorf("got money-weighted %s, expected %s", performance.MoneyWeighted, testCase.moneyWeighted)
+			}
+		})
+	}
+}
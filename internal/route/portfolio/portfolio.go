@@ -1,11 +1,17 @@
 package portfolio
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/dense-analysis/pricewarp/internal/chart"
 	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
 	"github.com/dense-analysis/pricewarp/internal/model"
 	"github.com/dense-analysis/pricewarp/internal/route/query"
 	"github.com/dense-analysis/pricewarp/internal/route/util"
@@ -26,24 +32,39 @@ type TrackedAsset struct {
 	Performance      decimal.Decimal
 }
 
+// defaultPortfolioName is the name of a user's first portfolio.
+const defaultPortfolioName = "Main"
+
+// maxPortfolioNameLength limits the length of portfolio names.
+const maxPortfolioNameLength = 100
+
 var portfolioQuery = `
 select
+	portfolio_id,
+	name,
 	currency_ticker,
 	currency_name,
-	cash
-from crypto_portfolio
-where user_id = ?
-order by updated_at desc
-limit 1
+	cash,
+	cost_basis_method
+from (
+	select *
+	from crypto_portfolio
+	where user_id = ?
+	order by updated_at desc
+	limit 1 by portfolio_id
+)
 `
 
 func scanPortfolio(row database.Row, portfolio *model.Portfolio) error {
 	var cash decimal.Decimal
 
 	if err := row.Scan(
+		&portfolio.ID,
+		&portfolio.Name,
 		&portfolio.Currency.Ticker,
 		&portfolio.Currency.Name,
 		&cash,
+		&portfolio.CostBasisMethod,
 	); err != nil {
 		return err
 	}
@@ -53,12 +74,30 @@ func scanPortfolio(row database.Row, portfolio *model.Portfolio) error {
 	return nil
 }
 
-func loadPortfolio(conn *database.Conn, user *model.User, portfolio *model.Portfolio) error {
-	row := conn.QueryRow(portfolioQuery, user.ID)
+func loadPortfolio(conn *database.Conn, user *model.User, portfolioID int64, portfolio *model.Portfolio) error {
+	row := conn.QueryRow(portfolioQuery+"where portfolio_id = ?", user.ID, portfolioID)
 
 	return scanPortfolio(row, portfolio)
 }
 
+// loadPortfolioList loads every portfolio of a user, with their first
+// portfolio first and the rest by name.
+func loadPortfolioList(conn *database.Conn, user *model.User, portfolioList *[]model.Portfolio) error {
+	return model.LoadList(
+		conn,
+		portfolioList,
+		4,
+		scanPortfolio,
+		portfolioQuery+"order by portfolio_id != 0, name, portfolio_id",
+		user.ID,
+	)
+}
+
+// selectedPortfolioID returns the ID of the portfolio a user is looking at.
+func selectedPortfolioID(request *http.Request) int64 {
+	return session.LoadPortfolioIDFromSession(request)
+}
+
 func scanAsset(row database.Row, asset *model.Asset) error {
 	var purchased decimal.Decimal
 	var amount decimal.Decimal
@@ -82,7 +121,7 @@ func scanTrackedAsset(row database.Row, asset *TrackedAsset) error {
 	return scanAsset(row, &asset.Asset)
 }
 
-func loadAssetList(conn *database.Conn, userID int64, assetList *[]TrackedAsset) error {
+func loadAssetList(conn *database.Conn, userID int64, portfolioID int64, assetList *[]TrackedAsset) error {
 	return model.LoadList(
 		conn,
 		assetList,
@@ -97,13 +136,14 @@ func loadAssetList(conn *database.Conn, userID int64, assetList *[]TrackedAsset)
 		FROM (
 			SELECT *
 			FROM crypto_asset
-			WHERE user_id = ?
+			WHERE user_id = ? AND portfolio_id = ?
 			ORDER BY updated_at DESC
 			LIMIT 1 BY currency_ticker
 		)
 		WHERE amount > 0
 		`,
 		userID,
+		portfolioID,
 	)
 }
 
@@ -236,14 +276,15 @@ func loadAssetPrices(conn *database.Conn, currency *model.Currency, assetList []
 
 var assetUpdateQuery = `
 insert into crypto_asset
-	(user_id, username, currency_ticker, currency_name, purchased, amount, updated_at)
-values (?, ?, ?, ?, ?, ?, now64(9))
+	(user_id, portfolio_id, username, currency_ticker, currency_name, purchased, amount, updated_at)
+values (?, ?, ?, ?, ?, ?, ?, now64(9))
 `
 
-func updateAsset(conn database.Queryable, user *model.User, asset *model.Asset) error {
+func updateAsset(conn database.Queryable, user *model.User, portfolio *model.Portfolio, asset *model.Asset) error {
 	return conn.Exec(
 		assetUpdateQuery,
 		user.ID,
+		portfolio.ID,
 		user.Username,
 		asset.Currency.Ticker,
 		asset.Currency.Name,
@@ -254,18 +295,21 @@ func updateAsset(conn database.Queryable, user *model.User, asset *model.Asset)
 
 var portfolioUpdateQuery = `
 insert into crypto_portfolio
-	(user_id, username, currency_ticker, currency_name, cash, updated_at)
-values (?, ?, ?, ?, ?, now64(9))
+	(user_id, portfolio_id, username, name, currency_ticker, currency_name, cash, cost_basis_method, updated_at)
+values (?, ?, ?, ?, ?, ?, ?, ?, now64(9))
 `
 
 func updatePortfolio(conn database.Queryable, user *model.User, portfolio *model.Portfolio) error {
 	return conn.Exec(
 		portfolioUpdateQuery,
 		user.ID,
+		portfolio.ID,
 		user.Username,
+		portfolio.Name,
 		portfolio.Currency.Ticker,
 		portfolio.Currency.Name,
 		portfolio.Cash,
+		portfolio.CostBasisMethod,
 	)
 }
 
@@ -286,7 +330,56 @@ type PortfolioPageData struct {
 	Portfolio model.Portfolio
 }
 
-// HandlePortfolioUpdate updates the user's currency and balance of that currency.
+// parsePortfolio reads the name, currency and cost basis method of a
+// portfolio from a form.
+//
+// The name of the portfolio is kept if no name is given.
+func parsePortfolio(conn *database.Conn, values url.Values, portfolio *model.Portfolio) error {
+	if name := strings.TrimSpace(values.Get("name")); name != "" {
+		portfolio.Name = name
+	}
+
+	if portfolio.Name == "" {
+		portfolio.Name = defaultPortfolioName
+	}
+
+	if len(portfolio.Name) > maxPortfolioNameLength {
+		return util.ValidationError("Portfolio names must be at most 100 characters")
+	}
+
+	currencyTicker := values.Get("currency")
+
+	if currencyTicker == "" {
+		return util.ValidationError("Invalid currency ticker")
+	}
+
+	if err := query.LoadCurrencyByTicker(conn, &portfolio.Currency, currencyTicker); err != nil {
+		if err == database.ErrNoRows {
+			return util.ValidationError("Unknown currency ticker")
+		}
+
+		return err
+	}
+
+	portfolio.CostBasisMethod = values.Get("cost_basis_method")
+
+	if portfolio.CostBasisMethod == "" {
+		portfolio.CostBasisMethod = ledger.Average
+	}
+
+	if !ledger.IsValidMethod(portfolio.CostBasisMethod) {
+		return util.ValidationError("Invalid cost basis method")
+	}
+
+	return nil
+}
+
+// HandlePortfolioUpdate updates the name, currency and cost basis method for
+// the selected portfolio.
+//
+// Cash can only be set when a user sets up their first portfolio. After that,
+// cash is changed by deposits and withdrawals, so the record of money put in
+// is kept.
 func HandlePortfolioUpdate(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
 	data := PortfolioPageData{}
 
@@ -298,32 +391,140 @@ func HandlePortfolioUpdate(conn *database.Conn, writer http.ResponseWriter, requ
 
 	request.ParseForm()
 
-	currencyTicker := request.Form.Get("currency")
+	var previous model.Portfolio
+	found := true
 
-	if currencyTicker == "" {
-		util.RespondValidationError(writer, "Invalid currency ticker")
+	// Users without a portfolio set up their first portfolio, with ID 0.
+	if err := loadPortfolio(conn, &data.User, selectedPortfolioID(request), &previous); err != nil {
+		if err != database.ErrNoRows {
+			util.RespondInternalServerError(writer, err)
+
+			return
+		}
+
+		found = false
+	}
+
+	data.Portfolio.ID = previous.ID
+	data.Portfolio.Name = previous.Name
+	data.Portfolio.Cash = previous.Cash
+
+	if err := parsePortfolio(conn, request.Form, &data.Portfolio); err != nil {
+		util.RespondError(writer, err)
 
 		return
 	}
 
-	var err error
-	data.Portfolio.Cash, err = decimal.NewFromString(request.Form.Get("cash"))
+	if found {
+		if err := updatePortfolio(conn, &data.User, &data.Portfolio); err != nil {
+			util.RespondInternalServerError(writer, err)
+
+			return
+		}
+	} else {
+		cash, err := parseCash(request.Form)
+
+		if err != nil {
+			util.RespondError(writer, err)
+
+			return
+		}
+
+		if err := openPortfolio(conn, &data.User, &data.Portfolio, cash); err != nil {
+			util.RespondError(writer, err)
+
+			return
+		}
+	}
+
+	// The cost of every asset depends on the cost basis method.
+	if previous.CostBasisMethod != data.Portfolio.CostBasisMethod {
+		if err := recalculateAssets(conn, &data.User, &data.Portfolio); err != nil {
+			util.RespondError(writer, err)
+
+			return
+		}
+	}
+
+	http.Redirect(writer, request, "/portfolio", http.StatusFound)
+}
+
+// HandlePortfolioCreate adds a new portfolio for a user and selects it.
+func HandlePortfolioCreate(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := PortfolioPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	request.ParseForm()
+
+	if strings.TrimSpace(request.Form.Get("name")) == "" {
+		util.RespondValidationError(writer, "Portfolios must have a name")
+
+		return
+	}
+
+	if err := parsePortfolio(conn, request.Form, &data.Portfolio); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	cash, err := parseCash(request.Form)
 
 	if err != nil {
-		util.RespondValidationError(writer, "Invalid cash value")
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	if data.Portfolio.ID, err = database.RandomID(); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	if err := openPortfolio(conn, &data.User, &data.Portfolio, cash); err != nil {
+		util.RespondError(writer, err)
 
 		return
 	}
 
-	if data.Portfolio.Cash.IsNegative() {
-		util.RespondValidationError(writer, "Cash must be non-negative")
+	if err := session.SavePortfolioIDInSession(writer, request, data.Portfolio.ID); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	http.Redirect(writer, request, "/portfolio", http.StatusFound)
+}
+
+// HandlePortfolioSelect switches the portfolio a user is looking at.
+func HandlePortfolioSelect(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := PortfolioPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	request.ParseForm()
+
+	portfolioID, err := strconv.ParseInt(request.Form.Get("portfolio"), 10, 64)
+
+	if err != nil {
+		util.RespondValidationError(writer, "Invalid portfolio")
 
 		return
 	}
 
-	if err := query.LoadCurrencyByTicker(conn, &data.Portfolio.Currency, currencyTicker); err != nil {
+	if err := loadPortfolio(conn, &data.User, portfolioID, &data.Portfolio); err != nil {
 		if err == database.ErrNoRows {
-			util.RespondValidationError(writer, "Unknown currency ticker")
+			util.RespondNotFound(writer)
 		} else {
 			util.RespondInternalServerError(writer, err)
 		}
@@ -331,24 +532,42 @@ func HandlePortfolioUpdate(conn *database.Conn, writer http.ResponseWriter, requ
 		return
 	}
 
-	if err := updatePortfolio(conn, &data.User, &data.Portfolio); err != nil {
+	if err := session.SavePortfolioIDInSession(writer, request, portfolioID); err != nil {
 		util.RespondInternalServerError(writer, err)
-	} else {
-		http.Redirect(writer, request, "/portfolio", http.StatusFound)
+
+		return
 	}
+
+	http.Redirect(writer, request, "/portfolio", http.StatusFound)
 }
 
-type PortfolioListPageData struct {
-	PortfolioPageData
-	AssetList          []TrackedAsset
-	ToCurrencyList     []model.Currency
-	FromCurrencyList   []model.Currency
-	TotalPurchased     decimal.Decimal
-	TotalValue         decimal.Decimal
-	TotalProfit        decimal.Decimal
+// PortfolioSummary is a portfolio with the value of every asset and totals.
+type PortfolioSummary struct {
+	Portfolio      model.Portfolio
+	AssetList      []TrackedAsset
+	TotalPurchased decimal.Decimal
+	TotalValue     decimal.Decimal
+	TotalProfit    decimal.Decimal
+	// TotalFees is every fee paid, with fees paid in crypto valued at the
+	// price of their trade.
+	TotalFees          decimal.Decimal
 	AveragePerformance decimal.Decimal
 }
 
+type PortfolioListPageData struct {
+	PortfolioSummary
+	User model.User
+	// PortfolioList lists every portfolio of the user, for switching between them.
+	PortfolioList    []model.Portfolio
+	CashFlowList     []model.CashFlow
+	ToCurrencyList   []model.Currency
+	FromCurrencyList []model.Currency
+	MethodList       []string
+	MethodNames      map[string]string
+	Chart            chart.Chart
+	Performance      Performance
+}
+
 type byValueOrder []TrackedAsset
 
 func (a byValueOrder) Len() int {
@@ -363,6 +582,48 @@ func (a byValueOrder) Less(i, j int) bool {
 	return a[j].Value.LessThan(a[i].Value)
 }
 
+// LoadPortfolioSummary loads one of a user's portfolios and values its assets.
+//
+// database.ErrNoRows is returned if the user hasn't set a currency yet.
+func LoadPortfolioSummary(conn *database.Conn, user *model.User, portfolioID int64, summary *PortfolioSummary) error {
+	if err := loadPortfolio(conn, user, portfolioID, &summary.Portfolio); err != nil {
+		return err
+	}
+
+	if err := loadAssetList(conn, user.ID, portfolioID, &summary.AssetList); err != nil {
+		return err
+	}
+
+	if err := loadAssetPrices(conn, &summary.Portfolio.Currency, summary.AssetList); err != nil {
+		return err
+	}
+
+	sort.Sort(byValueOrder(summary.AssetList))
+
+	// Add cash in fiat to the total value and amount purchased.
+	summary.TotalValue = summary.Portfolio.Cash
+	summary.TotalPurchased = summary.Portfolio.Cash
+
+	for _, asset := range summary.AssetList {
+		summary.TotalValue = summary.TotalValue.Add(asset.Value)
+		summary.TotalPurchased = summary.TotalPurchased.Add(asset.Purchased)
+	}
+
+	summary.TotalProfit = summary.TotalValue.Sub(summary.TotalPurchased)
+
+	if err := loadTotalFees(conn, user, &summary.Portfolio, &summary.TotalFees); err != nil {
+		return err
+	}
+
+	if summary.TotalPurchased.IsZero() {
+		summary.AveragePerformance = decimal.Zero
+	} else {
+		summary.AveragePerformance = summary.TotalValue.Div(summary.TotalPurchased).Sub(One).Mul(Hundred)
+	}
+
+	return nil
+}
+
 // HandlePortfolio shows the assets and cash a user has.
 func HandlePortfolio(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
 	data := PortfolioListPageData{}
@@ -373,7 +634,8 @@ func HandlePortfolio(conn *database.Conn, writer http.ResponseWriter, request *h
 		return
 	}
 
-	if err := loadPortfolio(conn, &data.User, &data.Portfolio); err != nil {
+	// Assets are only loaded once a currency has been set.
+	if err := LoadPortfolioSummary(conn, &data.User, selectedPortfolioID(request), &data.PortfolioSummary); err != nil {
 		if err != database.ErrNoRows {
 			util.RespondInternalServerError(writer, err)
 
@@ -381,6 +643,12 @@ func HandlePortfolio(conn *database.Conn, writer http.ResponseWriter, request *h
 		}
 	}
 
+	if err := loadPortfolioList(conn, &data.User, &data.PortfolioList); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
 	if err := query.LoadCurrencyList(conn, &data.FromCurrencyList); err != nil {
 		util.RespondInternalServerError(writer, err)
 
@@ -388,38 +656,32 @@ func HandlePortfolio(conn *database.Conn, writer http.ResponseWriter, request *h
 	}
 
 	data.ToCurrencyList = query.GetToCurrencyList()
+	data.MethodList = ledger.MethodList
+	data.MethodNames = ledger.MethodNames
 
 	if data.Portfolio.Currency.Ticker != "" {
-		// Only load assets once a currency has been set.
-		if err := loadAssetList(conn, data.User.ID, &data.AssetList); err != nil {
-			util.RespondInternalServerError(writer, err)
+		if err := loadCashFlowList(conn, &data.User, &data.Portfolio, &data.CashFlowList); err != nil {
+			util.RespondError(writer, err)
 
 			return
 		}
 
-		if err := loadAssetPrices(conn, &data.Portfolio.Currency, data.AssetList); err != nil {
+		if err := loadSnapshotChart(conn, data.User.ID, &data.Portfolio, &data.Chart); err != nil {
 			util.RespondInternalServerError(writer, err)
 
 			return
 		}
 
-		sort.Sort(byValueOrder(data.AssetList))
-
-		// Add cash in fiat to the total value and amount purchased.
-		data.TotalValue = data.Portfolio.Cash
-		data.TotalPurchased = data.Portfolio.Cash
-
-		for _, asset := range data.AssetList {
-			data.TotalValue = data.TotalValue.Add(asset.Value)
-			data.TotalPurchased = data.TotalPurchased.Add(asset.Purchased)
-		}
+		data.Performance = newPerformance(
+			request.URL.Query(),
+			"/portfolio",
+			"Returns will be shown here after the first daily snapshot",
+		)
 
-		data.TotalProfit = data.TotalValue.Sub(data.TotalPurchased)
+		if err := loadPortfolioPerformance(conn, &data.User, &data.PortfolioSummary, &data.Performance); err != nil {
+			util.RespondError(writer, err)
 
-		if data.TotalPurchased.IsZero() {
-			data.AveragePerformance = decimal.Zero
-		} else {
-			data.AveragePerformance = data.TotalValue.Div(data.TotalPurchased).Sub(One).Mul(Hundred)
+			return
 		}
 	}
 
@@ -428,234 +690,343 @@ func HandlePortfolio(conn *database.Conn, writer http.ResponseWriter, request *h
 
 type AssetAdjustData struct {
 	PortfolioPageData
-	asset  model.Asset
-	crypto decimal.Decimal
-	fiat   decimal.Decimal
+	// asset is the holding of the asset, worked out from transactionList.
+	asset           model.Asset
+	transactionList []model.Transaction
+	lotList         []ledger.Lot
+	transaction     model.Transaction
+	crypto          decimal.Decimal
+	fiat            decimal.Decimal
+	fee             decimal.Decimal
+	feeInCrypto     bool
 }
 
-func loadAssetAdjustFormData(
-	conn *database.Conn,
-	data *AssetAdjustData,
-	writer http.ResponseWriter,
-	request *http.Request,
-) bool {
-	if !loadUser(conn, writer, request, &data.User) {
-		util.RespondForbidden(writer)
+// loadAssetAdjustData loads a portfolio and the ledger of an asset in it for
+// a user, so the asset can be traded.
+//
+// database.ErrNoRows is returned for an unknown currency.
+func loadAssetAdjustData(conn *database.Conn, portfolioID int64, ticker string, data *AssetAdjustData) error {
+	if err := loadPortfolio(conn, &data.User, portfolioID, &data.Portfolio); err != nil {
+		if err == database.ErrNoRows {
+			return util.ValidationError("Portfolio not configured")
+		}
 
-		return false
+		return err
 	}
 
-	if err := loadPortfolio(conn, &data.User, &data.Portfolio); err != nil {
-		if err != database.ErrNoRows {
-			util.RespondValidationError(writer, "Portfolio not configured")
-		} else {
-			util.RespondInternalServerError(writer, err)
-		}
+	if err := loadCurrencyByTicker(conn, &data.asset.Currency, ticker); err != nil {
+		return err
+	}
 
-		return false
+	if err := loadLedger(conn, &data.User, &data.Portfolio, &data.asset.Currency, &data.transactionList); err != nil {
+		return err
 	}
 
-	ticker := mux.Vars(request)["ticker"]
+	return replayLedger(data)
+}
 
-	if err := loadCurrencyByTicker(conn, &data.asset.Currency, ticker); err != nil {
-		if err == database.ErrNoRows {
-			util.RespondNotFound(writer)
-		} else {
-			util.RespondInternalServerError(writer, err)
+// replayLedger works out the holding of an asset from its ledger.
+func replayLedger(data *AssetAdjustData) error {
+	holding, err := ledger.Replay(data.transactionList, data.Portfolio.CostBasisMethod)
+
+	if err != nil {
+		if errors.Is(err, ledger.ErrInsufficientAmount) {
+			return util.ValidationError("This change would remove more than you hold: " + err.Error())
 		}
 
-		return false
+		return err
 	}
 
-	row := conn.QueryRow(
-		`select currency_ticker, currency_name, purchased, amount
-		from crypto_asset
-		where user_id = ? and currency_ticker = ?
-		order by updated_at desc
-		limit 1`,
-		data.User.ID,
-		data.asset.Currency.Ticker,
-	)
+	data.asset.Amount = holding.Amount
+	data.asset.Purchased = holding.Cost
+	data.lotList = holding.LotList
 
-	if err := scanAsset(row, &data.asset); err != nil {
-		if err != database.ErrNoRows {
-			util.RespondInternalServerError(writer, err)
+	return nil
+}
 
-			return false
-		}
+// parseAssetAdjustment reads the amounts of crypto and fiat being traded.
+func parseAssetAdjustment(values url.Values, data *AssetAdjustData) error {
+	var err error
+	data.fiat, err = decimal.NewFromString(values.Get("fiat"))
 
-		data.asset.Purchased = decimal.Zero
-		data.asset.Amount = decimal.Zero
+	if err != nil {
+		return util.ValidationError("Invalid fiat value")
 	}
 
-	request.ParseForm()
+	if data.fiat.IsNegative() {
+		return util.ValidationError("fiat must not be negative")
+	}
 
-	var err error
-	data.fiat, err = decimal.NewFromString(request.Form.Get("fiat"))
+	data.crypto, err = decimal.NewFromString(values.Get("crypto"))
 
 	if err != nil {
-		util.RespondValidationError(writer, "Invalid fiat value")
+		return util.ValidationError("Invalid crypto value")
+	}
 
-		return false
+	if !data.crypto.IsPositive() {
+		return util.ValidationError("crypto must be positive")
 	}
 
-	if data.fiat.IsNegative() {
-		util.RespondValidationError(writer, "fiat must not be negative")
+	data.fee, data.feeInCrypto, err = parseFee(values)
 
-		return false
+	return err
+}
+
+// newTrade creates a transaction for trading the amounts in the data now.
+func newTrade(kind string, data *AssetAdjustData) error {
+	var err error
+
+	data.transaction = model.Transaction{
+		Currency: data.asset.Currency,
+		Kind:     kind,
+		Time:     time.Now().UTC(),
+		Quantity: data.crypto,
+		Price:    data.fiat.DivRound(data.crypto, priceScale),
 	}
 
-	data.crypto, err = decimal.NewFromString(request.Form.Get("crypto"))
+	if err := setFee(&data.transaction, data.fee, data.feeInCrypto); err != nil {
+		return err
+	}
 
-	if err != nil {
-		util.RespondValidationError(writer, "Invalid crypto value")
+	data.transaction.ID, err = database.RandomID()
 
-		return false
+	return err
+}
+
+// buyAsset swaps some cash for a cryptocurrency asset.
+//
+// Fees paid in fiat add to the cost, and fees paid in crypto reduce the
+// amount received.
+func buyAsset(data *AssetAdjustData) error {
+	if err := newTrade(ledger.Buy, data); err != nil {
+		return err
 	}
 
-	if !data.crypto.IsPositive() {
-		util.RespondValidationError(writer, "crypto must be positive")
+	if ledger.Cost(&data.transaction).GreaterThan(data.Portfolio.Cash) {
+		return util.ValidationError("You can't spend more fiat than you have")
+	}
 
-		return false
+	return nil
+}
+
+// sellAsset swaps some cryptocurrency asset for cash.
+//
+// Fees paid in fiat reduce the proceeds, and fees paid in crypto are taken
+// from the asset on top of the amount sold.
+func sellAsset(data *AssetAdjustData) error {
+	if err := newTrade(ledger.Sell, data); err != nil {
+		return err
+	}
+
+	if ledger.Amount(&data.transaction).GreaterThan(data.asset.Amount) {
+		return util.ValidationError("You can't remove more crypto than you have")
 	}
 
-	return true
+	if ledger.Proceeds(&data.transaction).IsNegative() {
+		return util.ValidationError("The fee can't be more than the fiat received")
+	}
+
+	return nil
 }
 
-func saveAssetAdjustChanges(
+// adjustAsset buys or sells an asset for a user and saves the changes.
+//
+// The web UI and the JSON API both trade through this function, so they
+// follow the same rules.
+func adjustAsset(
 	conn *database.Conn,
+	portfolioID int64,
+	ticker string,
+	values url.Values,
 	data *AssetAdjustData,
-	writer http.ResponseWriter,
-	_ *http.Request,
-) bool {
-	if err := updateAsset(conn, &data.User, &data.asset); err != nil {
-		util.RespondInternalServerError(writer, err)
-
-		return false
+	adjust func(data *AssetAdjustData) error,
+) error {
+	if err := loadAssetAdjustData(conn, portfolioID, ticker, data); err != nil {
+		return err
 	}
 
-	if err := updatePortfolio(conn, &data.User, &data.Portfolio); err != nil {
-		util.RespondInternalServerError(writer, err)
+	if err := parseAssetAdjustment(values, data); err != nil {
+		return err
+	}
 
-		return false
+	if err := adjust(data); err != nil {
+		return err
 	}
 
-	return true
+	return saveLedgerChange(conn, data, nil, &data.transaction)
 }
 
-// HandleAssetBuy swaps some cash for a cryptocurrency asset.
-func HandleAssetBuy(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+func handleAssetAdjustment(
+	conn *database.Conn,
+	writer http.ResponseWriter,
+	request *http.Request,
+	adjust func(data *AssetAdjustData) error,
+) {
 	data := AssetAdjustData{}
 
-	if loadAssetAdjustFormData(conn, &data, writer, request) {
-		if data.fiat.GreaterThan(data.Portfolio.Cash) {
-			util.RespondValidationError(writer, "You can't spend more fiat than you have")
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
 
-			return
-		}
+		return
+	}
 
-		data.asset.Purchased = data.asset.Purchased.Add(data.fiat)
-		data.asset.Amount = data.asset.Amount.Add(data.crypto)
-		data.Portfolio.Cash = data.Portfolio.Cash.Sub(data.fiat)
+	request.ParseForm()
 
-		if saveAssetAdjustChanges(conn, &data, writer, request) {
-			http.Redirect(writer, request, "/portfolio", http.StatusFound)
+	if err := adjustAsset(
+		conn,
+		selectedPortfolioID(request),
+		mux.Vars(request)["ticker"],
+		request.Form,
+		&data,
+		adjust,
+	); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondError(writer, err)
 		}
+
+		return
 	}
+
+	http.Redirect(writer, request, "/portfolio", http.StatusFound)
+}
+
+// HandleAssetBuy swaps some cash for a cryptocurrency asset.
+func HandleAssetBuy(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	handleAssetAdjustment(conn, writer, request, buyAsset)
 }
 
 // HandleAssetSell swaps some cryptocurrency asset for cash.
 func HandleAssetSell(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
-	data := AssetAdjustData{}
+	handleAssetAdjustment(conn, writer, request, sellAsset)
+}
 
-	if loadAssetAdjustFormData(conn, &data, writer, request) {
-		if data.crypto.GreaterThan(data.asset.Amount) {
-			util.RespondValidationError(writer, "You can't remove more crypto than you have")
+// TrackedLot is a lot of an asset valued at the latest price.
+type TrackedLot struct {
+	ledger.Lot
+	Value decimal.Decimal
+	// Gain is the unrealized gain or loss on the lot.
+	Gain        decimal.Decimal
+	Performance decimal.Decimal
+}
 
-			return
-		}
+// trackLots values the lots of an asset at the price the asset was valued at.
+func trackLots(asset *TrackedAsset, lotList []ledger.Lot) []TrackedLot {
+	trackedLotList := make([]TrackedLot, len(lotList))
+	price := decimal.Zero
+
+	if asset.Amount.IsPositive() {
+		price = asset.Value.Div(asset.Amount)
+	}
 
-		// Subtract the cost by the average cost of the asset sold.
-		differencePurchased := data.asset.Purchased.Mul(data.crypto.Div(data.asset.Amount))
-		data.asset.Purchased = data.asset.Purchased.Sub(differencePurchased)
-		data.asset.Amount = data.asset.Amount.Sub(data.crypto)
-		data.Portfolio.Cash = data.Portfolio.Cash.Add(data.fiat)
+	for i, lot := range lotList {
+		trackedLot := &trackedLotList[i]
+		trackedLot.Lot = lot
+		trackedLot.Value = lot.Amount.Mul(price)
+		trackedLot.Gain = trackedLot.Value.Sub(lot.Cost)
 
-		if saveAssetAdjustChanges(conn, &data, writer, request) {
-			http.Redirect(writer, request, "/portfolio", http.StatusFound)
+		if lot.Cost.IsZero() {
+			trackedLot.Performance = decimal.Zero
+		} else {
+			trackedLot.Performance = trackedLot.Value.Div(lot.Cost).Sub(One).Mul(Hundred)
 		}
 	}
+
+	return trackedLotList
 }
 
 type AssetPageData struct {
 	PortfolioPageData
-	Asset TrackedAsset
+	Asset           TrackedAsset
+	LotList         []TrackedLot
+	MethodNames     map[string]string
+	TransactionList []model.Transaction
+	// Transaction holds the defaults for the form for adding a transaction.
+	Transaction    model.Transaction
+	KindList       []string
+	ChartRangeList []ChartRange
+	ChartRange     ChartRange
+	Chart          chart.Chart
+	Performance    Performance
 }
 
-// HandleAsset displays the details for a single Cryptocurrency asset.
+// HandleAsset displays the details and trade history for a single
+// cryptocurrency asset.
 func HandleAsset(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
 	data := AssetPageData{}
+	adjustData := AssetAdjustData{}
 
-	if !loadUser(conn, writer, request, &data.User) {
+	if !loadUser(conn, writer, request, &adjustData.User) {
 		http.Redirect(writer, request, "/login", http.StatusFound)
 
 		return
 	}
 
-	if err := loadPortfolio(conn, &data.User, &data.Portfolio); err != nil {
-		if err != database.ErrNoRows {
+	if err := loadAssetAdjustData(conn, selectedPortfolioID(request), mux.Vars(request)["ticker"], &adjustData); err != nil {
+		if err == database.ErrNoRows {
 			util.RespondNotFound(writer)
 		} else {
-			util.RespondInternalServerError(writer, err)
+			util.RespondError(writer, err)
 		}
 
 		return
 	}
 
-	ticker := mux.Vars(request)["ticker"]
+	data.PortfolioPageData = adjustData.PortfolioPageData
+	data.TransactionList = adjustData.transactionList
+	data.Transaction = model.Transaction{
+		Currency: adjustData.asset.Currency,
+		Kind:     ledger.Buy,
+		Time:     time.Now().UTC(),
+	}
+	data.KindList = ledger.KindList
+	assetList := []TrackedAsset{{Asset: adjustData.asset}}
 
-	if err := loadCurrencyByTicker(conn, &data.Asset.Currency, ticker); err != nil {
-		if err == database.ErrNoRows {
-			util.RespondNotFound(writer)
-		} else {
-			util.RespondInternalServerError(writer, err)
-		}
+	if err := loadAssetPrices(conn, &data.Portfolio.Currency, assetList); err != nil {
+		util.RespondInternalServerError(writer, err)
 
 		return
 	}
 
-	row := conn.QueryRow(
-		`select currency_ticker, currency_name, purchased, amount
-		from crypto_asset
-		where user_id = ? and currency_ticker = ?
-		order by updated_at desc
-		limit 1`,
-		data.User.ID,
-		data.Asset.Currency.Ticker,
-	)
+	data.Asset = assetList[0]
+	data.LotList = trackLots(&data.Asset, adjustData.lotList)
+	data.MethodNames = ledger.MethodNames
+	data.ChartRangeList = chartRangeList
+	data.ChartRange = parseChartRange(request.URL.Query())
 
-	assetList := make([]TrackedAsset, 1)
+	if err := loadAssetChart(
+		conn,
+		&data.Asset.Asset,
+		&data.Portfolio.Currency,
+		&data.ChartRange,
+		&data.Chart,
+	); err != nil {
+		util.RespondInternalServerError(writer, err)
 
-	if err := scanTrackedAsset(row, &assetList[0]); err != nil {
-		if err != database.ErrNoRows {
-			util.RespondInternalServerError(writer, err)
+		return
+	}
 
-			return
-		}
+	addTradeMarkers(&data.Chart, data.TransactionList, &data.ChartRange)
 
-		assetList[0].Currency = data.Asset.Currency
-		assetList[0].Purchased = decimal.Zero
-		assetList[0].Amount = decimal.Zero
-	}
+	data.Performance = newPerformance(
+		request.URL.Query(),
+		"/portfolio/"+data.Asset.Currency.Ticker,
+		"There isn't enough price history to work out returns",
+	)
 
-	if err := loadAssetPrices(conn, &data.Portfolio.Currency, assetList); err != nil {
+	if err := loadAssetPerformance(
+		conn,
+		&data.Asset,
+		&data.Portfolio.Currency,
+		data.TransactionList,
+		&data.Performance,
+	); err != nil {
 		util.RespondInternalServerError(writer, err)
 
 		return
 	}
 
-	data.Asset = assetList[0]
-
 	template.Render(template.Asset, writer, data)
 }
 
@@ -0,0 +1,327 @@
+package portfolio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/dense-analysis/pricewarp/internal/tax"
+	"github.com/dense-analysis/pricewarp/internal/template"
+	"github.com/shopspring/decimal"
+)
+
+// reportYearCount is how many past tax years can be chosen for a report.
+const reportYearCount = 10
+
+// reportColumns are the columns of a tax report exported as CSV.
+var reportColumns = []string{
+	"currency",
+	"acquired",
+	"disposed",
+	"amount",
+	"proceeds",
+	"cost",
+	"gain",
+	"match",
+}
+
+// TaxReport is every disposal in a tax year, matched with a rule set.
+type TaxReport struct {
+	Rule string
+	Year tax.Year
+	// Currency is the currency every amount in the report is in.
+	Currency      model.Currency
+	DisposalList  []tax.Disposal
+	TotalProceeds decimal.Decimal
+	TotalCost     decimal.Decimal
+	TotalGain     decimal.Decimal
+	TotalLoss     decimal.Decimal
+}
+
+// loadTaxLedgers loads the transactions of every asset in every portfolio of
+// a user, with prices and fees converted to the currency of a report.
+//
+// The transactions for each asset are merged into one ledger, as tax rules
+// match disposals with the acquisitions of a taxpayer, not of a portfolio.
+// Amounts in other currencies are converted at the closing exchange rate of
+// the day of each transaction.
+func loadTaxLedgers(
+	conn *database.Conn,
+	user *model.User,
+	currency *model.Currency,
+	ledgerMap map[string][]model.Transaction,
+) error {
+	var portfolioList []model.Portfolio
+
+	if err := loadPortfolioList(conn, user, &portfolioList); err != nil {
+		return err
+	}
+
+	for i := range portfolioList {
+		var currencyList []model.Currency
+
+		portfolio := &portfolioList[i]
+
+		if err := loadLedgerCurrencyList(conn, user, portfolio, &currencyList); err != nil {
+			return err
+		}
+
+		for j := range currencyList {
+			var transactionList []model.Transaction
+
+			if err := loadLedger(conn, user, portfolio, &currencyList[j], &transactionList); err != nil {
+				return err
+			}
+
+			if err := convertTransactionList(conn, &portfolio.Currency, currency, transactionList); err != nil {
+				return err
+			}
+
+			ticker := currencyList[j].Ticker
+			ledgerMap[ticker] = append(ledgerMap[ticker], transactionList...)
+		}
+	}
+
+	return nil
+}
+
+// convertTransactionList converts the prices and fiat fees of transactions,
+// oldest first, from one currency to another at the closing rate of the day
+// of each transaction.
+func convertTransactionList(
+	conn *database.Conn,
+	from *model.Currency,
+	to *model.Currency,
+	transactionList []model.Transaction,
+) error {
+	if from.Ticker == to.Ticker || len(transactionList) == 0 {
+		return nil
+	}
+
+	rates, err := loadDailyRates(
+		conn,
+		from,
+		to,
+		transactionList[0].Time,
+		transactionList[len(transactionList)-1].Time.Add(24*time.Hour),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range transactionList {
+		transaction := &transactionList[i]
+		rate, ok := rates.at(transaction.Time)
+
+		if !ok {
+			return util.ValidationError(fmt.Sprintf(
+				"There is no price to convert %s to %s on %s",
+				from.Ticker,
+				to.Ticker,
+				transaction.Time.UTC().Format(time.DateOnly),
+			))
+		}
+
+		transaction.Price = transaction.Price.Mul(rate)
+
+		if transaction.FeeCurrency != transaction.Currency.Ticker {
+			transaction.Fee = transaction.Fee.Mul(rate)
+		}
+	}
+
+	return nil
+}
+
+// LoadTaxReport matches the sales of every asset a user has traded in any
+// portfolio, and keeps the disposals in a tax year.
+func LoadTaxReport(conn *database.Conn, user *model.User, report *TaxReport) error {
+	ledgerMap := map[string][]model.Transaction{}
+
+	if err := loadTaxLedgers(conn, user, &report.Currency, ledgerMap); err != nil {
+		return err
+	}
+
+	tickerList := make([]string, 0, len(ledgerMap))
+
+	for ticker := range ledgerMap {
+		tickerList = append(tickerList, ticker)
+	}
+
+	slices.Sort(tickerList)
+
+	report.DisposalList = nil
+	report.TotalProceeds = decimal.Zero
+	report.TotalCost = decimal.Zero
+	report.TotalGain = decimal.Zero
+	report.TotalLoss = decimal.Zero
+
+	for _, ticker := range tickerList {
+		transactionList := ledgerMap[ticker]
+		ledger.Sort(transactionList)
+		disposalList, err := tax.Match(report.Rule, transactionList)
+
+		if err != nil {
+			if errors.Is(err, tax.ErrUnmatched) {
+				return util.ValidationError(err.Error())
+			}
+
+			return err
+		}
+
+		for _, disposal := range disposalList {
+			if !report.Year.Contains(disposal.DisposedAt) {
+				continue
+			}
+
+			report.DisposalList = append(report.DisposalList, disposal)
+			report.TotalProceeds = report.TotalProceeds.Add(disposal.Proceeds)
+			report.TotalCost = report.TotalCost.Add(disposal.Cost)
+
+			if gain := disposal.Gain(); gain.IsNegative() {
+				report.TotalLoss = report.TotalLoss.Sub(gain)
+			} else {
+				report.TotalGain = report.TotalGain.Add(gain)
+			}
+		}
+	}
+
+	slices.SortStableFunc(report.DisposalList, func(a, b tax.Disposal) int {
+		return a.DisposedAt.Compare(b.DisposedAt)
+	})
+
+	return nil
+}
+
+// parseTaxReport reads the rule set and tax year for a report.
+//
+// The report is in the currency of the portfolio. The rule set defaults to the
+// UK rules for portfolios in GBP, and the US rules otherwise. The year
+// defaults to the current tax year.
+func parseTaxReport(values url.Values, portfolio *model.Portfolio, report *TaxReport) error {
+	report.Currency = portfolio.Currency
+	report.Rule = values.Get("rule")
+
+	if report.Rule == "" {
+		if portfolio.Currency.Ticker == "GBP" {
+			report.Rule = tax.UK
+		} else {
+			report.Rule = tax.US
+		}
+	}
+
+	if !tax.IsValidRule(report.Rule) {
+		return util.ValidationError("Invalid tax rules")
+	}
+
+	year := tax.CurrentTaxYear(report.Rule, time.Now())
+
+	if value := values.Get("year"); value != "" {
+		var err error
+
+		if year, err = strconv.Atoi(value); err != nil || year < 1970 || year > 9999 {
+			return util.ValidationError("Invalid tax year")
+		}
+	}
+
+	report.Year = tax.TaxYear(report.Rule, year)
+
+	return nil
+}
+
+func disposalCSVRow(disposal *tax.Disposal) []string {
+	acquired := ""
+
+	if !disposal.AcquiredAt.IsZero() {
+		acquired = disposal.AcquiredAt.UTC().Format(time.DateOnly)
+	}
+
+	return []string{
+		disposal.Currency.Ticker,
+		acquired,
+		disposal.DisposedAt.UTC().Format(time.DateOnly),
+		disposal.Amount.String(),
+		disposal.Proceeds.StringFixed(2),
+		disposal.Cost.StringFixed(2),
+		disposal.Gain().StringFixed(2),
+		disposal.Match,
+	}
+}
+
+type TaxReportPageData struct {
+	PortfolioPageData
+	TaxReport
+	RuleList  []string
+	RuleNames map[string]string
+	YearList  []tax.Year
+}
+
+// HandleTaxReport shows the disposals in a tax year, or exports them as CSV.
+func HandleTaxReport(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := TaxReportPageData{RuleList: tax.RuleList, RuleNames: tax.RuleNames}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		http.Redirect(writer, request, "/login", http.StatusFound)
+
+		return
+	}
+
+	if err := loadPortfolio(conn, &data.User, selectedPortfolioID(request), &data.Portfolio); err != nil {
+		if err == database.ErrNoRows {
+			http.Redirect(writer, request, "/portfolio", http.StatusFound)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return
+	}
+
+	values := request.URL.Query()
+
+	if err := parseTaxReport(values, &data.Portfolio, &data.TaxReport); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	if err := LoadTaxReport(conn, &data.User, &data.TaxReport); err != nil {
+		util.RespondError(writer, err)
+
+		return
+	}
+
+	if values.Get("format") == "csv" {
+		writer.Header().Set(
+			"Content-Disposition",
+			"attachment; filename=capital-gains-"+data.Rule+"-"+strconv.Itoa(data.Year.Start.Year())+".csv",
+		)
+		writer.Header().Set("Content-Type", "text/csv")
+		csvWriter := csv.NewWriter(writer)
+		_ = csvWriter.Write(reportColumns)
+
+		for i := range data.DisposalList {
+			_ = csvWriter.Write(disposalCSVRow(&data.DisposalList[i]))
+		}
+
+		csvWriter.Flush()
+
+		return
+	}
+
+	currentYear := tax.CurrentTaxYear(data.Rule, time.Now())
+
+	for year := currentYear; year > currentYear-reportYearCount; year-- {
+		data.YearList = append(data.YearList, tax.TaxYear(data.Rule, year))
+	}
+
+	template.Render(template.TaxReport, writer, data)
+}
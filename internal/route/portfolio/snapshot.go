@@ -0,0 +1,115 @@
+package portfolio
+
+import (
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/chart"
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+)
+
+// snapshotChartDuration is how far back the portfolio value chart goes.
+const snapshotChartDuration = 365 * 24 * time.Hour
+
+// SaveSnapshot saves the value of one of a user's portfolios for the current day.
+//
+// Saving a snapshot again on the same day replaces the earlier snapshot.
+// database.ErrNoRows is returned if the user hasn't set up the portfolio.
+func SaveSnapshot(conn *database.Conn, user *model.User, portfolioID int64) error {
+	var summary PortfolioSummary
+
+	if err := LoadPortfolioSummary(conn, user, portfolioID, &summary); err != nil {
+		return err
+	}
+
+	return conn.Exec(
+		`insert into crypto_portfolio_snapshot
+			(user_id, portfolio_id, username, snapshot_date, currency_ticker, currency_name,
+			 value, purchased, cash, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, now64(9))`,
+		user.ID,
+		portfolioID,
+		user.Username,
+		time.Now().UTC().Truncate(24*time.Hour),
+		summary.Portfolio.Currency.Ticker,
+		summary.Portfolio.Currency.Name,
+		summary.TotalValue,
+		summary.TotalPurchased.Sub(summary.Portfolio.Cash),
+		summary.Portfolio.Cash,
+	)
+}
+
+func scanSnapshot(row database.Row, snapshot *model.PortfolioSnapshot) error {
+	return row.Scan(
+		&snapshot.Date,
+		&snapshot.Currency.Ticker,
+		&snapshot.Currency.Name,
+		&snapshot.Value,
+		&snapshot.Purchased,
+		&snapshot.Cash,
+	)
+}
+
+// loadSnapshotList loads the snapshots of a portfolio in its currency since a
+// time, oldest first.
+func loadSnapshotList(
+	conn *database.Conn,
+	userID int64,
+	portfolio *model.Portfolio,
+	start time.Time,
+	snapshotList *[]model.PortfolioSnapshot,
+) error {
+	return model.LoadList(
+		conn,
+		snapshotList,
+		365,
+		scanSnapshot,
+		`select snapshot_date, currency_ticker, currency_name, value, purchased, cash
+		from crypto_portfolio_snapshot final
+		where user_id = ? and portfolio_id = ? and currency_ticker = ? and snapshot_date >= ?
+		order by snapshot_date`,
+		userID,
+		portfolio.ID,
+		portfolio.Currency.Ticker,
+		start,
+	)
+}
+
+// loadSnapshotChart loads a chart of the value of a portfolio against the
+// amount invested in it.
+//
+// Snapshots in other currencies are left out, as they can't be compared.
+func loadSnapshotChart(
+	conn *database.Conn,
+	userID int64,
+	portfolio *model.Portfolio,
+	snapshotChart *chart.Chart,
+) error {
+	var snapshotList []model.PortfolioSnapshot
+
+	start := time.Now().UTC().Add(-snapshotChartDuration)
+
+	if err := loadSnapshotList(conn, userID, portfolio, start, &snapshotList); err != nil {
+		return err
+	}
+
+	value := chart.Series{Name: "Value", Class: "value"}
+	invested := chart.Series{Name: "Invested", Class: "invested"}
+
+	for _, snapshot := range snapshotList {
+		value.PointList = append(value.PointList, chart.Point{
+			Time:  snapshot.Date,
+			Value: snapshot.Value,
+		})
+		invested.PointList = append(invested.PointList, chart.Point{
+			Time:  snapshot.Date,
+			Value: snapshot.Purchased.Add(snapshot.Cash),
+		})
+	}
+
+	snapshotChart.SeriesList = []chart.Series{invested, value}
+	snapshotChart.TimeFormat = "Jan 2 2006"
+	snapshotChart.EmptyText = "Your portfolio value will be shown here after the first daily snapshot"
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package portfolio
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/query"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// receivedScale is the number of decimal places kept for amounts received in
+// a swap that are worked out from prices.
+const receivedScale = 8
+
+// loadMarketPrice loads the latest price of an asset in the portfolio
+// currency, converting through BTC if there's no price for the pair.
+func loadMarketPrice(conn *database.Conn, data *AssetAdjustData) (decimal.Decimal, error) {
+	var price model.Price
+
+	fromTicker := data.asset.Currency.Ticker
+	toTicker := data.Portfolio.Currency.Ticker
+
+	if _, err := query.LoadConvertedLatestPrice(conn, &price, fromTicker, toTicker); err != nil {
+		if err == database.ErrNoRows {
+			return decimal.Zero, util.ValidationError("There is no recent price for " + fromTicker + " in " + toTicker)
+		}
+
+		return decimal.Zero, err
+	}
+
+	if !price.Value.IsPositive() {
+		return decimal.Zero, util.ValidationError("There is no recent price for " + fromTicker + " in " + toTicker)
+	}
+
+	return price.Value, nil
+}
+
+// parseAmount reads a positive amount from a form.
+func parseAmount(values url.Values, name string) (decimal.Decimal, error) {
+	amount, err := decimal.NewFromString(values.Get(name))
+
+	if err != nil {
+		return decimal.Zero, util.ValidationError("Invalid " + name + " value")
+	}
+
+	if !amount.IsPositive() {
+		return decimal.Zero, util.ValidationError(name + " must be positive")
+	}
+
+	return amount, nil
+}
+
+// swapAssets swaps an amount of one asset for another at market value.
+//
+// A swap is saved as a sale of the asset given and a purchase of the asset
+// received, both for the value of the asset given at its latest price. The
+// cash in the portfolio doesn't change, and the sale and purchase follow the
+// cost basis method of the portfolio like any other trade. Without an amount
+// received, the amount is worked out from the latest price of the asset
+// received.
+func swapAssets(
+	conn *database.Conn,
+	portfolioID int64,
+	fromTicker string,
+	values url.Values,
+	from *AssetAdjustData,
+) error {
+	toTicker := values.Get("to")
+
+	if toTicker == fromTicker {
+		return util.ValidationError("You can't swap an asset for itself")
+	}
+
+	to := AssetAdjustData{}
+	to.User = from.User
+
+	if err := loadAssetAdjustData(conn, portfolioID, fromTicker, from); err != nil {
+		return err
+	}
+
+	if err := loadAssetAdjustData(conn, portfolioID, toTicker, &to); err != nil {
+		return err
+	}
+
+	amount, err := parseAmount(values, "crypto")
+
+	if err != nil {
+		return err
+	}
+
+	if amount.GreaterThan(from.asset.Amount) {
+		return util.ValidationError("You can't remove more crypto than you have")
+	}
+
+	price, err := loadMarketPrice(conn, from)
+
+	if err != nil {
+		return err
+	}
+
+	value := amount.Mul(price)
+	var received decimal.Decimal
+
+	if values.Get("received") != "" {
+		if received, err = parseAmount(values, "received"); err != nil {
+			return err
+		}
+	} else {
+		receivedPrice, err := loadMarketPrice(conn, &to)
+
+		if err != nil {
+			return err
+		}
+
+		received = value.DivRound(receivedPrice, receivedScale)
+
+		if !received.IsPositive() {
+			return util.ValidationError("The amount received would be too small")
+		}
+	}
+
+	// Round the price of the purchase down, so it never costs more cash than
+	// the sale made.
+	receivedPrice, _ := value.QuoRem(received, priceScale)
+	now := time.Now().UTC()
+
+	from.transaction = model.Transaction{
+		Currency: from.asset.Currency,
+		Kind:     ledger.Sell,
+		Time:     now,
+		Quantity: amount,
+		Price:    price,
+		Fee:      decimal.Zero,
+		Note:     "Swapped for " + received.String() + " " + toTicker,
+	}
+	to.transaction = model.Transaction{
+		Currency: to.asset.Currency,
+		Kind:     ledger.Buy,
+		Time:     now,
+		Quantity: received,
+		Price:    receivedPrice,
+		Fee:      decimal.Zero,
+		Note:     "Swapped from " + amount.String() + " " + fromTicker,
+	}
+
+	if from.transaction.ID, err = database.RandomID(); err != nil {
+		return err
+	}
+
+	if to.transaction.ID, err = database.RandomID(); err != nil {
+		return err
+	}
+
+	if from.transaction.SwapID, err = database.RandomID(); err != nil {
+		return err
+	}
+
+	to.transaction.SwapID = from.transaction.SwapID
+
+	saleList := []model.Transaction{from.transaction}
+	purchaseList := []model.Transaction{to.transaction}
+
+	// Both sides are checked before anything is saved, so a purchase that
+	// can't be made doesn't leave the sale behind.
+	if err := applyLedgerChanges(from, nil, saleList); err != nil {
+		return err
+	}
+
+	// The purchase is paid for with the cash from the sale.
+	to.Portfolio = from.Portfolio
+
+	if err := applyLedgerChanges(&to, nil, purchaseList); err != nil {
+		return err
+	}
+
+	if err := writeLedgerChanges(conn, from, nil, saleList); err != nil {
+		return err
+	}
+
+	if err := writeLedgerChanges(conn, &to, nil, purchaseList); err != nil {
+		return err
+	}
+
+	return updatePortfolio(conn, &to.User, &to.Portfolio)
+}
+
+// deleteSwap deletes both sides of a swap, and puts back the assets and cash
+// in the portfolio.
+func deleteSwap(conn *database.Conn, user *model.User, swapID int64) error {
+	var transactionList []model.Transaction
+
+	if err := model.LoadList(
+		conn,
+		&transactionList,
+		2,
+		scanTransaction,
+		transactionQuery+`where is_deleted = 0 and swap_id = ?`,
+		user.ID,
+		swapID,
+	); err != nil {
+		return err
+	}
+
+	if len(transactionList) == 0 {
+		return database.ErrNoRows
+	}
+
+	// The purchase is removed before the sale, so the cash it gives back pays
+	// for removing the sale.
+	slices.SortStableFunc(transactionList, func(a, b model.Transaction) int {
+		return strings.Compare(a.Kind, b.Kind)
+	})
+
+	dataList := make([]AssetAdjustData, len(transactionList))
+
+	for i := range transactionList {
+		data := &dataList[i]
+		data.User = *user
+
+		if err := loadAssetAdjustData(conn, transactionList[i].PortfolioID, transactionList[i].Currency.Ticker, data); err != nil {
+			return err
+		}
+
+		// Both sides change the cash of the same portfolio.
+		if i > 0 {
+			data.Portfolio = dataList[i-1].Portfolio
+		}
+
+		if err := applyLedgerChanges(data, transactionList[i:i+1], nil); err != nil {
+			return err
+		}
+	}
+
+	for i := range dataList {
+		if err := writeLedgerChanges(conn, &dataList[i], transactionList[i:i+1], nil); err != nil {
+			return err
+		}
+	}
+
+	return updatePortfolio(conn, user, &dataList[len(dataList)-1].Portfolio)
+}
+
+// HandleAssetSwap swaps some of a cryptocurrency asset for another asset.
+func HandleAssetSwap(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := AssetAdjustData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	request.ParseForm()
+
+	if err := swapAssets(conn, selectedPortfolioID(request), mux.Vars(request)["ticker"], request.Form, &data); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondError(writer, err)
+		}
+
+		return
+	}
+
+	http.Redirect(writer, request, "/portfolio", http.StatusFound)
+}
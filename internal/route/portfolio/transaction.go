@@ -0,0 +1,679 @@
+package portfolio
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/dense-analysis/pricewarp/internal/template"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// priceScale is the number of decimal places prices are stored with.
+const priceScale = 20
+
+// maxTransactionNoteLength limits the length of transaction notes.
+const maxTransactionNoteLength = 500
+
+// Values of fee currency fields, for fees paid in fiat or in the asset.
+const (
+	feeCurrencyFiat   = "fiat"
+	feeCurrencyCrypto = "crypto"
+)
+
+// transactionTimeLayout is the format of datetime-local inputs with seconds.
+const transactionTimeLayout = "2006-01-02T15:04:05"
+
+var transactionQuery = `
+select
+	transaction_id,
+	portfolio_id,
+	currency_ticker,
+	currency_name,
+	kind,
+	transaction_time,
+	quantity,
+	price,
+	fee,
+	fee_currency,
+	note,
+	external_id,
+	transfer_id,
+	swap_id,
+	is_deleted
+from (
+	select *
+	from crypto_transactions
+	where user_id = ?
+	order by updated_at desc
+	limit 1 by transaction_id
+)
+`
+
+func scanTransaction(row database.Row, transaction *model.Transaction) error {
+	var isDeleted uint8
+
+	if err := row.Scan(
+		&transaction.ID,
+		&transaction.PortfolioID,
+		&transaction.Currency.Ticker,
+		&transaction.Currency.Name,
+		&transaction.Kind,
+		&transaction.Time,
+		&transaction.Quantity,
+		&transaction.Price,
+		&transaction.Fee,
+		&transaction.FeeCurrency,
+		&transaction.Note,
+		&transaction.ExternalID,
+		&transaction.TransferID,
+		&transaction.SwapID,
+		&isDeleted,
+	); err != nil {
+		return err
+	}
+
+	if isDeleted == 1 {
+		return database.ErrNoRows
+	}
+
+	return nil
+}
+
+var transactionInsertQuery = `
+insert into crypto_transactions
+	(transaction_id, user_id, portfolio_id, username, kind,
+	 currency_ticker, currency_name, transaction_time,
+	 quantity, price, fee, fee_currency, note, external_id, transfer_id,
+	 swap_id, updated_at, is_deleted)
+values (?, ?, ?, ?, ?,
+	?, ?, ?,
+	?, ?, ?, ?, ?, ?, ?,
+	?, now64(9), ?)
+`
+
+// saveTransaction writes a new version of a transaction, or marks it as deleted.
+func saveTransaction(
+	conn database.Queryable,
+	user *model.User,
+	transaction *model.Transaction,
+	isDeleted bool,
+) error {
+	return conn.Exec(
+		transactionInsertQuery,
+		transaction.ID,
+		user.ID,
+		transaction.PortfolioID,
+		user.Username,
+		transaction.Kind,
+		transaction.Currency.Ticker,
+		transaction.Currency.Name,
+		transaction.Time,
+		transaction.Quantity,
+		transaction.Price,
+		transaction.Fee,
+		transaction.FeeCurrency,
+		transaction.Note,
+		transaction.ExternalID,
+		transaction.TransferID,
+		transaction.SwapID,
+		database.BoolToUint(isDeleted),
+	)
+}
+
+// errNotBackfilled is returned for assets and cash recorded before
+// transactions and cash flows were kept, until bin/backfill has added their
+// opening balances.
+var errNotBackfilled = util.ValidationError("Run bin/backfill to record the opening balances of this portfolio")
+
+// queryLedger loads every transaction for an asset in a portfolio, in the
+// order they happened.
+func queryLedger(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	currency *model.Currency,
+	transactionList *[]model.Transaction,
+) error {
+	return model.LoadList(
+		conn,
+		transactionList,
+		10,
+		scanTransaction,
+		transactionQuery+`where is_deleted = 0 and portfolio_id = ? and currency_ticker = ?
+		order by transaction_time, transaction_id`,
+		user.ID,
+		portfolio.ID,
+		currency.Ticker,
+	)
+}
+
+// loadLedger loads every transaction for an asset in a portfolio, in the
+// order they happened.
+//
+// errNotBackfilled is returned for an asset held without any transactions, so
+// its holding isn't replaced with nothing.
+func loadLedger(
+	conn *database.Conn,
+	user *model.User,
+	portfolio *model.Portfolio,
+	currency *model.Currency,
+	transactionList *[]model.Transaction,
+) error {
+	if err := queryLedger(conn, user, portfolio, currency, transactionList); err != nil {
+		return err
+	}
+
+	if len(*transactionList) > 0 {
+		return nil
+	}
+
+	var amount decimal.Decimal
+
+	row := conn.QueryRow(
+		`select amount
+		from crypto_asset
+		where user_id = ? and portfolio_id = ? and currency_ticker = ?
+		order by updated_at desc
+		limit 1`,
+		user.ID,
+		portfolio.ID,
+		currency.Ticker,
+	)
+
+	if err := row.Scan(&amount); err != nil {
+		if err == database.ErrNoRows {
+			return nil
+		}
+
+		return err
+	}
+
+	if amount.IsPositive() {
+		return errNotBackfilled
+	}
+
+	return nil
+}
+
+// loadTotalFees loads the total of every fee paid in a portfolio.
+func loadTotalFees(conn *database.Conn, user *model.User, portfolio *model.Portfolio, totalFees *decimal.Decimal) error {
+	var fiatFees, cryptoFees decimal.Decimal
+
+	row := conn.QueryRow(
+		`select
+			sumIf(fee, fee_currency != currency_ticker),
+			sumIf(toDecimal256(fee * price, 20), fee_currency = currency_ticker)
+		from (`+transactionQuery+`)
+		where is_deleted = 0 and portfolio_id = ?`,
+		user.ID,
+		portfolio.ID,
+	)
+
+	if err := row.Scan(&fiatFees, &cryptoFees); err != nil {
+		return err
+	}
+
+	*totalFees = fiatFees.Add(cryptoFees)
+
+	return nil
+}
+
+// recalculateAssets works out the holding of every asset in a portfolio from
+// its ledger again, after the cost basis method has changed.
+func recalculateAssets(conn *database.Conn, user *model.User, portfolio *model.Portfolio) error {
+	var assetList []TrackedAsset
+
+	if err := loadAssetList(conn, user.ID, portfolio.ID, &assetList); err != nil {
+		return err
+	}
+
+	for _, trackedAsset := range assetList {
+		var transactionList []model.Transaction
+
+		asset := trackedAsset.Asset
+
+		if err := loadLedger(conn, user, portfolio, &asset.Currency, &transactionList); err != nil {
+			return err
+		}
+
+		holding, err := ledger.Replay(transactionList, portfolio.CostBasisMethod)
+
+		if err != nil {
+			return err
+		}
+
+		asset.Amount = holding.Amount
+		asset.Purchased = holding.Cost
+
+		if err := updateAsset(conn, user, portfolio, &asset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveLedgerChange replaces one transaction in a ledger with another, and
+// saves the transaction, the holding worked out from the ledger and the cash
+// left in the portfolio.
+//
+// `previous` is nil when adding a transaction, and `next` is nil when deleting
+// one. Changes that would remove more of an asset than was held at the time,
+// or spend more cash than there is, are rejected.
+func saveLedgerChange(
+	conn *database.Conn,
+	data *AssetAdjustData,
+	previous *model.Transaction,
+	next *model.Transaction,
+) error {
+	var nextList []model.Transaction
+
+	if next != nil {
+		nextList = append(nextList, *next)
+	}
+
+	return saveLedgerChanges(conn, data, previous, nextList)
+}
+
+// saveLedgerChanges replaces one transaction in a ledger with any number of
+// transactions, following the same rules as saveLedgerChange.
+func saveLedgerChanges(
+	conn *database.Conn,
+	data *AssetAdjustData,
+	previous *model.Transaction,
+	nextList []model.Transaction,
+) error {
+	var previousList []model.Transaction
+
+	if previous != nil {
+		previousList = append(previousList, *previous)
+	}
+
+	if err := applyLedgerChanges(data, previousList, nextList); err != nil {
+		return err
+	}
+
+	if err := writeLedgerChanges(conn, data, previousList, nextList); err != nil {
+		return err
+	}
+
+	return updatePortfolio(conn, &data.User, &data.Portfolio)
+}
+
+// applyLedgerChanges replaces transactions in a ledger with any number of
+// transactions without saving anything, and works out the holding and the
+// cash left in the portfolio.
+//
+// Changes that would remove more of an asset than was held at the time, or
+// spend more cash than there is, are rejected.
+func applyLedgerChanges(data *AssetAdjustData, previousList []model.Transaction, nextList []model.Transaction) error {
+	transactionList := make([]model.Transaction, 0, len(data.transactionList)+len(nextList))
+	cash := data.Portfolio.Cash
+
+	for _, transaction := range data.transactionList {
+		if !slices.ContainsFunc(previousList, func(previous model.Transaction) bool {
+			return previous.ID == transaction.ID
+		}) {
+			transactionList = append(transactionList, transaction)
+		}
+	}
+
+	for i := range previousList {
+		cash = cash.Sub(ledger.CashChange(&previousList[i]))
+	}
+
+	for i := range nextList {
+		nextList[i].PortfolioID = data.Portfolio.ID
+		transactionList = append(transactionList, nextList[i])
+		cash = cash.Add(ledger.CashChange(&nextList[i]))
+	}
+
+	ledger.Sort(transactionList)
+	data.transactionList = transactionList
+
+	if err := replayLedger(data); err != nil {
+		return err
+	}
+
+	if cash.IsNegative() && cash.LessThan(data.Portfolio.Cash) {
+		return util.ValidationError("You can't spend more fiat than you have")
+	}
+
+	data.Portfolio.Cash = cash
+
+	return nil
+}
+
+// writeLedgerChanges saves changes made by applyLedgerChanges to the
+// transactions and the holding. The portfolio is saved separately.
+//
+// Transactions replaced by a transaction with the same ID are saved again,
+// and the others are marked as deleted.
+func writeLedgerChanges(
+	conn *database.Conn,
+	data *AssetAdjustData,
+	previousList []model.Transaction,
+	nextList []model.Transaction,
+) error {
+	for i := range previousList {
+		if slices.ContainsFunc(nextList, func(next model.Transaction) bool {
+			return next.ID == previousList[i].ID
+		}) {
+			continue
+		}
+
+		if err := saveTransaction(conn, &data.User, &previousList[i], true); err != nil {
+			return err
+		}
+	}
+
+	for i := range nextList {
+		if err := saveTransaction(conn, &data.User, &nextList[i], false); err != nil {
+			return err
+		}
+	}
+
+	return updateAsset(conn, &data.User, &data.Portfolio, &data.asset)
+}
+
+// parseTransactionTime parses a time from a datetime-local input, in UTC.
+func parseTransactionTime(value string) (time.Time, error) {
+	for _, layout := range []string{transactionTimeLayout, "2006-01-02T15:04", time.RFC3339} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+
+	return time.Time{}, util.ValidationError("Invalid time")
+}
+
+// parseFee reads an optional fee from a form, and whether it was paid in
+// crypto instead of fiat.
+func parseFee(values url.Values) (decimal.Decimal, bool, error) {
+	fee := decimal.Zero
+	feeInCrypto := false
+
+	switch values.Get("fee_currency") {
+	case "", feeCurrencyFiat:
+	case feeCurrencyCrypto:
+		feeInCrypto = true
+	default:
+		return fee, false, util.ValidationError("Invalid fee currency")
+	}
+
+	if value := values.Get("fee"); value != "" {
+		var err error
+
+		if fee, err = decimal.NewFromString(value); err != nil {
+			return fee, false, util.ValidationError("Invalid fee")
+		}
+
+		if fee.IsNegative() {
+			return fee, false, util.ValidationError("Fee must not be negative")
+		}
+	}
+
+	return fee, feeInCrypto, nil
+}
+
+// setFee sets the fee for a transaction, in the asset or in fiat.
+func setFee(transaction *model.Transaction, fee decimal.Decimal, feeInCrypto bool) error {
+	transaction.Fee = fee
+	transaction.FeeCurrency = ""
+
+	if feeInCrypto && fee.IsPositive() {
+		transaction.FeeCurrency = transaction.Currency.Ticker
+	}
+
+	if !ledger.Amount(transaction).IsPositive() {
+		return util.ValidationError("The fee must be less than the quantity")
+	}
+
+	return nil
+}
+
+// parseTransaction reads the fields of a transaction from a form.
+func parseTransaction(values url.Values, transaction *model.Transaction) error {
+	var err error
+
+	transaction.Kind = values.Get("kind")
+
+	if !ledger.IsValidKind(transaction.Kind) {
+		return util.ValidationError("Invalid transaction type")
+	}
+
+	if transaction.Time, err = parseTransactionTime(values.Get("time")); err != nil {
+		return err
+	}
+
+	if transaction.Time.After(time.Now()) {
+		return util.ValidationError("Transactions can't be in the future")
+	}
+
+	if transaction.Quantity, err = decimal.NewFromString(values.Get("quantity")); err != nil {
+		return util.ValidationError("Invalid quantity")
+	}
+
+	if !transaction.Quantity.IsPositive() {
+		return util.ValidationError("Quantity must be positive")
+	}
+
+	if transaction.Price, err = decimal.NewFromString(values.Get("price")); err != nil {
+		return util.ValidationError("Invalid price")
+	}
+
+	if transaction.Price.IsNegative() {
+		return util.ValidationError("Price must not be negative")
+	}
+
+	fee, feeInCrypto, err := parseFee(values)
+
+	if err != nil {
+		return err
+	}
+
+	if err := setFee(transaction, fee, feeInCrypto); err != nil {
+		return err
+	}
+
+	transaction.Note = strings.TrimSpace(values.Get("note"))
+
+	if len(transaction.Note) > maxTransactionNoteLength {
+		return util.ValidationError("Notes must be at most 500 characters")
+	}
+
+	return nil
+}
+
+// errTransferEdit is returned for edits to one transaction in a transfer, as
+// the sides of a transfer must match.
+var errTransferEdit = util.ValidationError("Transfers can't be edited. Delete the transfer and make it again.")
+
+// errSwapEdit is returned for edits to one side of a swap, as the sale pays
+// for the purchase.
+var errSwapEdit = util.ValidationError("Swaps can't be edited. Delete the swap and make it again.")
+
+// loadTransactionByRouteID loads the transaction for the `{id}` in a route.
+func loadTransactionByRouteID(
+	conn *database.Conn,
+	request *http.Request,
+	user *model.User,
+	transaction *model.Transaction,
+) error {
+	transactionID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+
+	if err != nil {
+		return database.ErrNoRows
+	}
+
+	row := conn.QueryRow(transactionQuery+"where transaction_id = ?", user.ID, transactionID)
+
+	return scanTransaction(row, transaction)
+}
+
+type TransactionPageData struct {
+	PortfolioPageData
+	Transaction model.Transaction
+	KindList    []string
+}
+
+// HandleTransaction shows a form for editing a transaction.
+func HandleTransaction(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := TransactionPageData{KindList: ledger.KindList}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		http.Redirect(writer, request, "/login", http.StatusFound)
+
+		return
+	}
+
+	if err := loadTransactionByRouteID(conn, request, &data.User, &data.Transaction); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return
+	}
+
+	if err := loadPortfolio(conn, &data.User, data.Transaction.PortfolioID, &data.Portfolio); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return
+	}
+
+	template.Render(template.Transaction, writer, data)
+}
+
+// handleLedgerChange loads the ledger for an asset and applies a change to it,
+// then sends the user back to the asset page.
+func handleLedgerChange(
+	conn *database.Conn,
+	writer http.ResponseWriter,
+	request *http.Request,
+	change func(data *AssetAdjustData) error,
+) {
+	data := AssetAdjustData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	if err := change(&data); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondError(writer, err)
+		}
+
+		return
+	}
+
+	if request.Method == http.MethodDelete {
+		writer.WriteHeader(http.StatusNoContent)
+	} else {
+		http.Redirect(writer, request, "/portfolio/"+data.asset.Currency.Ticker, http.StatusFound)
+	}
+}
+
+// HandleCreateTransaction adds a transaction to the ledger of an asset.
+func HandleCreateTransaction(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	handleLedgerChange(conn, writer, request, func(data *AssetAdjustData) error {
+		if err := loadAssetAdjustData(conn, selectedPortfolioID(request), mux.Vars(request)["ticker"], data); err != nil {
+			return err
+		}
+
+		request.ParseForm()
+
+		transaction := model.Transaction{Currency: data.asset.Currency}
+
+		if err := parseTransaction(request.Form, &transaction); err != nil {
+			return err
+		}
+
+		var err error
+
+		if transaction.ID, err = database.RandomID(); err != nil {
+			return err
+		}
+
+		return saveLedgerChange(conn, data, nil, &transaction)
+	})
+}
+
+// HandleUpdateTransaction corrects a transaction in the ledger of an asset.
+func HandleUpdateTransaction(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	handleLedgerChange(conn, writer, request, func(data *AssetAdjustData) error {
+		var previous model.Transaction
+
+		if err := loadTransactionByRouteID(conn, request, &data.User, &previous); err != nil {
+			return err
+		}
+
+		if previous.TransferID != 0 {
+			return errTransferEdit
+		}
+
+		if previous.SwapID != 0 {
+			return errSwapEdit
+		}
+
+		if err := loadAssetAdjustData(conn, previous.PortfolioID, previous.Currency.Ticker, data); err != nil {
+			return err
+		}
+
+		request.ParseForm()
+
+		next := previous
+
+		if err := parseTransaction(request.Form, &next); err != nil {
+			return err
+		}
+
+		return saveLedgerChange(conn, data, &previous, &next)
+	})
+}
+
+// HandleDeleteTransaction removes a mistaken transaction from the ledger of an asset.
+//
+// Deleting a transaction in a transfer or a swap deletes the whole transfer or
+// swap.
+func HandleDeleteTransaction(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	handleLedgerChange(conn, writer, request, func(data *AssetAdjustData) error {
+		var previous model.Transaction
+
+		if err := loadTransactionByRouteID(conn, request, &data.User, &previous); err != nil {
+			return err
+		}
+
+		if previous.TransferID != 0 {
+			return deleteTransfer(conn, &data.User, previous.TransferID)
+		}
+
+		if previous.SwapID != 0 {
+			return deleteSwap(conn, &data.User, previous.SwapID)
+		}
+
+		if err := loadAssetAdjustData(conn, previous.PortfolioID, previous.Currency.Ticker, data); err != nil {
+			return err
+		}
+
+		return saveLedgerChange(conn, data, &previous, nil)
+	})
+}
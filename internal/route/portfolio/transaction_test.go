@@ -0,0 +1,178 @@
+package portfolio
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+func transactionValues(changes map[string]string) url.Values {
+	values := url.Values{
+		"kind":     {"buy"},
+		"time":     {"2024-01-02T03:04:05"},
+		"quantity": {"1.5"},
+		"price":    {"40000"},
+		"note":     {"  First buy  "},
+	}
+
+	for key, value := range changes {
+		values.Set(key, value)
+	}
+
+	return values
+}
+
+func TestParseTransaction(t *testing.T) {
+	testCases := []struct {
+		name    string
+		changes map[string]string
+		err     string
+	}{
+		{name: "Valid buy"},
+		{name: "Minutes only", changes: map[string]string{"time": "2024-01-02T03:04"}},
+		{name: "Free deposit", changes: map[string]string{"kind": "deposit", "price": "0"}},
+		{name: "Invalid kind", changes: map[string]string{"kind": "gift"}, err: "Invalid transaction type"},
+		{name: "Invalid time", changes: map[string]string{"time": "yesterday"}, err: "Invalid time"},
+		{name: "Future time", changes: map[string]string{"time": "2999-01-01T00:00"}, err: "in the future"},
+		{name: "Invalid quantity", changes: map[string]string{"quantity": "lots"}, err: "Invalid quantity"},
+		{name: "Zero quantity", changes: map[string]string{"quantity": "0"}, err: "Quantity must be positive"},
+		{name: "Invalid price", changes: map[string]string{"price": ""}, err: "Invalid price"},
+		{name: "Negative price", changes: map[string]string{"price": "-1"}, err: "Price must not be negative"},
+		{name: "Long note", changes: map[string]string{"note": strings.Repeat("x", 501)}, err: "at most 500"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var transaction model.Transaction
+			err := parseTransaction(transactionValues(testCase.changes), &transaction)
+
+			if testCase.err != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.err) {
+					t.Errorf("got error %v, expected %q", err, testCase.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			expectedTime := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+
+			if testCase.changes["time"] == "" {
+				expectedTime = expectedTime.Add(5 * time.Second)
+			}
+
+			if !transaction.Time.Equal(expectedTime) {
+				t.Errorf("got time %s, expected %s", transaction.Time, expectedTime)
+			}
+
+			if !transaction.Quantity.Equal(decimal.RequireFromString("1.5")) {
+				t.Errorf("got quantity %s, expected 1.5", transaction.Quantity)
+			}
+
+			if transaction.Note != "First buy" {
+				t.Errorf("got note %q, expected %q", transaction.Note, "First buy")
+			}
+		})
+	}
+}
+
+func TestParseFee(t *testing.T) {
+	testCases := []struct {
+		name        string
+		fee         string
+		currency    string
+		expected    string
+		feeInCrypto bool
+		err         string
+	}{
+		{name: "No fee", expected: "0"},
+		{name: "Fiat fee", fee: "2.5", currency: "fiat", expected: "2.5"},
+		{name: "Crypto fee", fee: "0.01", currency: "crypto", expected: "0.01", feeInCrypto: true},
+		{name: "Invalid currency", fee: "1", currency: "gold", err: "Invalid fee currency"},
+		{name: "Invalid fee", fee: "some", err: "Invalid fee"},
+		{name: "Negative fee", fee: "-1", err: "Fee must not be negative"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			values := url.Values{"fee": {testCase.fee}, "fee_currency": {testCase.currency}}
+			fee, feeInCrypto, err := parseFee(values)
+
+			if testCase.err != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.err) {
+					t.Errorf("got error %v, expected %q", err, testCase.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !fee.Equal(decimal.RequireFromString(testCase.expected)) || feeInCrypto != testCase.feeInCrypto {
+				t.Errorf("got %s in crypto %v, expected %s in crypto %v", fee, feeInCrypto, testCase.expected, testCase.feeInCrypto)
+			}
+		})
+	}
+}
+
+func TestSetFee(t *testing.T) {
+	testCases := []struct {
+		name        string
+		kind        string
+		fee         string
+		feeInCrypto bool
+		expected    string
+		err         bool
+	}{
+		{name: "Fiat fee", kind: "buy", fee: "5", expected: ""},
+		{name: "Fiat fee over the quantity", kind: "buy", fee: "50", expected: ""},
+		{name: "Crypto fee", kind: "buy", fee: "0.1", feeInCrypto: true, expected: "BTC"},
+		{name: "Zero crypto fee", kind: "buy", fee: "0", feeInCrypto: true, expected: ""},
+		{name: "Crypto fee of the whole buy", kind: "buy", fee: "1", feeInCrypto: true, err: true},
+		{name: "Crypto fee over a sale", kind: "sell", fee: "2", feeInCrypto: true, expected: "BTC"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			transaction := model.Transaction{
+				Kind:        testCase.kind,
+				Currency:    model.Currency{Ticker: "BTC"},
+				Quantity:    decimal.NewFromInt(1),
+				FeeCurrency: "ETH",
+			}
+			fee := decimal.RequireFromString(testCase.fee)
+			err := setFee(&transaction, fee, testCase.feeInCrypto)
+
+			if testCase.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !transaction.Fee.Equal(fee) || transaction.FeeCurrency != testCase.expected {
+				t.Errorf(
+					"got fee %s in %q, expected %s in %q",
+					transaction.Fee,
+					transaction.FeeCurrency,
+					fee,
+					testCase.expected,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package portfolio
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/shopspring/decimal"
+)
+
+// rateScale is the number of decimal places of exchange rates in the notes
+// of transfers.
+const rateScale = 8
+
+// transferNote describes one side of a transfer, with the exchange rate used
+// when the portfolios have different currencies.
+func transferNote(text string, from *model.Currency, to *model.Currency, rate decimal.Decimal) string {
+	if from.Ticker == to.Ticker {
+		return text
+	}
+
+	return text + " at 1 " + from.Ticker + " = " + rate.Round(rateScale).String() + " " + to.Ticker
+}
+
+// transferCash moves cash from one portfolio to another, converting it to
+// the currency of the portfolio it goes to at the latest exchange rate.
+//
+// Both sides are checked before either is saved, and share a transfer ID so
+// they can only be deleted together.
+func transferCash(
+	conn *database.Conn,
+	user *model.User,
+	fromID int64,
+	toID int64,
+	amount decimal.Decimal,
+) error {
+	var from, to model.Portfolio
+
+	if err := loadPortfolio(conn, user, fromID, &from); err != nil {
+		if err == database.ErrNoRows {
+			return util.ValidationError("Portfolio not configured")
+		}
+
+		return err
+	}
+
+	if err := loadPortfolio(conn, user, toID, &to); err != nil {
+		return err
+	}
+
+	rate, err := loadExchangeRate(conn, &from.Currency, &to.Currency)
+
+	if err != nil {
+		return err
+	}
+
+	transferID, err := database.RandomID()
+
+	if err != nil {
+		return err
+	}
+
+	withdrawal, err := newCashFlow(
+		&from,
+		ledger.Withdrawal,
+		amount,
+		transferNote("Transferred to "+to.Name, &from.Currency, &to.Currency, rate),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	deposit, err := newCashFlow(
+		&to,
+		ledger.Deposit,
+		amount.Mul(rate),
+		transferNote("Transferred from "+from.Name, &from.Currency, &to.Currency, rate),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	withdrawal.TransferID = transferID
+	deposit.TransferID = transferID
+
+	if err := applyCashFlowChange(conn, user, &from, nil, &withdrawal); err != nil {
+		return err
+	}
+
+	if err := applyCashFlowChange(conn, user, &to, nil, &deposit); err != nil {
+		return err
+	}
+
+	if err := writeCashFlowChange(conn, user, &from, nil, &withdrawal); err != nil {
+		return err
+	}
+
+	return writeCashFlowChange(conn, user, &to, nil, &deposit)
+}
+
+// transferAsset moves an amount of an asset from one portfolio to another.
+//
+// The lots the asset leaves with are chosen by the cost basis method of the
+// portfolio it leaves. Each lot arrives as a deposit with the time it was
+// acquired and its cost converted to the currency of the portfolio it goes
+// to, so holding periods are kept. The transfer is saved as a withdrawal and
+// these deposits, all with one transfer ID, so no gain is realized and they
+// can only be deleted together.
+func transferAsset(
+	conn *database.Conn,
+	fromID int64,
+	toID int64,
+	ticker string,
+	amount decimal.Decimal,
+	from *AssetAdjustData,
+) error {
+	to := AssetAdjustData{}
+	to.User = from.User
+
+	if err := loadAssetAdjustData(conn, fromID, ticker, from); err != nil {
+		return err
+	}
+
+	if err := loadAssetAdjustData(conn, toID, ticker, &to); err != nil {
+		return err
+	}
+
+	if amount.GreaterThan(from.asset.Amount) {
+		return util.ValidationError("You can't remove more crypto than you have")
+	}
+
+	rate, err := loadExchangeRate(conn, &from.Portfolio.Currency, &to.Portfolio.Currency)
+
+	if err != nil {
+		return err
+	}
+
+	transferID, err := database.RandomID()
+
+	if err != nil {
+		return err
+	}
+
+	withdrawal := model.Transaction{
+		Currency:   from.asset.Currency,
+		Kind:       ledger.Withdrawal,
+		Time:       time.Now().UTC(),
+		Quantity:   amount,
+		Price:      decimal.Zero,
+		Fee:        decimal.Zero,
+		Note:       transferNote("Transferred to "+to.Portfolio.Name, &from.Portfolio.Currency, &to.Portfolio.Currency, rate),
+		TransferID: transferID,
+	}
+
+	if withdrawal.ID, err = database.RandomID(); err != nil {
+		return err
+	}
+
+	lotList := from.lotList
+	withdrawalList := []model.Transaction{withdrawal}
+
+	// Replay the ledger with the withdrawal to find the lots it takes.
+	if err := applyLedgerChanges(from, nil, withdrawalList); err != nil {
+		return err
+	}
+
+	takenList := takenLots(lotList, from.lotList)
+	depositList := make([]model.Transaction, 0, len(takenList))
+	cost := decimal.Zero
+
+	for _, lot := range takenList {
+		deposit := model.Transaction{
+			Currency:   to.asset.Currency,
+			Kind:       ledger.Deposit,
+			Time:       lot.Time,
+			Quantity:   lot.Amount,
+			Price:      lot.Cost.Mul(rate).DivRound(lot.Amount, priceScale),
+			Fee:        decimal.Zero,
+			Note:       transferNote("Transferred from "+from.Portfolio.Name, &from.Portfolio.Currency, &to.Portfolio.Currency, rate),
+			TransferID: transferID,
+		}
+
+		if deposit.ID, err = database.RandomID(); err != nil {
+			return err
+		}
+
+		depositList = append(depositList, deposit)
+		cost = cost.Add(lot.Cost)
+	}
+
+	withdrawalList[0].Price = cost.DivRound(amount, priceScale)
+
+	if err := applyLedgerChanges(&to, nil, depositList); err != nil {
+		return err
+	}
+
+	if err := writeLedgerChanges(conn, from, nil, withdrawalList); err != nil {
+		return err
+	}
+
+	return writeLedgerChanges(conn, &to, nil, depositList)
+}
+
+// takenLots returns the part of each lot taken between two lists of lots
+// held, in the order of the first list.
+func takenLots(before []ledger.Lot, after []ledger.Lot) []ledger.Lot {
+	var takenList []ledger.Lot
+
+	for _, lot := range before {
+		taken := lot
+
+		for _, left := range after {
+			if left.ID == lot.ID {
+				taken.Amount = lot.Amount.Sub(left.Amount)
+				taken.Cost = lot.Cost.Sub(left.Cost)
+
+				break
+			}
+		}
+
+		if taken.Amount.IsPositive() {
+			takenList = append(takenList, taken)
+		}
+	}
+
+	return takenList
+}
+
+// deleteTransfer deletes every transaction and cash flow in a transfer
+// between portfolios.
+//
+// Every portfolio the transfer touched is checked before anything is deleted,
+// so removing a deposit that has since been sold or spent is rejected.
+func deleteTransfer(conn *database.Conn, user *model.User, transferID int64) error {
+	var transactionList []model.Transaction
+	var flowList []model.CashFlow
+
+	if err := model.LoadList(
+		conn,
+		&transactionList,
+		4,
+		scanTransaction,
+		transactionQuery+`where is_deleted = 0 and transfer_id = ?`,
+		user.ID,
+		transferID,
+	); err != nil {
+		return err
+	}
+
+	if err := model.LoadList(
+		conn,
+		&flowList,
+		2,
+		scanCashFlow,
+		cashFlowQuery+`where is_deleted = 0 and transfer_id = ?`,
+		user.ID,
+		transferID,
+	); err != nil {
+		return err
+	}
+
+	if len(transactionList) == 0 && len(flowList) == 0 {
+		return database.ErrNoRows
+	}
+
+	var dataList []*AssetAdjustData
+	var legsList [][]model.Transaction
+
+	for _, transaction := range transactionList {
+		i := slices.IndexFunc(dataList, func(data *AssetAdjustData) bool {
+			return data.Portfolio.ID == transaction.PortfolioID
+		})
+
+		if i < 0 {
+			data := &AssetAdjustData{}
+			data.User = *user
+
+			if err := loadAssetAdjustData(conn, transaction.PortfolioID, transaction.Currency.Ticker, data); err != nil {
+				return err
+			}
+
+			dataList = append(dataList, data)
+			legsList = append(legsList, nil)
+			i = len(dataList) - 1
+		}
+
+		legsList[i] = append(legsList[i], transaction)
+	}
+
+	portfolioList := make([]model.Portfolio, len(flowList))
+
+	for i := range dataList {
+		if err := applyLedgerChanges(dataList[i], legsList[i], nil); err != nil {
+			return err
+		}
+	}
+
+	for i := range flowList {
+		if err := loadPortfolio(conn, user, flowList[i].PortfolioID, &portfolioList[i]); err != nil {
+			return err
+		}
+
+		if err := applyCashFlowChange(conn, user, &portfolioList[i], &flowList[i], nil); err != nil {
+			return err
+		}
+	}
+
+	for i := range dataList {
+		if err := writeLedgerChanges(conn, dataList[i], legsList[i], nil); err != nil {
+			return err
+		}
+	}
+
+	for i := range flowList {
+		if err := writeCashFlowChange(conn, user, &portfolioList[i], &flowList[i], nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// transfer moves cash or an asset from one portfolio to another.
+//
+// The `asset` value is the ticker of the asset to move, or empty for cash.
+func transfer(conn *database.Conn, portfolioID int64, values url.Values, data *AssetAdjustData) error {
+	toID, err := strconv.ParseInt(values.Get("to"), 10, 64)
+
+	if err != nil {
+		return util.ValidationError("Invalid portfolio")
+	}
+
+	if toID == portfolioID {
+		return util.ValidationError("You can't transfer to the same portfolio")
+	}
+
+	amount, err := parseAmount(values, "amount")
+
+	if err != nil {
+		return err
+	}
+
+	if ticker := values.Get("asset"); ticker != "" {
+		return transferAsset(conn, portfolioID, toID, ticker, amount, data)
+	}
+
+	return transferCash(conn, &data.User, portfolioID, toID, amount)
+}
+
+// HandleTransfer moves cash or an asset from the selected portfolio to
+// another portfolio of the user.
+func HandleTransfer(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := AssetAdjustData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	request.ParseForm()
+
+	if err := transfer(conn, selectedPortfolioID(request), request.Form, &data); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondError(writer, err)
+		}
+
+		return
+	}
+
+	http.Redirect(writer, request, "/portfolio", http.StatusFound)
+}
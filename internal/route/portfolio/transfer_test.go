@@ -0,0 +1,82 @@
+package portfolio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/shopspring/decimal"
+)
+
+func newLot(id int64, amount string, cost string) ledger.Lot {
+	return ledger.Lot{
+		ID:     id,
+		Time:   time.Date(2024, time.January, int(id), 0, 0, 0, 0, time.UTC),
+		Amount: decimal.RequireFromString(amount),
+		Cost:   decimal.RequireFromString(cost),
+	}
+}
+
+func TestTakenLots(t *testing.T) {
+	testCases := []struct {
+		name     string
+		before   []ledger.Lot
+		after    []ledger.Lot
+		expected []ledger.Lot
+	}{
+		{
+			name:     "Nothing taken",
+			before:   []ledger.Lot{newLot(1, "1", "100"), newLot(2, "2", "400")},
+			after:    []ledger.Lot{newLot(1, "1", "100"), newLot(2, "2", "400")},
+			expected: nil,
+		},
+		{
+			name:     "Part of a lot taken",
+			before:   []ledger.Lot{newLot(1, "1", "100"), newLot(2, "2", "400")},
+			after:    []ledger.Lot{newLot(1, "0.25", "25"), newLot(2, "2", "400")},
+			expected: []ledger.Lot{newLot(1, "0.75", "75")},
+		},
+		{
+			name:     "A whole lot and part of another taken",
+			before:   []ledger.Lot{newLot(1, "1", "100"), newLot(2, "2", "400")},
+			after:    []ledger.Lot{newLot(2, "1.5", "300")},
+			expected: []ledger.Lot{newLot(1, "1", "100"), newLot(2, "0.5", "100")},
+		},
+		{
+			name:     "Lots are kept in the order they were held",
+			before:   []ledger.Lot{newLot(1, "1", "100"), newLot(2, "2", "400"), newLot(3, "1", "50")},
+			after:    []ledger.Lot{newLot(1, "1", "100")},
+			expected: []ledger.Lot{newLot(2, "2", "400"), newLot(3, "1", "50")},
+		},
+		{
+			name:     "Everything taken",
+			before:   []ledger.Lot{newLot(1, "1", "100")},
+			expected: []ledger.Lot{newLot(1, "1", "100")},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			takenList := takenLots(testCase.before, testCase.after)
+
+			if len(takenList) != len(testCase.expected) {
+				t.Fatalf("got %d lots, expected %d", len(takenList), len(testCase.expected))
+			}
+
+			for i, expected := range testCase.expected {
+				taken := takenList[i]
+
+				if taken.ID != expected.ID || !taken.Time.Equal(expected.Time) {
+					t.Errorf("lot %d: got ID %d at %s, expected ID %d at %s", i, taken.ID, taken.Time, expected.ID, expected.Time)
+				}
+
+				if !taken.Amount.Equal(expected.Amount) || !taken.Cost.Equal(expected.Cost) {
+					t.Errorf(
+						"lot %d: got %s costing %s, expected %s costing %s",
+						i, taken.Amount, taken.Cost, expected.Amount, expected.Cost,
+					)
+				}
+			}
+		})
+	}
+}
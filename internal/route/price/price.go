@@ -0,0 +1,234 @@
+// Package price serves stored prices through the JSON API.
+package price
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/query"
+	"github.com/dense-analysis/pricewarp/internal/route/token"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultHistoryRange    = 24 * time.Hour
+	defaultHistoryInterval = time.Hour
+	minHistoryInterval     = time.Minute
+	// maxHistoryPoints limits how many prices one history request can return.
+	maxHistoryPoints = 5000
+)
+
+// APIPrice is a price at a point in time in the JSON API.
+type APIPrice struct {
+	Time  time.Time       `json:"time"`
+	Value decimal.Decimal `json:"value"`
+}
+
+// APILatestPrice is the response for the latest price of a pair.
+//
+// Via is set when there is no price for the pair, and the price was
+// converted through another currency.
+type APILatestPrice struct {
+	From  util.APICurrency `json:"from"`
+	To    util.APICurrency `json:"to"`
+	Via   string           `json:"via,omitempty"`
+	Time  time.Time        `json:"time"`
+	Value decimal.Decimal  `json:"value"`
+}
+
+// APIPriceHistory is the response for the price history of a pair.
+//
+// Each price is the closing price of an interval, with the time of the start
+// of the interval.
+type APIPriceHistory struct {
+	From     util.APICurrency `json:"from"`
+	To       util.APICurrency `json:"to"`
+	Via      string           `json:"via,omitempty"`
+	Start    time.Time        `json:"start"`
+	End      time.Time        `json:"end"`
+	Interval string           `json:"interval"`
+	Prices   []APIPrice       `json:"prices"`
+}
+
+// loadCurrency loads a currency that prices can be requested for.
+func loadCurrency(conn *database.Conn, ticker string, currency *model.Currency) error {
+	if ticker == "" {
+		return util.ValidationError("from and to currencies are required")
+	}
+
+	for _, toCurrency := range query.GetToCurrencyList() {
+		if toCurrency.Ticker == ticker {
+			*currency = toCurrency
+
+			return nil
+		}
+	}
+
+	if err := query.LoadCurrencyByTicker(conn, currency, ticker); err != nil {
+		if err == database.ErrNoRows {
+			return util.ValidationError("Unknown currency: " + ticker)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func loadPair(conn *database.Conn, values url.Values, from *model.Currency, to *model.Currency) error {
+	if err := loadCurrency(conn, strings.ToUpper(values.Get("from")), from); err != nil {
+		return err
+	}
+
+	return loadCurrency(conn, strings.ToUpper(values.Get("to")), to)
+}
+
+// parseTime parses a time given as RFC 3339 or as a UTC date.
+func parseTime(value string, name string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+
+	if parsed, err := time.Parse(time.DateOnly, value); err == nil {
+		return parsed, nil
+	}
+
+	return time.Time{}, util.ValidationError("Invalid " + name + " time, use RFC 3339 or YYYY-MM-DD")
+}
+
+// parseHistoryRange reads the start, end and interval for a price history.
+func parseHistoryRange(values url.Values) (time.Time, time.Time, time.Duration, error) {
+	var err error
+	end := time.Now().UTC()
+	interval := defaultHistoryInterval
+
+	if value := values.Get("end"); value != "" {
+		if end, err = parseTime(value, "end"); err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+	}
+
+	start := end.Add(-defaultHistoryRange)
+
+	if value := values.Get("start"); value != "" {
+		if start, err = parseTime(value, "start"); err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, 0, util.ValidationError("start must be before end")
+	}
+
+	if value := values.Get("interval"); value != "" {
+		if interval, err = time.ParseDuration(value); err != nil {
+			return time.Time{}, time.Time{}, 0, util.ValidationError("Invalid interval, use a duration such as 15m, 1h or 24h")
+		}
+	}
+
+	if interval < minHistoryInterval {
+		return time.Time{}, time.Time{}, 0, util.ValidationError("interval must be at least " + minHistoryInterval.String())
+	}
+
+	if end.Sub(start)/interval > maxHistoryPoints {
+		return time.Time{}, time.Time{}, 0, util.ValidationError("Too many prices requested, use a larger interval")
+	}
+
+	return start, end, interval, nil
+}
+
+// HandleAPILatestPrice gets the latest price for a pair of currencies.
+func HandleAPILatestPrice(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var from, to model.Currency
+	var price model.Price
+
+	if !token.LoadAPIUser(conn, writer, request, &user) {
+		return
+	}
+
+	values := request.URL.Query()
+
+	if err := loadPair(conn, values, &from, &to); err != nil {
+		util.RespondJSONError(writer, err)
+
+		return
+	}
+
+	via, err := query.LoadConvertedLatestPrice(conn, &price, from.Ticker, to.Ticker)
+
+	if err != nil {
+		if err == database.ErrNoRows {
+			util.RespondJSONStatus(writer, http.StatusNotFound, "No recent price for "+from.Ticker+"/"+to.Ticker)
+		} else {
+			util.RespondJSONError(writer, err)
+		}
+
+		return
+	}
+
+	util.RespondJSON(writer, http.StatusOK, APILatestPrice{
+		From:  util.NewAPICurrency(&from),
+		To:    util.NewAPICurrency(&to),
+		Via:   via,
+		Time:  price.Time,
+		Value: price.Value,
+	})
+}
+
+// HandleAPIPriceHistory gets the closing prices for a pair of currencies over
+// a range of time.
+func HandleAPIPriceHistory(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var from, to model.Currency
+	var priceList []model.Price
+
+	if !token.LoadAPIUser(conn, writer, request, &user) {
+		return
+	}
+
+	values := request.URL.Query()
+
+	if err := loadPair(conn, values, &from, &to); err != nil {
+		util.RespondJSONError(writer, err)
+
+		return
+	}
+
+	start, end, interval, err := parseHistoryRange(values)
+
+	if err != nil {
+		util.RespondJSONError(writer, err)
+
+		return
+	}
+
+	via, err := query.LoadConvertedClosingPrices(conn, &priceList, from.Ticker, to.Ticker, start, end, interval)
+
+	if err != nil {
+		util.RespondJSONError(writer, err)
+
+		return
+	}
+
+	response := APIPriceHistory{
+		From:     util.NewAPICurrency(&from),
+		To:       util.NewAPICurrency(&to),
+		Via:      via,
+		Start:    start,
+		End:      end,
+		Interval: interval.String(),
+		Prices:   make([]APIPrice, len(priceList)),
+	}
+
+	for i, price := range priceList {
+		response.Prices[i] = APIPrice{Time: price.Time, Value: price.Value}
+	}
+
+	util.RespondJSON(writer, http.StatusOK, response)
+}
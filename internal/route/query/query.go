@@ -2,6 +2,7 @@ package query
 
 import (
 	"slices"
+	"time"
 
 	"github.com/dense-analysis/pricewarp/internal/database"
 	"github.com/dense-analysis/pricewarp/internal/model"
@@ -42,3 +43,184 @@ var toCurrencies = []model.Currency{
 func GetToCurrencyList() []model.Currency {
 	return slices.Clone(toCurrencies)
 }
+
+var priceQuery = `
+select
+	from_currency_ticker,
+	from_currency_name,
+	to_currency_ticker,
+	to_currency_name,
+	time,
+	value
+from crypto_currency_prices
+`
+
+func scanPrice(row database.Row, price *model.Price) error {
+	return row.Scan(
+		&price.From.Ticker,
+		&price.From.Name,
+		&price.To.Ticker,
+		&price.To.Name,
+		&price.Time,
+		&price.Value,
+	)
+}
+
+// LoadLatestPrice loads the most recent price for a pair from the last month.
+func LoadLatestPrice(conn database.Queryable, price *model.Price, fromTicker string, toTicker string) error {
+	row := conn.QueryRow(
+		priceQuery+`
+		PREWHERE yearmonth >= toYear(addMonths(now(), -1)) * 100 + toMonth(addMonths(now(), -1))
+		WHERE from_currency_ticker = ?
+		AND to_currency_ticker = ?
+		ORDER BY time DESC
+		LIMIT 1
+		`,
+		fromTicker,
+		toTicker,
+	)
+
+	return scanPrice(row, price)
+}
+
+// LoadClosingPrices loads the last price in each interval for a pair between two times, oldest first.
+//
+// The Time of each price is the start of its interval.
+func LoadClosingPrices(
+	conn database.Queryable,
+	priceList *[]model.Price,
+	fromTicker string,
+	toTicker string,
+	start time.Time,
+	end time.Time,
+	interval time.Duration,
+) error {
+	return model.LoadList(
+		conn,
+		priceList,
+		1000,
+		scanPrice,
+		`
+		SELECT
+			from_currency_ticker,
+			argMax(from_currency_name, time) AS from_currency_name,
+			to_currency_ticker,
+			argMax(to_currency_name, time) AS to_currency_name,
+			toStartOfInterval(time, toIntervalSecond(?)) AS interval_start,
+			argMax(value, time) AS value
+		FROM crypto_currency_prices
+		-- Skip partitions from before the start of the range.
+		PREWHERE yearmonth >= toYear(?) * 100 + toMonth(?)
+		WHERE from_currency_ticker = ?
+		AND to_currency_ticker = ?
+		AND time >= ?
+		AND time <= ?
+		GROUP BY from_currency_ticker, to_currency_ticker, interval_start
+		ORDER BY interval_start
+		`,
+		int64(interval/time.Second),
+		start,
+		start,
+		fromTicker,
+		toTicker,
+		start,
+		end,
+	)
+}
+
+// viaTicker is the currency used to convert between pairs without prices.
+const viaTicker = "BTC"
+
+// canConvert returns true if a pair can be converted through viaTicker.
+func canConvert(fromTicker string, toTicker string) bool {
+	return fromTicker != viaTicker && toTicker != viaTicker
+}
+
+// LoadConvertedLatestPrice loads the latest price for a pair, converting
+// through BTC if there is no recent price for the pair itself.
+//
+// The returned string is the ticker the price was converted through, if any.
+func LoadConvertedLatestPrice(
+	conn database.Queryable,
+	price *model.Price,
+	fromTicker string,
+	toTicker string,
+) (string, error) {
+	err := LoadLatestPrice(conn, price, fromTicker, toTicker)
+
+	if err != database.ErrNoRows || !canConvert(fromTicker, toTicker) {
+		return "", err
+	}
+
+	var viaPrice model.Price
+
+	if err := LoadLatestPrice(conn, price, fromTicker, viaTicker); err != nil {
+		return "", err
+	}
+
+	if err := LoadLatestPrice(conn, &viaPrice, viaTicker, toTicker); err != nil {
+		return "", err
+	}
+
+	price.Value = price.Value.Mul(viaPrice.Value)
+	price.To = viaPrice.To
+
+	// The converted price is only as recent as the oldest price used.
+	if viaPrice.Time.Before(price.Time) {
+		price.Time = viaPrice.Time
+	}
+
+	return viaTicker, nil
+}
+
+// LoadConvertedClosingPrices loads closing prices for a pair, converting
+// through BTC if there are no prices for the pair itself.
+//
+// Converted prices are only returned for intervals with prices for both
+// halves of the conversion. The returned string is the ticker prices were
+// converted through, if any.
+func LoadConvertedClosingPrices(
+	conn database.Queryable,
+	priceList *[]model.Price,
+	fromTicker string,
+	toTicker string,
+	start time.Time,
+	end time.Time,
+	interval time.Duration,
+) (string, error) {
+	if err := LoadClosingPrices(conn, priceList, fromTicker, toTicker, start, end, interval); err != nil {
+		return "", err
+	}
+
+	if len(*priceList) > 0 || !canConvert(fromTicker, toTicker) {
+		return "", nil
+	}
+
+	var fromList, viaList []model.Price
+
+	if err := LoadClosingPrices(conn, &fromList, fromTicker, viaTicker, start, end, interval); err != nil {
+		return "", err
+	}
+
+	if err := LoadClosingPrices(conn, &viaList, viaTicker, toTicker, start, end, interval); err != nil {
+		return "", err
+	}
+
+	viaMap := make(map[int64]model.Price, len(viaList))
+
+	for _, price := range viaList {
+		viaMap[price.Time.UnixNano()] = price
+	}
+
+	*priceList = make([]model.Price, 0, len(fromList))
+
+	for _, price := range fromList {
+		if viaPrice, ok := viaMap[price.Time.UnixNano()]; ok {
+			price.Value = price.Value.Mul(viaPrice.Value)
+			price.To = viaPrice.To
+			*priceList = append(*priceList, price)
+		}
+	}
+
+	return viaTicker, nil
+}
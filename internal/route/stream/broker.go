@@ -0,0 +1,118 @@
+// Package stream pushes live price and alert updates to browsers with
+// server-sent events.
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is a message sent to subscribers.
+//
+// Events for a pair are sent to every subscriber interested in the pair, and
+// events with a UserID are only sent to that user.
+type Event struct {
+	Name   string
+	Pair   string
+	UserID int64
+	Data   any
+}
+
+type subscriber struct {
+	userID int64
+	// pairSet contains "FROM/TO" for every pair the user is interested in.
+	pairSet map[string]bool
+	events  chan Event
+}
+
+func (sub *subscriber) wants(event *Event) bool {
+	if event.UserID != 0 {
+		return event.UserID == sub.userID
+	}
+
+	return sub.pairSet[event.Pair]
+}
+
+// Broker shares one database poller between every connected client.
+//
+// The poller only runs while clients are connected, so an idle server
+// doesn't query the database.
+type Broker struct {
+	mutex       sync.Mutex
+	subscribers map[*subscriber]struct{}
+	polling     bool
+	interval    time.Duration
+	// closed is closed when the server shuts down, to end every stream.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBroker creates a Broker that polls the database on an interval.
+func NewBroker(interval time.Duration) *Broker {
+	return &Broker{
+		subscribers: make(map[*subscriber]struct{}),
+		interval:    interval,
+		closed:      make(chan struct{}),
+	}
+}
+
+// Close ends every open stream, so the server can shut down.
+//
+// Open streams don't end when http.Server.Shutdown is called, so Close
+// should be registered with http.Server.RegisterOnShutdown.
+func (broker *Broker) Close() {
+	broker.closeOnce.Do(func() {
+		close(broker.closed)
+	})
+}
+
+func (broker *Broker) subscribe(sub *subscriber) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.subscribers[sub] = struct{}{}
+
+	if !broker.polling {
+		broker.polling = true
+
+		go broker.poll()
+	}
+}
+
+func (broker *Broker) unsubscribe(sub *subscriber) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	delete(broker.subscribers, sub)
+}
+
+// hasSubscribers returns true if any clients are connected, or stops polling
+// if there aren't any.
+func (broker *Broker) hasSubscribers() bool {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	if len(broker.subscribers) == 0 {
+		broker.polling = false
+	}
+
+	return broker.polling
+}
+
+// publish sends an event to every subscriber that wants it.
+//
+// Events are dropped for clients that are too slow to keep up, rather than
+// holding up every other client.
+func (broker *Broker) publish(event Event) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for sub := range broker.subscribers {
+		if sub.wants(&event) {
+			select {
+			case sub.events <- event:
+			default:
+			}
+		}
+	}
+}
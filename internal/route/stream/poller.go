@@ -0,0 +1,177 @@
+package stream
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/shopspring/decimal"
+)
+
+// PriceEvent is the data of a "price" event, sent for new prices.
+type PriceEvent struct {
+	From  string          `json:"from"`
+	To    string          `json:"to"`
+	Time  time.Time       `json:"time"`
+	Value decimal.Decimal `json:"value"`
+}
+
+// AlertEvent is the data of an "alert" event, sent when an alert fires.
+type AlertEvent struct {
+	ID        string          `json:"id"`
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Direction string          `json:"direction"`
+	Value     decimal.Decimal `json:"value"`
+}
+
+// pollCursor holds the newest price and alert change seen by the poller.
+type pollCursor struct {
+	priceTime time.Time
+	alertTime time.Time
+}
+
+func loadCursor(conn *database.Conn, cursor *pollCursor) error {
+	// Start from the database clock, so events are only sent for changes
+	// made after clients connect.
+	if err := conn.QueryRow("select now64(9)").Scan(&cursor.priceTime); err != nil {
+		return err
+	}
+
+	cursor.alertTime = cursor.priceTime
+
+	return nil
+}
+
+func (broker *Broker) poll() {
+	var cursor pollCursor
+
+	ticker := time.NewTicker(broker.interval)
+	defer ticker.Stop()
+
+	for broker.hasSubscribers() {
+		if err := broker.pollOnce(&cursor); err != nil {
+			log.Printf("stream poll error: %+v\n", err)
+		}
+
+		<-ticker.C
+	}
+}
+
+func (broker *Broker) pollOnce(cursor *pollCursor) error {
+	conn, err := database.Connect()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if cursor.priceTime.IsZero() {
+		return loadCursor(conn, cursor)
+	}
+
+	if err := broker.publishPrices(conn, cursor); err != nil {
+		return err
+	}
+
+	return broker.publishAlerts(conn, cursor)
+}
+
+// publishPrices sends the newest price of every pair with new prices.
+func (broker *Broker) publishPrices(conn *database.Conn, cursor *pollCursor) error {
+	rows, err := conn.Query(
+		`SELECT
+			from_currency_ticker,
+			to_currency_ticker,
+			max(time) AS latest_time,
+			argMax(value, time)
+		FROM crypto_currency_prices
+		PREWHERE yearmonth >= toYear(?) * 100 + toMonth(?)
+		WHERE time > ?
+		GROUP BY from_currency_ticker, to_currency_ticker`,
+		cursor.priceTime,
+		cursor.priceTime,
+		cursor.priceTime,
+	)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var price PriceEvent
+
+		if err := rows.Scan(&price.From, &price.To, &price.Time, &price.Value); err != nil {
+			return err
+		}
+
+		if price.Time.After(cursor.priceTime) {
+			cursor.priceTime = price.Time
+		}
+
+		broker.publish(Event{Name: "price", Pair: price.From + "/" + price.To, Data: price})
+	}
+
+	return rows.Err()
+}
+
+// publishAlerts tells users about alerts bin/notify has sent since the last poll.
+func (broker *Broker) publishAlerts(conn *database.Conn, cursor *pollCursor) error {
+	rows, err := conn.Query(
+		`SELECT
+			alert_id,
+			user_id,
+			from_currency_ticker,
+			to_currency_ticker,
+			above,
+			value,
+			updated_at
+		FROM crypto_alert
+		WHERE updated_at > ? AND sent = 1 AND is_deleted = 0`,
+		cursor.alertTime,
+	)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var alertID, userID int64
+		var above uint8
+		var updatedAt time.Time
+		var alert AlertEvent
+
+		if err := rows.Scan(
+			&alertID,
+			&userID,
+			&alert.From,
+			&alert.To,
+			&above,
+			&alert.Value,
+			&updatedAt,
+		); err != nil {
+			return err
+		}
+
+		if updatedAt.After(cursor.alertTime) {
+			cursor.alertTime = updatedAt
+		}
+
+		alert.ID = strconv.FormatInt(alertID, 10)
+		alert.Direction = "below"
+
+		if above == 1 {
+			alert.Direction = "above"
+		}
+
+		broker.publish(Event{Name: "alert", UserID: userID, Data: alert})
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,180 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/token"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+)
+
+// keepAliveInterval is how often a comment is sent to keep idle connections
+// from being closed by proxies.
+const keepAliveInterval = 30 * time.Second
+
+// eventBufferSize is how many events can wait for a slow client.
+const eventBufferSize = 32
+
+// loadPairSet loads "FROM/TO" for the pairs of a user's alerts, and for their
+// assets priced in the currency of each portfolio they are in.
+func loadPairSet(conn *database.Conn, userID int64) (map[string]bool, error) {
+	pairSet := make(map[string]bool)
+
+	rows, err := conn.Query(
+		`select distinct from_currency_ticker, to_currency_ticker
+		from (
+			select *
+			from crypto_alert
+			where user_id = ?
+			order by updated_at desc
+			limit 1 by alert_id
+		)
+		where is_deleted = 0
+		union distinct
+		select asset.currency_ticker, portfolio.currency_ticker
+		from (
+			select portfolio_id, currency_ticker
+			from crypto_asset
+			where user_id = ?
+			order by updated_at desc
+			limit 1 by portfolio_id, currency_ticker
+		) as asset
+		inner join (
+			select portfolio_id, currency_ticker
+			from crypto_portfolio
+			where user_id = ?
+			order by updated_at desc
+			limit 1 by portfolio_id
+		) as portfolio
+		on asset.portfolio_id = portfolio.portfolio_id`,
+		userID,
+		userID,
+		userID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var from, to string
+
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+
+		pairSet[from+"/"+to] = true
+	}
+
+	return pairSet, rows.Err()
+}
+
+// loadSubscriber loads the user for a request and the pairs they want.
+//
+// The connection is closed before streaming starts, so open streams don't
+// hold database connections.
+func loadSubscriber(request *http.Request, sub *subscriber) (bool, error) {
+	conn, err := database.Connect()
+
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var user model.User
+
+	found, err := token.LoadUser(conn, request, &user)
+
+	if err != nil || !found {
+		return false, err
+	}
+
+	sub.userID = user.ID
+	sub.pairSet, err = loadPairSet(conn, user.ID)
+
+	return err == nil, err
+}
+
+func writeEvent(writer http.ResponseWriter, event *Event) error {
+	data, err := json.Marshal(event.Data)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event.Name, data)
+
+	return err
+}
+
+// HandleStream sends "price" events for the pairs a user is interested in,
+// and "alert" events when their alerts are sent, until the client leaves or
+// the broker is closed.
+func (broker *Broker) HandleStream(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+
+	if !ok {
+		util.RespondInternalServerError(writer, fmt.Errorf("streaming is not supported"))
+
+		return
+	}
+
+	sub := &subscriber{events: make(chan Event, eventBufferSize)}
+
+	if found, err := loadSubscriber(request, sub); err != nil {
+		if err == token.ErrReadOnly {
+			util.RespondForbidden(writer)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return
+	} else if !found {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering events.
+	writer.Header().Set("X-Accel-Buffering", "no")
+
+	broker.subscribe(sub)
+	defer broker.unsubscribe(sub)
+
+	fmt.Fprintf(writer, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-broker.closed:
+			return
+		case event := <-sub.events:
+			if err := writeEvent(writer, &event); err != nil {
+				log.Printf("stream write error: %+v\n", err)
+
+				return
+			}
+
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprintf(writer, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,382 @@
+// Package token manages personal API tokens, and authenticates API requests
+// with them.
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/database"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/dense-analysis/pricewarp/internal/route/util"
+	"github.com/dense-analysis/pricewarp/internal/session"
+	"github.com/dense-analysis/pricewarp/internal/template"
+	"github.com/gorilla/mux"
+)
+
+// Scopes a token can be given.
+const (
+	ScopeRead      = "read"
+	ScopeReadWrite = "read-write"
+)
+
+// tokenPrefix starts every token, so leaked tokens are easy to recognise.
+const tokenPrefix = "pw_"
+
+// maxTokenNameLength limits the length of token names.
+const maxTokenNameLength = 100
+
+// lastUsedInterval is how often the last used time of a token is saved, so
+// a new row isn't written for every request.
+const lastUsedInterval = time.Minute
+
+// ErrReadOnly is returned when a read-only token is used to change data.
+var ErrReadOnly = errors.New("token is read-only")
+
+// tokenQuery loads tokens with the time they were last used.
+//
+// Uses are stored separately, so recording a use can never bring back a token
+// which was revoked at the same time.
+var tokenQuery = `
+select
+	token_id,
+	user_id,
+	username,
+	name,
+	scope,
+	token_hash,
+	created_at,
+	last_used_at,
+	is_deleted
+from (
+	select
+		tokens.token_id as token_id,
+		tokens.user_id as user_id,
+		tokens.username as username,
+		tokens.name as name,
+		tokens.scope as scope,
+		tokens.token_hash as token_hash,
+		tokens.created_at as created_at,
+		uses.used_at as last_used_at,
+		tokens.is_deleted as is_deleted
+	from (
+		select *
+		from crypto_api_token
+		order by updated_at desc
+		limit 1 by token_id
+	) as tokens
+	left join (
+		select token_id, max(toNullable(used_at)) as used_at
+		from crypto_api_token_use
+		group by token_id
+	) as uses
+	on uses.token_id = tokens.token_id
+)
+`
+
+func scanToken(row database.Row, token *model.APIToken) error {
+	var isDeleted uint8
+
+	if err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Username,
+		&token.Name,
+		&token.Scope,
+		&token.Hash,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+		&isDeleted,
+	); err != nil {
+		return err
+	}
+
+	if isDeleted == 1 {
+		return database.ErrNoRows
+	}
+
+	return nil
+}
+
+func loadTokenList(conn *database.Conn, userID int64, tokenList *[]model.APIToken) error {
+	return model.LoadList(
+		conn,
+		tokenList,
+		1,
+		scanToken,
+		tokenQuery+"where user_id = ? and is_deleted = 0 order by created_at",
+		userID,
+	)
+}
+
+var tokenInsertQuery = `
+insert into crypto_api_token
+	(token_id, user_id, username, name, scope, token_hash,
+	 created_at, updated_at, is_deleted)
+values (?, ?, ?, ?, ?, ?, ?, now64(9), ?)
+`
+
+// saveToken writes a new version of a token, or marks it as deleted.
+func saveToken(conn database.Queryable, token *model.APIToken, isDeleted bool) error {
+	return conn.Exec(
+		tokenInsertQuery,
+		token.ID,
+		token.UserID,
+		token.Username,
+		token.Name,
+		token.Scope,
+		token.Hash,
+		token.CreatedAt,
+		database.BoolToUint(isDeleted),
+	)
+}
+
+// saveTokenUse records the time a token was used.
+func saveTokenUse(conn database.Queryable, token *model.APIToken, usedAt time.Time) error {
+	return conn.Exec(
+		"insert into crypto_api_token_use (token_id, used_at) values (?, ?)",
+		token.ID,
+		usedAt,
+	)
+}
+
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// generateToken returns a new random token.
+func generateToken() (string, error) {
+	var buffer [32]byte
+
+	if _, err := rand.Read(buffer[:]); err != nil {
+		return "", err
+	}
+
+	return tokenPrefix + hex.EncodeToString(buffer[:]), nil
+}
+
+// isReadMethod returns true for HTTP methods that don't change data.
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// LoadUser loads the user making an API request.
+//
+// Requests are authenticated with an `Authorization: Bearer` token, or with
+// the session cookie when there is no Authorization header, so the API can
+// also be used from the browser. ErrReadOnly is returned when a read-only
+// token is used for a request that changes data.
+func LoadUser(conn *database.Conn, request *http.Request, user *model.User) (bool, error) {
+	authorization := request.Header.Get("Authorization")
+
+	if authorization == "" {
+		return session.LoadUserFromSession(conn, request, user)
+	}
+
+	value, ok := strings.CutPrefix(authorization, "Bearer ")
+
+	if !ok {
+		return false, nil
+	}
+
+	var token model.APIToken
+
+	row := conn.QueryRow(tokenQuery+"where token_hash = ?", hashToken(strings.TrimSpace(value)))
+
+	if err := scanToken(row, &token); err != nil {
+		if err == database.ErrNoRows {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if token.Scope != ScopeReadWrite && !isReadMethod(request.Method) {
+		return false, ErrReadOnly
+	}
+
+	now := time.Now().UTC()
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedInterval {
+		if err := saveTokenUse(conn, &token, now); err != nil {
+			return false, err
+		}
+	}
+
+	*user = model.User{ID: token.UserID, Username: token.Username}
+
+	return true, nil
+}
+
+// LoadAPIUser loads the user making an API request, or responds with a JSON
+// error if the request can't be authenticated.
+func LoadAPIUser(conn *database.Conn, writer http.ResponseWriter, request *http.Request, user *model.User) bool {
+	found, err := LoadUser(conn, request, user)
+
+	if err != nil {
+		if err == ErrReadOnly {
+			util.RespondJSONStatus(writer, http.StatusForbidden, "This token is read-only")
+		} else {
+			util.RespondJSONError(writer, err)
+		}
+
+		return false
+	}
+
+	if !found {
+		util.RespondJSONStatus(writer, http.StatusUnauthorized, "Authentication required")
+	}
+
+	return found
+}
+
+func loadUser(conn *database.Conn, writer http.ResponseWriter, request *http.Request, user *model.User) bool {
+	found, err := session.LoadUserFromSession(conn, request, user)
+
+	if err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return false
+	}
+
+	return found
+}
+
+type TokenPageData struct {
+	User      model.User
+	TokenList []model.APIToken
+	// NewToken is a token that was just created, which is only shown once.
+	NewToken string
+}
+
+func renderTokenPage(conn *database.Conn, writer http.ResponseWriter, data *TokenPageData) {
+	if err := loadTokenList(conn, data.User.ID, &data.TokenList); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	template.Render(template.Settings, writer, data)
+}
+
+// HandleSettings shows the API tokens for a user.
+func HandleSettings(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := TokenPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		http.Redirect(writer, request, "/login", http.StatusFound)
+
+		return
+	}
+
+	renderTokenPage(conn, writer, &data)
+}
+
+// HandleCreateToken creates an API token, and shows it to the user once.
+func HandleCreateToken(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	data := TokenPageData{}
+
+	if !loadUser(conn, writer, request, &data.User) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	request.ParseForm()
+
+	token := model.APIToken{
+		UserID:    data.User.ID,
+		Username:  data.User.Username,
+		Name:      strings.TrimSpace(request.Form.Get("name")),
+		Scope:     request.Form.Get("scope"),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if token.Name == "" {
+		util.RespondValidationError(writer, "Name is required")
+
+		return
+	}
+
+	if len(token.Name) > maxTokenNameLength {
+		util.RespondValidationError(writer, "Name is too long")
+
+		return
+	}
+
+	if token.Scope != ScopeRead && token.Scope != ScopeReadWrite {
+		util.RespondValidationError(writer, "Invalid scope")
+
+		return
+	}
+
+	var err error
+
+	if token.ID, err = database.RandomID(); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	if data.NewToken, err = generateToken(); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	token.Hash = hashToken(data.NewToken)
+
+	if err := saveToken(conn, &token, false); err != nil {
+		util.RespondInternalServerError(writer, err)
+
+		return
+	}
+
+	renderTokenPage(conn, writer, &data)
+}
+
+// HandleDeleteToken revokes an API token.
+func HandleDeleteToken(conn *database.Conn, writer http.ResponseWriter, request *http.Request) {
+	var user model.User
+	var token model.APIToken
+
+	if !loadUser(conn, writer, request, &user) {
+		util.RespondForbidden(writer)
+
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(mux.Vars(request)["id"], 10, 64)
+
+	if err != nil {
+		util.RespondNotFound(writer)
+
+		return
+	}
+
+	row := conn.QueryRow(tokenQuery+"where user_id = ? and token_id = ?", user.ID, tokenID)
+
+	if err := scanToken(row, &token); err != nil {
+		if err == database.ErrNoRows {
+			util.RespondNotFound(writer)
+		} else {
+			util.RespondInternalServerError(writer, err)
+		}
+
+		return
+	}
+
+	if err := saveToken(conn, &token, true); err != nil {
+		util.RespondInternalServerError(writer, err)
+	} else {
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -1,11 +1,34 @@
 package util
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/dense-analysis/pricewarp/internal/model"
 )
 
+// ValidationError is an error for invalid input sent by a user.
+type ValidationError string
+
+func (err ValidationError) Error() string {
+	return string(err)
+}
+
+// RespondError responds with a validation error for a ValidationError, or
+// with an internal server error for anything else.
+func RespondError(writer http.ResponseWriter, err error) {
+	var validationError ValidationError
+
+	if errors.As(err, &validationError) {
+		RespondValidationError(writer, validationError.Error())
+	} else {
+		RespondInternalServerError(writer, err)
+	}
+}
+
 func RespondInternalServerError(writer http.ResponseWriter, err error) {
 	writer.WriteHeader(http.StatusInternalServerError)
 	fmt.Fprintf(writer, "Internal Server Error\n")
@@ -26,3 +49,52 @@ func RespondForbidden(writer http.ResponseWriter) {
 	writer.WriteHeader(http.StatusForbidden)
 	fmt.Fprintf(writer, "403: Forbidden\n")
 }
+
+// JSONError is the body of every error response from the JSON API.
+type JSONError struct {
+	Error JSONErrorDetail `json:"error"`
+}
+
+// JSONErrorDetail describes an error from the JSON API.
+type JSONErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// APICurrency is a currency in the JSON API.
+type APICurrency struct {
+	Ticker string `json:"ticker"`
+	Name   string `json:"name"`
+}
+
+func NewAPICurrency(currency *model.Currency) APICurrency {
+	return APICurrency{Ticker: currency.Ticker, Name: currency.Name}
+}
+
+// RespondJSON writes a value as a JSON response with a status code.
+func RespondJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.Printf("json encoding error: %+v\n", err)
+	}
+}
+
+// RespondJSONStatus writes a JSON error body for a status code.
+func RespondJSONStatus(writer http.ResponseWriter, status int, message string) {
+	RespondJSON(writer, status, JSONError{JSONErrorDetail{Status: status, Message: message}})
+}
+
+// RespondJSONError responds with a JSON validation error for a
+// ValidationError, or with an internal server error for anything else.
+func RespondJSONError(writer http.ResponseWriter, err error) {
+	var validationError ValidationError
+
+	if errors.As(err, &validationError) {
+		RespondJSONStatus(writer, http.StatusBadRequest, validationError.Error())
+	} else {
+		RespondJSONStatus(writer, http.StatusInternalServerError, "Internal Server Error")
+		log.Printf("internal error: %+v\n", err)
+	}
+}
@@ -64,6 +64,29 @@ func LoadUserFromSession(conn *database.Conn, request *http.Request, user *model
 func SaveUserInSession(writer http.ResponseWriter, request *http.Request, user *model.User) error {
 	session, _ := sessionStore.Get(request, "sessionid")
 	session.Values["userID"] = user.ID
+	// Start on the first portfolio of the user.
+	delete(session.Values, "portfolioID")
+
+	return session.Save(request, writer)
+}
+
+// LoadPortfolioIDFromSession loads the ID of the portfolio a user has
+// selected, which is 0 for their first portfolio.
+func LoadPortfolioIDFromSession(request *http.Request) int64 {
+	session, sessionError := sessionStore.Get(request, "sessionid")
+
+	if sessionError != nil {
+		return 0
+	}
+
+	portfolioID, _ := session.Values["portfolioID"].(int64)
+
+	return portfolioID
+}
+
+func SavePortfolioIDInSession(writer http.ResponseWriter, request *http.Request, portfolioID int64) error {
+	session, _ := sessionStore.Get(request, "sessionid")
+	session.Values["portfolioID"] = portfolioID
 
 	return session.Save(request, writer)
 }
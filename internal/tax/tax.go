@@ -0,0 +1,366 @@
+// Package tax matches disposals of assets to acquisitions under the capital
+// gains rules of a jurisdiction.
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+// Rule sets for matching disposals.
+const (
+	// UK matches disposals with acquisitions on the same day, then with
+	// acquisitions in the next 30 days, then with the section 104 pool.
+	UK = "uk"
+	// US matches disposals with the earliest acquisitions first.
+	US = "us"
+)
+
+// RuleList lists every rule set.
+var RuleList = []string{UK, US}
+
+// RuleNames are the names of rule sets shown to users.
+var RuleNames = map[string]string{
+	UK: "United Kingdom",
+	US: "United States (FIFO)",
+}
+
+// Ways a disposal can be matched to acquisitions.
+const (
+	MatchSameDay   = "same day"
+	MatchThirtyDay = "30 day"
+	MatchPool      = "section 104"
+	MatchFIFO      = "fifo"
+)
+
+// ErrUnmatched is returned when more is disposed of than was acquired.
+var ErrUnmatched = errors.New("disposal can't be matched to an acquisition")
+
+// IsValidRule returns true for a rule set in RuleList.
+func IsValidRule(rule string) bool {
+	return slices.Contains(RuleList, rule)
+}
+
+// Disposal is part of a sale matched to the acquisitions it came from.
+type Disposal struct {
+	Currency model.Currency
+	// AcquiredAt is when the amount was acquired, which is zero for amounts
+	// from a pool of acquisitions.
+	AcquiredAt time.Time
+	DisposedAt time.Time
+	Amount     decimal.Decimal
+	// Proceeds is the share of what the sale earned after fees.
+	Proceeds decimal.Decimal
+	// Cost is the cost of the acquisitions matched, including fees.
+	Cost decimal.Decimal
+	// Match is how the disposal was matched to acquisitions.
+	Match string
+}
+
+// Gain returns the gain on a disposal, which is negative for a loss.
+func (disposal Disposal) Gain() decimal.Decimal {
+	return disposal.Proceeds.Sub(disposal.Cost)
+}
+
+// IsLongTerm returns true if the asset was held for more than a year before
+// it was disposed of.
+func (disposal Disposal) IsLongTerm() bool {
+	return !disposal.AcquiredAt.IsZero() &&
+		disposal.DisposedAt.After(disposal.AcquiredAt.AddDate(1, 0, 0))
+}
+
+// Year is a tax year, from Start up to but not including End.
+type Year struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Contains returns true if a time is in the tax year.
+func (year Year) Contains(t time.Time) bool {
+	return !t.Before(year.Start) && t.Before(year.End)
+}
+
+// TaxYear returns the tax year starting in a calendar year.
+//
+// UK tax years run from the 6th of April, and US tax years are calendar years.
+func TaxYear(rule string, year int) Year {
+	if rule == UK {
+		return Year{
+			Name:  fmt.Sprintf("%d/%02d", year, (year+1)%100),
+			Start: time.Date(year, time.April, 6, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(year+1, time.April, 6, 0, 0, 0, 0, time.UTC),
+		}
+	}
+
+	return Year{
+		Name:  fmt.Sprint(year),
+		Start: time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// CurrentTaxYear returns the starting calendar year of the tax year a time is in.
+func CurrentTaxYear(rule string, t time.Time) int {
+	year := t.UTC().Year()
+
+	if !TaxYear(rule, year).Contains(t) {
+		year--
+	}
+
+	return year
+}
+
+// Match matches the sales in a sorted list of transactions for one asset to
+// acquisitions with a rule set.
+//
+// Buys and deposits are acquisitions. Withdrawals move an asset elsewhere, so
+// they remove it without a disposal. Transfers between portfolios are ignored,
+// as the asset keeps its owner, and so the acquisitions it was moved with.
+func Match(rule string, transactionList []model.Transaction) ([]Disposal, error) {
+	transactionList = slices.DeleteFunc(slices.Clone(transactionList), func(transaction model.Transaction) bool {
+		return transaction.TransferID != 0
+	})
+
+	switch rule {
+	case UK:
+		return matchUK(transactionList)
+	case US:
+		return matchFIFO(transactionList)
+	}
+
+	return nil, fmt.Errorf("unknown tax rule set: %s", rule)
+}
+
+// share returns the part of a value for an amount out of a total amount.
+func share(value decimal.Decimal, amount decimal.Decimal, total decimal.Decimal) decimal.Decimal {
+	if amount.Equal(total) {
+		return value
+	}
+
+	return value.Mul(amount).Div(total)
+}
+
+func unmatchedError(transaction *model.Transaction) error {
+	return fmt.Errorf(
+		"%w: %s %s of %s on %s",
+		ErrUnmatched,
+		transaction.Kind,
+		transaction.Quantity.String(),
+		transaction.Currency.Ticker,
+		transaction.Time.UTC().Format("2006-01-02 15:04"),
+	)
+}
+
+// fifoLot is an amount acquired in one transaction that hasn't been matched.
+type fifoLot struct {
+	time   time.Time
+	amount decimal.Decimal
+	cost   decimal.Decimal
+}
+
+// matchFIFO matches each sale with the earliest acquisitions first.
+func matchFIFO(transactionList []model.Transaction) ([]Disposal, error) {
+	var lotList []fifoLot
+	var disposalList []Disposal
+
+	for _, transaction := range transactionList {
+		switch transaction.Kind {
+		case ledger.Buy, ledger.Deposit:
+			lotList = append(lotList, fifoLot{
+				time:   transaction.Time,
+				amount: ledger.Amount(&transaction),
+				cost:   ledger.Cost(&transaction),
+			})
+		case ledger.Sell, ledger.Withdrawal:
+			amount := ledger.Amount(&transaction)
+			remaining := amount
+			total := ledger.Proceeds(&transaction)
+
+			for remaining.IsPositive() {
+				if len(lotList) == 0 {
+					return nil, unmatchedError(&transaction)
+				}
+
+				lot := &lotList[0]
+				take := decimal.Min(remaining, lot.amount)
+				lotCost := share(lot.cost, take, lot.amount)
+
+				if transaction.Kind == ledger.Sell {
+					disposalList = append(disposalList, Disposal{
+						Currency:   transaction.Currency,
+						AcquiredAt: lot.time,
+						DisposedAt: transaction.Time,
+						Amount:     take,
+						Proceeds:   share(total, take, amount),
+						Cost:       lotCost,
+						Match:      MatchFIFO,
+					})
+				}
+
+				lot.amount = lot.amount.Sub(take)
+				lot.cost = lot.cost.Sub(lotCost)
+				remaining = remaining.Sub(take)
+
+				if lot.amount.IsZero() {
+					lotList = lotList[1:]
+				}
+			}
+		}
+	}
+
+	return disposalList, nil
+}
+
+// ukDay is every transaction for an asset on one day, as the UK rules treat
+// all acquisitions and all disposals on a day as one of each.
+type ukDay struct {
+	date time.Time
+	// acquired is the amount acquired, and acquiredLeft is the amount not
+	// matched with disposals yet.
+	acquired     decimal.Decimal
+	acquiredLeft decimal.Decimal
+	cost         decimal.Decimal
+	// sold is the amount sold, and soldLeft is the amount not matched with
+	// acquisitions yet.
+	sold      decimal.Decimal
+	soldLeft  decimal.Decimal
+	proceeds  decimal.Decimal
+	withdrawn decimal.Decimal
+	sale      *model.Transaction
+}
+
+func groupUKDays(transactionList []model.Transaction) []ukDay {
+	var dayList []ukDay
+
+	for i := range transactionList {
+		transaction := &transactionList[i]
+		date := transaction.Time.UTC().Truncate(24 * time.Hour)
+
+		if len(dayList) == 0 || !dayList[len(dayList)-1].date.Equal(date) {
+			dayList = append(dayList, ukDay{
+				date:         date,
+				acquired:     decimal.Zero,
+				acquiredLeft: decimal.Zero,
+				cost:         decimal.Zero,
+				sold:         decimal.Zero,
+				soldLeft:     decimal.Zero,
+				proceeds:     decimal.Zero,
+				withdrawn:    decimal.Zero,
+			})
+		}
+
+		day := &dayList[len(dayList)-1]
+
+		switch transaction.Kind {
+		case ledger.Buy, ledger.Deposit:
+			day.acquired = day.acquired.Add(ledger.Amount(transaction))
+			day.cost = day.cost.Add(ledger.Cost(transaction))
+		case ledger.Sell:
+			day.sold = day.sold.Add(ledger.Amount(transaction))
+			day.proceeds = day.proceeds.Add(ledger.Proceeds(transaction))
+			day.sale = transaction
+		case ledger.Withdrawal:
+			day.withdrawn = day.withdrawn.Add(ledger.Amount(transaction))
+		}
+
+		day.acquiredLeft = day.acquired
+		day.soldLeft = day.sold
+	}
+
+	return dayList
+}
+
+// matchUK matches sales with the same day rule, then the 30 day rule, then
+// with the section 104 pool at its average cost.
+func matchUK(transactionList []model.Transaction) ([]Disposal, error) {
+	var disposalList []Disposal
+
+	dayList := groupUKDays(transactionList)
+
+	newDisposal := func(day *ukDay, acquiredAt time.Time, amount decimal.Decimal, cost decimal.Decimal, match string) {
+		disposalList = append(disposalList, Disposal{
+			Currency:   day.sale.Currency,
+			AcquiredAt: acquiredAt,
+			DisposedAt: day.date,
+			Amount:     amount,
+			Proceeds:   share(day.proceeds, amount, day.sold),
+			Cost:       cost,
+			Match:      match,
+		})
+	}
+
+	// Match with acquisitions on the same day.
+	for i := range dayList {
+		day := &dayList[i]
+		amount := decimal.Min(day.sold, day.acquired)
+
+		if amount.IsPositive() {
+			newDisposal(day, day.date, amount, share(day.cost, amount, day.acquired), MatchSameDay)
+			day.soldLeft = day.soldLeft.Sub(amount)
+			day.acquiredLeft = day.acquiredLeft.Sub(amount)
+		}
+	}
+
+	// Match with acquisitions in the 30 days after, earliest first.
+	for i := range dayList {
+		day := &dayList[i]
+
+		for j := i + 1; j < len(dayList) && day.soldLeft.IsPositive(); j++ {
+			later := &dayList[j]
+
+			if later.date.After(day.date.AddDate(0, 0, 30)) {
+				break
+			}
+
+			amount := decimal.Min(day.soldLeft, later.acquiredLeft)
+
+			if amount.IsPositive() {
+				newDisposal(day, later.date, amount, share(later.cost, amount, later.acquired), MatchThirtyDay)
+				day.soldLeft = day.soldLeft.Sub(amount)
+				later.acquiredLeft = later.acquiredLeft.Sub(amount)
+			}
+		}
+	}
+
+	// Match everything else with the section 104 pool.
+	poolAmount := decimal.Zero
+	poolCost := decimal.Zero
+
+	for i := range dayList {
+		day := &dayList[i]
+
+		poolAmount = poolAmount.Add(day.acquiredLeft)
+		poolCost = poolCost.Add(share(day.cost, day.acquiredLeft, day.acquired))
+
+		if day.soldLeft.IsPositive() {
+			if day.soldLeft.GreaterThan(poolAmount) {
+				return nil, unmatchedError(day.sale)
+			}
+
+			amount := day.soldLeft
+			amountCost := share(poolCost, amount, poolAmount)
+			newDisposal(day, time.Time{}, amount, amountCost, MatchPool)
+			poolAmount = poolAmount.Sub(amount)
+			poolCost = poolCost.Sub(amountCost)
+		}
+
+		if day.withdrawn.IsPositive() {
+			amount := decimal.Min(day.withdrawn, poolAmount)
+			poolCost = poolCost.Sub(share(poolCost, amount, poolAmount))
+			poolAmount = poolAmount.Sub(amount)
+		}
+	}
+
+	slices.SortStableFunc(disposalList, func(a, b Disposal) int {
+		return a.DisposedAt.Compare(b.DisposedAt)
+	})
+
+	return disposalList, nil
+}
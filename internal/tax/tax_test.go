@@ -0,0 +1,401 @@
+package tax
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dense-analysis/pricewarp/internal/ledger"
+	"github.com/dense-analysis/pricewarp/internal/model"
+	"github.com/shopspring/decimal"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// newTransaction returns a transaction for BTC without a fee.
+func newTransaction(kind string, at time.Time, quantity string, price string) model.Transaction {
+	return model.Transaction{
+		Currency: model.Currency{Ticker: "BTC", Name: "Bitcoin"},
+		Kind:     kind,
+		Time:     at,
+		Quantity: decimal.RequireFromString(quantity),
+		Price:    decimal.RequireFromString(price),
+		Fee:      decimal.Zero,
+	}
+}
+
+// withFee returns a transaction with a fee in the portfolio currency.
+func withFee(transaction model.Transaction, fee string) model.Transaction {
+	transaction.Fee = decimal.RequireFromString(fee)
+
+	return transaction
+}
+
+// expectedDisposal is a disposal expected from matching.
+type expectedDisposal struct {
+	match      string
+	acquiredAt time.Time
+	disposedAt time.Time
+	amount     string
+	proceeds   string
+	cost       string
+}
+
+func checkDecimal(t *testing.T, name string, actual decimal.Decimal, expected string) {
+	t.Helper()
+
+	if !actual.Equal(decimal.RequireFromString(expected)) {
+		t.Errorf("%s: got %s, expected %s", name, actual.String(), expected)
+	}
+}
+
+func checkDisposalList(t *testing.T, disposalList []Disposal, expectedList []expectedDisposal) {
+	t.Helper()
+
+	if len(disposalList) != len(expectedList) {
+		t.Fatalf("got %d disposals, expected %d", len(disposalList), len(expectedList))
+	}
+
+	for i, expected := range expectedList {
+		disposal := disposalList[i]
+
+		if disposal.Match != expected.match {
+			t.Errorf("disposal %d: got match %q, expected %q", i, disposal.Match, expected.match)
+		}
+
+		if !disposal.AcquiredAt.Equal(expected.acquiredAt) {
+			t.Errorf("disposal %d: got acquired at %s, expected %s", i, disposal.AcquiredAt, expected.acquiredAt)
+		}
+
+		if !disposal.DisposedAt.Equal(expected.disposedAt) {
+			t.Errorf("disposal %d: got disposed at %s, expected %s", i, disposal.DisposedAt, expected.disposedAt)
+		}
+
+		checkDecimal(t, "amount", disposal.Amount, expected.amount)
+		checkDecimal(t, "proceeds", disposal.Proceeds, expected.proceeds)
+		checkDecimal(t, "cost", disposal.Cost, expected.cost)
+	}
+}
+
+func TestMatchUK(t *testing.T) {
+	testCases := []struct {
+		name            string
+		transactionList []model.Transaction
+		expected        []expectedDisposal
+	}{
+		{
+			// The sale is matched with the buys on its day at their average
+			// cost, not with the earlier buy.
+			name: "Same day buy and sell",
+			transactionList: []model.Transaction{
+				newTransaction(ledger.Buy, date(2024, time.January, 1), "1", "100"),
+				newTransaction(ledger.Buy, date(2024, time.January, 10), "1", "150"),
+				newTransaction(ledger.Buy, date(2024, time.January, 10).Add(time.Hour), "1", "250"),
+				newTransaction(ledger.Sell, date(2024, time.January, 10).Add(2*time.Hour), "1", "300"),
+			},
+			expected: []expectedDisposal{
+				{MatchSameDay, date(2024, time.January, 10), date(2024, time.January, 10), "1", "300", "200"},
+			},
+		},
+		{
+			name: "Bed and breakfast buy back within 30 days",
+			transactionList: []model.Transaction{
+				newTransaction(ledger.Buy, date(2024, time.January, 1), "2", "100"),
+				newTransaction(ledger.Sell, date(2024, time.January, 10), "1", "300"),
+				newTransaction(ledger.Buy, date(2024, time.January, 20), "1", "250"),
+			},
+			expected: []expectedDisposal{
+				{MatchThirtyDay, date(2024, time.January, 20), date(2024, time.January, 10), "1", "300", "250"},
+			},
+		},
+		{
+			name: "Buy back after 30 days is left in the pool",
+			transactionList: []model.Transaction{
+				newTransaction(ledger.Buy, date(2024, time.January, 1), "2", "100"),
+				newTransaction(ledger.Sell, date(2024, time.January, 10), "1", "300"),
+				newTransaction(ledger.Buy, date(2024, time.February, 10), "1", "250"),
+			},
+			expected: []expectedDisposal{
+				{MatchPool, time.Time{}, date(2024, time.January, 10), "1", "300", "100"},
+			},
+		},
+		{
+			// Half a unit is bought back, so the rest of the sale comes from
+			// the pool.
+			name: "Sale from the pool after a partial 30 day match",
+			transactionList: []model.Transaction{
+				newTransaction(ledger.Buy, date(2024, time.January, 1), "2", "100"),
+				newTransaction(ledger.Sell, date(2024, time.January, 10), "2", "300"),
+				newTransaction(ledger.Buy, date(2024, time.January, 20), "0.5", "200"),
+			},
+			expected: []expectedDisposal{
+				{MatchThirtyDay, date(2024, time.January, 20), date(2024, time.January, 10), "0.5", "150", "100"},
+				{MatchPool, time.Time{}, date(2024, time.January, 10), "1.5", "450", "150"},
+			},
+		},
+		{
+			// The pool is averaged over 2 units at 100 and 1 at 200.
+			name: "Pool at its average cost",
+			transactionList: []model.Transaction{
+				newTransaction(ledger.Buy, date(2024, time.January, 1), "2", "100"),
+				newTransaction(ledger.Buy, date(2024, time.January, 2), "1", "200"),
+				newTransaction(ledger.Sell, date(2024, time.March, 1), "1.5", "400"),
+			},
+			expected: []expectedDisposal{
+				{MatchPool, time.Time{}, date(2024, time.March, 1), "1.5", "600", "200"},
+			},
+		},
+		{
+			// Withdrawing 1 of 2 units at 100 leaves 1 at 100 in the pool,
+			// which is then averaged with 1 at 400.
+			name: "Withdrawals reduce the pool",
+			transactionList: []model.Transaction{
+				newTransaction(ledger.Buy, date(2024, time.January, 1), "2", "100"),
+				newTransaction(ledger.Withdrawal, date(2024, time.January, 5), "1", "100"),
+				newTransaction(ledger.Buy, date(2024, time.January, 6), "1", "400"),
+				newTransaction(ledger.Sell, date(2024, time.March, 1), "1", "600"),
+			},
+			expected: []expectedDisposal{
+				{MatchPool, time.Time{}, date(2024, time.March, 1), "1", "600", "250"},
+			},
+		},
+		{
+			name: "Fees are added to costs and taken from proceeds",
+			transactionList: []model.Transaction{
+				withFee(newTransaction(ledger.Buy, date(2024, time.January, 1), "1", "100"), "10"),
+				withFee(newTransaction(ledger.Sell, date(2024, time.March, 1), "1", "300"), "5"),
+			},
+			expected: []expectedDisposal{
+				{MatchPool, time.Time{}, date(2024, time.March, 1), "1", "295", "110"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			disposalList, err := Match(UK, testCase.transactionList)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			checkDisposalList(t, disposalList, testCase.expected)
+		})
+	}
+}
+
+func TestMatchFIFO(t *testing.T) {
+	testCases := []struct {
+		name            string
+		transactionList []model.Transaction
+		expected        []expectedDisposal
+	}{
+		{
+			name: "Sale spans lots",
+			transactionList: []model.Transaction{
+				newTransaction(ledger.Buy, date(2024, time.January, 1), "1", "100"),
+				newTransaction(ledger.Buy, date(2024, time.January, 2), "1", "200"),
+				withFee(newTransaction(ledger.Sell, date(2024, time.January, 3), "1.5", "300"), "30"),
+			},
+			expected: []expectedDisposal{
+				{MatchFIFO, date(2024, time.January, 1), date(2024, time.January, 3), "1", "280", "100"},
+				{MatchFIFO, date(2024, time.January, 2), date(2024, time.January, 3), "0.5", "140", "100"},
+			},
+		},
+		{
+			name: "Withdrawals take the earliest lots without a disposal",
+			transactionList: []model.Transaction{
+				newTransaction(ledger.Buy, date(2024, time.January, 1), "1", "100"),
+				newTransaction(ledger.Buy, date(2024, time.January, 2), "1", "200"),
+				newTransaction(ledger.Withdrawal, date(2024, time.January, 3), "1", "150"),
+				newTransaction(ledger.Sell, date(2024, time.January, 4), "1", "300"),
+			},
+			expected: []expectedDisposal{
+				{MatchFIFO, date(2024, time.January, 2), date(2024, time.January, 4), "1", "300", "200"},
+			},
+		},
+		{
+			// The 30 day rule is only for the UK.
+			name: "Buy backs don't change the lot sold",
+			transactionList: []model.Transaction{
+				withFee(newTransaction(ledger.Buy, date(2024, time.January, 1), "1", "100"), "10"),
+				newTransaction(ledger.Sell, date(2024, time.January, 10), "1", "300"),
+				newTransaction(ledger.Buy, date(2024, time.January, 11), "1", "250"),
+			},
+			expected: []expectedDisposal{
+				{MatchFIFO, date(2024, time.January, 1), date(2024, time.January, 10), "1", "300", "110"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			disposalList, err := Match(US, testCase.transactionList)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			checkDisposalList(t, disposalList, testCase.expected)
+		})
+	}
+}
+
+func TestMatchOversell(t *testing.T) {
+	transactionList := []model.Transaction{
+		newTransaction(ledger.Buy, date(2024, time.January, 1), "1", "100"),
+		newTransaction(ledger.Sell, date(2024, time.January, 2), "1.5", "200"),
+	}
+
+	for _, rule := range RuleList {
+		t.Run(rule, func(t *testing.T) {
+			if _, err := Match(rule, transactionList); !errors.Is(err, ErrUnmatched) {
+				t.Errorf("got %v, expected ErrUnmatched", err)
+			}
+		})
+	}
+}
+
+func TestMatchUnknownRule(t *testing.T) {
+	if _, err := Match("random", nil); err == nil {
+		t.Error("expected an error for an unknown rule set")
+	}
+}
+
+// Sales either side of the start of a UK tax year fall in different years.
+func TestMatchUKTaxYearBoundary(t *testing.T) {
+	transactionList := []model.Transaction{
+		newTransaction(ledger.Buy, date(2024, time.January, 1), "2", "100"),
+		newTransaction(ledger.Sell, date(2024, time.April, 5).Add(23*time.Hour), "1", "300"),
+		newTransaction(ledger.Sell, date(2024, time.April, 6), "1", "400"),
+	}
+	disposalList, err := Match(UK, transactionList)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	checkDisposalList(t, disposalList, []expectedDisposal{
+		{MatchPool, time.Time{}, date(2024, time.April, 5), "1", "300", "100"},
+		{MatchPool, time.Time{}, date(2024, time.April, 6), "1", "400", "100"},
+	})
+
+	for i, year := range []int{2023, 2024} {
+		if !TaxYear(UK, year).Contains(disposalList[i].DisposedAt) {
+			t.Errorf("disposal %d: expected it to be in the tax year %s", i, TaxYear(UK, year).Name)
+		}
+
+		if actual := CurrentTaxYear(UK, disposalList[i].DisposedAt); actual != year {
+			t.Errorf("disposal %d: got tax year %d, expected %d", i, actual, year)
+		}
+	}
+}
+
+func TestTaxYear(t *testing.T) {
+	testCases := []struct {
+		rule  string
+		year  int
+		name  string
+		start time.Time
+		end   time.Time
+	}{
+		{UK, 2023, "2023/24", date(2023, time.April, 6), date(2024, time.April, 6)},
+		{UK, 2099, "2099/00", date(2099, time.April, 6), date(2100, time.April, 6)},
+		{US, 2024, "2024", date(2024, time.January, 1), date(2025, time.January, 1)},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			year := TaxYear(testCase.rule, testCase.year)
+
+			if year.Name != testCase.name {
+				t.Errorf("got name %q, expected %q", year.Name, testCase.name)
+			}
+
+			if !year.Start.Equal(testCase.start) || !year.End.Equal(testCase.end) {
+				t.Errorf("got %s to %s, expected %s to %s", year.Start, year.End, testCase.start, testCase.end)
+			}
+		})
+	}
+}
+
+func TestCurrentTaxYear(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rule     string
+		time     time.Time
+		expected int
+	}{
+		{"UK on 5 April", UK, date(2024, time.April, 5).Add(23*time.Hour + 59*time.Minute), 2023},
+		{"UK on 6 April", UK, date(2024, time.April, 6), 2024},
+		{"UK in January", UK, date(2024, time.January, 1), 2023},
+		{"UK in December", UK, date(2024, time.December, 31), 2024},
+		{"US on 1 January", US, date(2024, time.January, 1), 2024},
+		{"US on 31 December", US, date(2024, time.December, 31).Add(23 * time.Hour), 2024},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if actual := CurrentTaxYear(testCase.rule, testCase.time); actual != testCase.expected {
+				t.Errorf("got %d, expected %d", actual, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestIsLongTerm(t *testing.T) {
+	testCases := []struct {
+		name       string
+		acquiredAt time.Time
+		disposedAt time.Time
+		expected   bool
+	}{
+		{"Held for less than a year", date(2023, time.June, 1), date(2024, time.January, 1), false},
+		{"Held for exactly a year", date(2023, time.January, 1), date(2024, time.January, 1), false},
+		{"Held for more than a year", date(2023, time.January, 1), date(2024, time.January, 2), true},
+		{"Matched with a pool", time.Time{}, date(2024, time.January, 1), false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			disposal := Disposal{AcquiredAt: testCase.acquiredAt, DisposedAt: testCase.disposedAt}
+
+			if actual := disposal.IsLongTerm(); actual != testCase.expected {
+				t.Errorf("got %v, expected %v", actual, testCase.expected)
+			}
+		})
+	}
+}
+
+// Moving an asset between portfolios isn't a disposal, and the lots it moves
+// keep their cost, so both legs of a transfer are left out.
+func TestMatchIgnoresTransfers(t *testing.T) {
+	withdrawal := newTransaction(ledger.Withdrawal, date(2024, time.January, 5), "1", "150")
+	withdrawal.TransferID = 7
+	transactionList := []model.Transaction{
+		newTransaction(ledger.Buy, date(2024, time.January, 1), "2", "100"),
+		withdrawal,
+		newTransaction(ledger.Sell, date(2024, time.March, 1), "2", "300"),
+	}
+	testCases := []struct {
+		rule     string
+		expected []expectedDisposal
+	}{
+		{UK, []expectedDisposal{{MatchPool, time.Time{}, date(2024, time.March, 1), "2", "600", "200"}}},
+		{US, []expectedDisposal{{MatchFIFO, date(2024, time.January, 1), date(2024, time.March, 1), "2", "600", "200"}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.rule, func(t *testing.T) {
+			disposalList, err := Match(testCase.rule, transactionList)
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			checkDisposalList(t, disposalList, testCase.expected)
+		})
+	}
+}
@@ -14,8 +14,17 @@ var templateMap map[string]*template.Template
 var Login *template.Template
 var AlertList *template.Template
 var Alert *template.Template
+var AlertBacktest *template.Template
+var AlertImport *template.Template
+var AlertLadder *template.Template
 var Portfolio *template.Template
+var CombinedPortfolio *template.Template
 var Asset *template.Template
+var Transaction *template.Template
+var Gains *template.Template
+var TaxReport *template.Template
+var TradeImport *template.Template
+var Settings *template.Template
 
 func Init() {
 	Login = template.Must(template.ParseFiles(
@@ -25,6 +34,7 @@ func Init() {
 	AlertList = template.Must(template.ParseFiles(
 		"template/base.tmpl",
 		"template/alert-form.tmpl",
+		"template/alert-ladder-form.tmpl",
 		"template/alert-list.tmpl",
 	))
 	Alert = template.Must(template.ParseFiles(
@@ -32,14 +42,56 @@ func Init() {
 		"template/alert-form.tmpl",
 		"template/alert.tmpl",
 	))
+	AlertBacktest = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/alert-form.tmpl",
+		"template/alert-backtest.tmpl",
+	))
+	AlertImport = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/alert-import.tmpl",
+	))
+	AlertLadder = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/alert-ladder-form.tmpl",
+		"template/alert-ladder.tmpl",
+	))
 	Portfolio = template.Must(template.ParseFiles(
 		"template/base.tmpl",
+		"template/performance.tmpl",
 		"template/portfolio.tmpl",
 	))
+	CombinedPortfolio = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/portfolio-combined.tmpl",
+	))
 	Asset = template.Must(template.ParseFiles(
 		"template/base.tmpl",
+		"template/transaction-form.tmpl",
+		"template/performance.tmpl",
 		"template/asset.tmpl",
 	))
+	Transaction = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/transaction-form.tmpl",
+		"template/transaction.tmpl",
+	))
+	Gains = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/gains.tmpl",
+	))
+	TaxReport = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/tax-report.tmpl",
+	))
+	TradeImport = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/trade-import.tmpl",
+	))
+	Settings = template.Must(template.ParseFiles(
+		"template/base.tmpl",
+		"template/settings.tmpl",
+	))
 }
 
 func Render(tmpl *template.Template, writer io.Writer, data any) {
@@ -0,0 +1,591 @@
+// Package tradeimport reads trades from the CSV trade histories exported by
+// exchanges.
+package tradeimport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Formats of trade histories.
+const (
+	Binance  = "binance"
+	Coinbase = "coinbase"
+	Kraken   = "kraken"
+	// Generic reads any CSV file with columns named by a Mapping.
+	Generic = "generic"
+)
+
+// FormatList lists every format.
+var FormatList = []string{Binance, Coinbase, Kraken, Generic}
+
+// FormatNames are the names of formats shown to users.
+var FormatNames = map[string]string{
+	Binance:  "Binance",
+	Coinbase: "Coinbase",
+	Kraken:   "Kraken",
+	Generic:  "Other (map columns)",
+}
+
+// Sides of a trade.
+const (
+	Buy  = "buy"
+	Sell = "sell"
+)
+
+// ErrSkipped is returned for rows that aren't trades, such as transfers.
+var ErrSkipped = errors.New("not a trade")
+
+// IsValidFormat returns true for a format in FormatList.
+func IsValidFormat(format string) bool {
+	return slices.Contains(FormatList, format)
+}
+
+// Trade is a trade read from a row of a trade history.
+type Trade struct {
+	// ExternalID identifies the trade at the exchange, so trades imported
+	// twice can be found. Rows without an ID get one from their contents.
+	ExternalID string
+	Time       time.Time
+	Side       string
+	// Base is the ticker of the asset traded, and Quote is the ticker of the
+	// currency it was priced in.
+	Base     string
+	Quote    string
+	Quantity decimal.Decimal
+	// Price is the price of one unit of Base in Quote.
+	Price decimal.Decimal
+	Fee   decimal.Decimal
+	// FeeCurrency is the ticker of the currency the fee was paid in.
+	FeeCurrency string
+}
+
+// Row is the result of reading one row of a trade history.
+type Row struct {
+	// Number is the line of the row, where the header is line 1.
+	Number int
+	Trade  Trade
+	// Err is set if the row couldn't be read, or ErrSkipped if it isn't a trade.
+	Err error
+}
+
+// Mapping names the columns to read for the Generic format.
+type Mapping struct {
+	ID       string
+	Time     string
+	Side     string
+	Asset    string
+	Quote    string
+	Quantity string
+	Price    string
+	Fee      string
+}
+
+// DefaultMapping is the Generic mapping used for columns that aren't named.
+var DefaultMapping = Mapping{
+	ID:       "id",
+	Time:     "time",
+	Side:     "side",
+	Asset:    "asset",
+	Quote:    "quote",
+	Quantity: "quantity",
+	Price:    "price",
+	Fee:      "fee",
+}
+
+// record is a row of a CSV file with columns looked up by name.
+type record struct {
+	columnMap map[string]int
+	row       []string
+	// occurrence counts the identical rows before this one in the file.
+	occurrence int
+}
+
+func (record *record) get(name string) string {
+	if i, ok := record.columnMap[strings.ToLower(name)]; ok && i < len(record.row) {
+		return strings.TrimSpace(record.row[i])
+	}
+
+	return ""
+}
+
+func (record *record) has(name string) bool {
+	_, ok := record.columnMap[strings.ToLower(name)]
+
+	return ok
+}
+
+// hashID creates an ID for a row from its contents.
+//
+// Exchanges can export identical rows for separate fills in the same second,
+// so rows repeated in a file are told apart by how many came before them.
+// The first of them gets the same ID as a row that isn't repeated.
+func (record *record) hashID(format string) string {
+	content := format + "\x00" + strings.Join(record.row, "\x00")
+
+	if record.occurrence > 0 {
+		content += fmt.Sprintf("\x00%d", record.occurrence)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+
+	return format + ":" + hex.EncodeToString(sum[:12])
+}
+
+// Read reads the trades in a trade history.
+//
+// Lines before the header are skipped, as some exchanges add a title above
+// the header. An error is only returned if the file can't be read at all.
+func Read(format string, content []byte, mapping Mapping) ([]Row, error) {
+	var parse func(record *record) (Trade, error)
+	var headerColumn string
+
+	switch format {
+	case Binance:
+		parse, headerColumn = parseBinance, "date(utc)"
+	case Coinbase:
+		parse, headerColumn = parseCoinbase, "timestamp"
+	case Kraken:
+		parse, headerColumn = parseKraken, "txid"
+	case Generic:
+		mapping = mapping.withDefaults()
+		headerColumn = strings.ToLower(mapping.Time)
+		parse = func(record *record) (Trade, error) {
+			return parseGeneric(record, &mapping)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+
+	csvReader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\ufeff"))))
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+
+	var columnMap map[string]int
+	var rowList []Row
+	line := 0
+	// occurrenceMap counts how many times each row has been seen.
+	occurrenceMap := map[string]int{}
+
+	for {
+		row, err := csvReader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		line++
+
+		if columnMap == nil {
+			if columnMap = readHeader(row, headerColumn); columnMap != nil {
+				// Number rows from the header, like a spreadsheet starting at it.
+				line = 1
+			}
+
+			continue
+		}
+
+		if len(row) == 1 && strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+
+		key := strings.Join(row, "\x00")
+		trade, err := parse(&record{columnMap: columnMap, row: row, occurrence: occurrenceMap[key]})
+		occurrenceMap[key]++
+		rowList = append(rowList, Row{Number: line, Trade: trade, Err: err})
+	}
+
+	if columnMap == nil {
+		return nil, fmt.Errorf("no header row with a %q column", headerColumn)
+	}
+
+	return rowList, nil
+}
+
+// readHeader maps column names to their positions, if a row is the header row
+// with a given column.
+func readHeader(row []string, headerColumn string) map[string]int {
+	columnMap := make(map[string]int, len(row))
+
+	for i, name := range row {
+		columnMap[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, ok := columnMap[headerColumn]; !ok {
+		return nil
+	}
+
+	return columnMap
+}
+
+func (mapping Mapping) withDefaults() Mapping {
+	fill := func(value *string, name string) {
+		if strings.TrimSpace(*value) == "" {
+			*value = name
+		}
+	}
+
+	fill(&mapping.ID, DefaultMapping.ID)
+	fill(&mapping.Time, DefaultMapping.Time)
+	fill(&mapping.Side, DefaultMapping.Side)
+	fill(&mapping.Asset, DefaultMapping.Asset)
+	fill(&mapping.Quote, DefaultMapping.Quote)
+	fill(&mapping.Quantity, DefaultMapping.Quantity)
+	fill(&mapping.Price, DefaultMapping.Price)
+	fill(&mapping.Fee, DefaultMapping.Fee)
+
+	return mapping
+}
+
+// parseNumber parses a number, ignoring currency symbols and thousands separators.
+func parseNumber(value string, name string) (decimal.Decimal, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+
+		return -1
+	}, value)
+
+	number, err := decimal.NewFromString(cleaned)
+
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid %s: %q", name, value)
+	}
+
+	return number.Abs(), nil
+}
+
+// splitAmount splits an amount with a ticker after it, such as "0.5BTC".
+func splitAmount(value string, name string) (decimal.Decimal, string, error) {
+	value = strings.TrimSpace(value)
+	// The ticker starts at the first character that can't be in a number, so
+	// tickers with digits such as "C98" are kept whole.
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != ',' && r != '-'
+	})
+
+	if end <= 0 {
+		return decimal.Zero, "", fmt.Errorf("invalid %s: %q", name, value)
+	}
+
+	amount, err := parseNumber(value[:end], name)
+
+	return amount, strings.ToUpper(strings.TrimSpace(value[end:])), err
+}
+
+// parseTime parses a time in one of the layouts exchanges use, in UTC.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02 15:04:05 MST",
+		"2006-01-02 15:04:05 UTC",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time: %q", value)
+}
+
+// parseSide reads the side of a trade, skipping rows that aren't trades.
+func parseSide(value string) (string, error) {
+	lower := strings.ToLower(value)
+
+	switch {
+	case strings.Contains(lower, "buy"):
+		return Buy, nil
+	case strings.Contains(lower, "sell"):
+		return Sell, nil
+	}
+
+	return "", ErrSkipped
+}
+
+// quoteList lists currencies trading pairs are commonly quoted in, for
+// splitting pairs written without a separator.
+var quoteList = []string{
+	"USDT", "USDC", "BUSD", "FDUSD", "TUSD", "DAI",
+	"USD", "EUR", "GBP", "JPY", "CAD", "AUD", "CHF", "TRY", "BRL",
+	"BTC", "ETH", "BNB",
+}
+
+// splitPair splits a trading pair such as "BTCUSDT" or "BTC/USD".
+func splitPair(pair string) (string, string, error) {
+	pair = strings.ToUpper(strings.TrimSpace(pair))
+
+	for _, separator := range []string{"/", "-", "_"} {
+		if base, quote, ok := strings.Cut(pair, separator); ok {
+			return base, quote, nil
+		}
+	}
+
+	for _, quote := range quoteList {
+		if base, ok := strings.CutSuffix(pair, quote); ok && base != "" {
+			return base, quote, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("unknown trading pair: %q", pair)
+}
+
+// parseBinance reads a row of a Binance spot trade history.
+//
+// Newer exports have amounts with tickers, such as "0.5BTC", and older
+// exports have a Market column with separate fee currencies.
+func parseBinance(record *record) (Trade, error) {
+	var trade Trade
+	var err error
+
+	if trade.Side, err = parseSide(record.get("side") + record.get("type")); err != nil {
+		return trade, err
+	}
+
+	if trade.Time, err = parseTime(record.get("date(utc)")); err != nil {
+		return trade, err
+	}
+
+	if trade.Price, err = parseNumber(record.get("price"), "price"); err != nil {
+		return trade, err
+	}
+
+	if record.has("market") {
+		if trade.Base, trade.Quote, err = splitPair(record.get("market")); err != nil {
+			return trade, err
+		}
+
+		if trade.Quantity, err = parseNumber(record.get("amount"), "amount"); err != nil {
+			return trade, err
+		}
+
+		if trade.Fee, err = parseNumber(record.get("fee"), "fee"); err != nil {
+			return trade, err
+		}
+
+		trade.FeeCurrency = strings.ToUpper(record.get("fee coin"))
+	} else {
+		if trade.Quantity, trade.Base, err = splitAmount(record.get("executed"), "executed amount"); err != nil {
+			return trade, err
+		}
+
+		var total decimal.Decimal
+
+		if total, trade.Quote, err = splitAmount(record.get("amount"), "amount"); err != nil {
+			return trade, err
+		}
+
+		if !trade.Quantity.IsZero() {
+			trade.Price = total.Div(trade.Quantity)
+		}
+
+		if trade.Fee, trade.FeeCurrency, err = splitAmount(record.get("fee"), "fee"); err != nil {
+			return trade, err
+		}
+	}
+
+	trade.ExternalID = record.hashID(Binance)
+
+	return trade, nil
+}
+
+// parseCoinbase reads a row of a Coinbase transaction history.
+//
+// Rows that aren't buys or sells, such as sends and receives, are skipped.
+func parseCoinbase(record *record) (Trade, error) {
+	var trade Trade
+	var err error
+
+	if trade.Side, err = parseSide(record.get("transaction type")); err != nil {
+		return trade, err
+	}
+
+	if trade.Time, err = parseTime(record.get("timestamp")); err != nil {
+		return trade, err
+	}
+
+	trade.Base = strings.ToUpper(record.get("asset"))
+	trade.Quote = strings.ToUpper(record.get("spot price currency"))
+	trade.FeeCurrency = trade.Quote
+
+	if trade.Quantity, err = parseNumber(record.get("quantity transacted"), "quantity"); err != nil {
+		return trade, err
+	}
+
+	if trade.Price, err = parseNumber(record.get("spot price at transaction"), "price"); err != nil {
+		return trade, err
+	}
+
+	if fee := record.get("fees and/or spread"); fee != "" {
+		if trade.Fee, err = parseNumber(fee, "fee"); err != nil {
+			return trade, err
+		}
+	}
+
+	if id := record.get("id"); id != "" {
+		trade.ExternalID = Coinbase + ":" + id
+	} else {
+		trade.ExternalID = record.hashID(Coinbase)
+	}
+
+	return trade, nil
+}
+
+// krakenAssets maps Kraken's names for assets to common tickers.
+var krakenAssets = map[string]string{
+	"XXBT": "BTC",
+	"XBT":  "BTC",
+	"XETH": "ETH",
+	"XXDG": "DOGE",
+	"XDG":  "DOGE",
+	"XLTC": "LTC",
+	"XXRP": "XRP",
+	"XXLM": "XLM",
+	"XXMR": "XMR",
+	"XETC": "ETC",
+	"XZEC": "ZEC",
+	"ZUSD": "USD",
+	"ZEUR": "EUR",
+	"ZGBP": "GBP",
+	"ZCAD": "CAD",
+	"ZJPY": "JPY",
+	"ZAUD": "AUD",
+}
+
+func krakenAsset(name string) string {
+	if ticker, ok := krakenAssets[name]; ok {
+		return ticker
+	}
+
+	return name
+}
+
+// splitKrakenPair splits a Kraken pair such as "XXBTZUSD", "XBTUSDT" or "BTC/USD".
+func splitKrakenPair(pair string) (string, string, error) {
+	pair = strings.ToUpper(strings.TrimSpace(pair))
+
+	// Legacy pairs join two four letter names, such as XXBT and ZUSD.
+	if len(pair) == 8 {
+		_, hasBase := krakenAssets[pair[:4]]
+		_, hasQuote := krakenAssets[pair[4:]]
+
+		if hasBase && hasQuote {
+			return krakenAsset(pair[:4]), krakenAsset(pair[4:]), nil
+		}
+	}
+
+	base, quote, err := splitPair(pair)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return krakenAsset(base), krakenAsset(quote), nil
+}
+
+// parseKraken reads a row of a Kraken trades export.
+func parseKraken(record *record) (Trade, error) {
+	var trade Trade
+	var err error
+
+	if trade.Side, err = parseSide(record.get("type")); err != nil {
+		return trade, err
+	}
+
+	if trade.Time, err = parseTime(record.get("time")); err != nil {
+		return trade, err
+	}
+
+	if trade.Base, trade.Quote, err = splitKrakenPair(record.get("pair")); err != nil {
+		return trade, err
+	}
+
+	trade.FeeCurrency = trade.Quote
+
+	if trade.Quantity, err = parseNumber(record.get("vol"), "volume"); err != nil {
+		return trade, err
+	}
+
+	if trade.Price, err = parseNumber(record.get("price"), "price"); err != nil {
+		return trade, err
+	}
+
+	if trade.Fee, err = parseNumber(record.get("fee"), "fee"); err != nil {
+		return trade, err
+	}
+
+	trade.ExternalID = Kraken + ":" + record.get("txid")
+
+	return trade, nil
+}
+
+// parseGeneric reads a row with columns named by a mapping.
+//
+// The side, price and fee are optional, so a file of purchases only needs
+// times, assets and quantities.
+func parseGeneric(record *record, mapping *Mapping) (Trade, error) {
+	var trade Trade
+	var err error
+
+	trade.Side = Buy
+
+	if side := record.get(mapping.Side); side != "" {
+		if trade.Side, err = parseSide(side); err != nil {
+			return trade, err
+		}
+	}
+
+	if trade.Time, err = parseTime(record.get(mapping.Time)); err != nil {
+		return trade, err
+	}
+
+	trade.Base = strings.ToUpper(record.get(mapping.Asset))
+
+	if trade.Base == "" {
+		return trade, errors.New("missing asset")
+	}
+
+	trade.Quote = strings.ToUpper(record.get(mapping.Quote))
+	trade.FeeCurrency = trade.Quote
+
+	if trade.Quantity, err = parseNumber(record.get(mapping.Quantity), "quantity"); err != nil {
+		return trade, err
+	}
+
+	if price := record.get(mapping.Price); price != "" {
+		if trade.Price, err = parseNumber(price, "price"); err != nil {
+			return trade, err
+		}
+	}
+
+	if fee := record.get(mapping.Fee); fee != "" {
+		if trade.Fee, err = parseNumber(fee, "fee"); err != nil {
+			return trade, err
+		}
+	}
+
+	if id := record.get(mapping.ID); id != "" {
+		trade.ExternalID = Generic + ":" + id
+	} else {
+		trade.ExternalID = record.hashID(Generic)
+	}
+
+	return trade, nil
+}
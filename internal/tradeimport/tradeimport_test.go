@@ -0,0 +1,343 @@
+package tradeimport
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// expectedRow is a row expected from a fixture. A row with an error only
+// has its number and error checked.
+type expectedRow struct {
+	number      int
+	err         string
+	side        string
+	time        time.Time
+	base        string
+	quote       string
+	quantity    string
+	price       string
+	fee         string
+	feeCurrency string
+	externalID  string
+}
+
+func readFixture(t *testing.T, format string, name string, mapping Mapping) []Row {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rowList, err := Read(format, content, mapping)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return rowList
+}
+
+func checkRow(t *testing.T, row Row, expected expectedRow) {
+	t.Helper()
+
+	if row.Number != expected.number {
+		t.Errorf("got row %d, expected row %d", row.Number, expected.number)
+	}
+
+	if expected.err != "" {
+		if row.Err == nil || !strings.Contains(row.Err.Error(), expected.err) {
+			t.Errorf("row %d: got error %v, expected %q", expected.number, row.Err, expected.err)
+		}
+
+		return
+	}
+
+	if row.Err != nil {
+		t.Errorf("row %d: unexpected error: %s", expected.number, row.Err)
+
+		return
+	}
+
+	trade := row.Trade
+	checkDecimal := func(name string, actual decimal.Decimal, value string) {
+		if value == "" {
+			value = "0"
+		}
+
+		if !actual.Equal(decimal.RequireFromString(value)) {
+			t.Errorf("row %d: got %s %s, expected %s", expected.number, name, actual.String(), value)
+		}
+	}
+
+	if trade.Side != expected.side {
+		t.Errorf("row %d: got side %q, expected %q", expected.number, trade.Side, expected.side)
+	}
+
+	if !trade.Time.Equal(expected.time) {
+		t.Errorf("row %d: got time %s, expected %s", expected.number, trade.Time, expected.time)
+	}
+
+	if trade.Base != expected.base || trade.Quote != expected.quote {
+		t.Errorf(
+			"row %d: got pair %s/%s, expected %s/%s",
+			expected.number, trade.Base, trade.Quote, expected.base, expected.quote,
+		)
+	}
+
+	checkDecimal("quantity", trade.Quantity, expected.quantity)
+	checkDecimal("price", trade.Price, expected.price)
+	checkDecimal("fee", trade.Fee, expected.fee)
+
+	if trade.FeeCurrency != expected.feeCurrency {
+		t.Errorf("row %d: got fee currency %q, expected %q", expected.number, trade.FeeCurrency, expected.feeCurrency)
+	}
+
+	if expected.externalID != "" && trade.ExternalID != expected.externalID {
+		t.Errorf("row %d: got ID %q, expected %q", expected.number, trade.ExternalID, expected.externalID)
+	}
+
+	if trade.ExternalID == "" {
+		t.Errorf("row %d: got no ID", expected.number)
+	}
+}
+
+func TestRead(t *testing.T) {
+	day := func(year int, month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name    string
+		format  string
+		fixture string
+		mapping Mapping
+		rowList []expectedRow
+	}{
+		{
+			name:    "Binance",
+			format:  Binance,
+			fixture: "binance.csv",
+			rowList: []expectedRow{
+				{
+					number: 2, side: Buy, time: day(2024, time.January, 2, 10, 0),
+					base: "BTC", quote: "USDT", quantity: "0.01", price: "42000",
+					fee: "0.00001", feeCurrency: "BTC",
+				},
+				{
+					number: 3, side: Buy, time: day(2024, time.January, 2, 10, 0),
+					base: "BTC", quote: "USDT", quantity: "0.01", price: "42000",
+					fee: "0.00001", feeCurrency: "BTC",
+				},
+				{
+					number: 4, side: Sell, time: day(2024, time.January, 3, 12, 30),
+					base: "ETH", quote: "BTC", quantity: "1.5", price: "0.055",
+					fee: "0.0000825", feeCurrency: "BTC",
+				},
+				{
+					number: 5, side: Buy, time: day(2024, time.January, 4, 8, 15),
+					base: "C98", quote: "USDT", quantity: "1000", price: "0.25",
+					fee: "0.1", feeCurrency: "BNB",
+				},
+			},
+		},
+		{
+			name:    "Binance with a Market column",
+			format:  Binance,
+			fixture: "binance-legacy.csv",
+			rowList: []expectedRow{
+				{
+					number: 2, side: Buy, time: day(2021, time.May, 1, 8, 0),
+					base: "BNB", quote: "BUSD", quantity: "2", price: "600",
+					fee: "0.0015", feeCurrency: "BNB",
+				},
+				{
+					number: 3, side: Sell, time: day(2021, time.May, 2, 9, 30),
+					base: "ADA", quote: "EUR", quantity: "400", price: "1.25",
+					fee: "0.5", feeCurrency: "EUR",
+				},
+			},
+		},
+		{
+			name:    "Coinbase",
+			format:  Coinbase,
+			fixture: "coinbase.csv",
+			rowList: []expectedRow{
+				{
+					number: 2, side: Buy, time: day(2023, time.March, 1, 9, 0),
+					base: "BTC", quote: "USD", quantity: "0.002", price: "23500",
+					fee: "1.99", feeCurrency: "USD",
+				},
+				{number: 3, err: ErrSkipped.Error()},
+				{
+					number: 4, side: Sell, time: day(2023, time.March, 5, 11, 0),
+					base: "BTC", quote: "USD", quantity: "0.001", price: "24000",
+					fee: "0.99", feeCurrency: "USD",
+				},
+				{
+					number: 5, side: Buy, time: day(2023, time.March, 6, 12, 0),
+					base: "ETH", quote: "USD", quantity: "0.5", price: "1600",
+					fee: "4.80", feeCurrency: "USD",
+				},
+			},
+		},
+		{
+			name:    "Kraken",
+			format:  Kraken,
+			fixture: "kraken.csv",
+			rowList: []expectedRow{
+				{
+					number: 2, side: Buy,
+					time: time.Date(2022, time.June, 1, 12, 0, 0, 123400000, time.UTC),
+					base: "BTC", quote: "USD", quantity: "0.01", price: "30000",
+					fee: "0.78", feeCurrency: "USD", externalID: "kraken:TXID1-AAAAA",
+				},
+				{
+					number: 3, side: Sell, time: day(2022, time.June, 2, 13, 0),
+					base: "ETH", quote: "EUR", quantity: "1", price: "1700.5",
+					fee: "2.72", feeCurrency: "EUR", externalID: "kraken:TXID2-BBBBB",
+				},
+				{
+					number: 4, side: Buy, time: day(2022, time.June, 3, 14, 0),
+					base: "SOL", quote: "USD", quantity: "10", price: "40",
+					fee: "1.04", feeCurrency: "USD", externalID: "kraken:TXID3-CCCCC",
+				},
+				{number: 5, err: "unknown trading pair"},
+			},
+		},
+		{
+			name:    "Generic",
+			format:  Generic,
+			fixture: "generic.csv",
+			rowList: []expectedRow{
+				{
+					number: 2, side: Buy, time: day(2024, time.February, 1, 0, 0),
+					base: "BTC", quote: "USD", quantity: "0.1", price: "45000",
+					fee: "2.5", feeCurrency: "USD",
+				},
+				{
+					number: 3, side: Buy, time: day(2024, time.February, 1, 0, 0),
+					base: "BTC", quote: "USD", quantity: "0.1", price: "45000",
+					fee: "2.5", feeCurrency: "USD",
+				},
+				{
+					number: 4, side: Sell, time: day(2024, time.February, 3, 10, 0),
+					base: "BTC", quote: "USD", quantity: "0.05", price: "47000",
+					feeCurrency: "USD",
+				},
+				{number: 5, err: ErrSkipped.Error()},
+			},
+		},
+		{
+			name:    "Generic with mapped columns",
+			format:  Generic,
+			fixture: "generic-mapped.csv",
+			mapping: Mapping{
+				ID:       "Ref",
+				Time:     "Date",
+				Asset:    "Coin",
+				Quote:    "Currency",
+				Quantity: "Amount",
+				Price:    "Unit Price",
+			},
+			rowList: []expectedRow{
+				{
+					number: 2, side: Buy, time: day(2024, time.March, 1, 0, 0),
+					base: "ETH", quote: "EUR", quantity: "2", price: "3000",
+					feeCurrency: "EUR", externalID: "generic:A-1",
+				},
+				{number: 3, err: "missing asset"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rowList := readFixture(t, testCase.format, testCase.fixture, testCase.mapping)
+
+			if len(rowList) != len(testCase.rowList) {
+				t.Fatalf("got %d rows, expected %d", len(rowList), len(testCase.rowList))
+			}
+
+			for i, expected := range testCase.rowList {
+				checkRow(t, rowList[i], expected)
+			}
+		})
+	}
+}
+
+func TestReadSkippedRowsAreErrSkipped(t *testing.T) {
+	rowList := readFixture(t, Coinbase, "coinbase.csv", Mapping{})
+
+	if !errors.Is(rowList[1].Err, ErrSkipped) {
+		t.Errorf("got %v, expected ErrSkipped", rowList[1].Err)
+	}
+}
+
+func TestReadRepeatedRowsHaveDistinctIDs(t *testing.T) {
+	testCases := []struct {
+		name    string
+		format  string
+		fixture string
+	}{
+		{"Binance", Binance, "binance.csv"},
+		{"Generic", Generic, "generic.csv"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rowList := readFixture(t, testCase.format, testCase.fixture, Mapping{})
+			// The first two rows of each fixture are identical fills.
+			first := rowList[0].Trade.ExternalID
+			second := rowList[1].Trade.ExternalID
+
+			if first == second {
+				t.Errorf("identical rows got the same ID %q", first)
+			}
+
+			// IDs must be the same when the same file is imported again.
+			again := readFixture(t, testCase.format, testCase.fixture, Mapping{})
+
+			for i := range rowList {
+				if rowList[i].Trade.ExternalID != again[i].Trade.ExternalID {
+					t.Errorf("row %d: ID changed from %q to %q", rowList[i].Number, rowList[i].Trade.ExternalID, again[i].Trade.ExternalID)
+				}
+			}
+		})
+	}
+}
+
+// The first of the repeated rows has the ID it would have alone, so files
+// imported before repeats were counted aren't imported again.
+func TestReadFirstOccurrenceIDIsUnchanged(t *testing.T) {
+	single := "Date(UTC),Pair,Side,Price,Executed,Amount,Fee\n" +
+		"2024-01-02 10:00:00,BTCUSDT,BUY,42000,0.01BTC,420USDT,0.00001BTC\n"
+	rowList, err := Read(Binance, []byte(single), Mapping{})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	repeated := readFixture(t, Binance, "binance.csv", Mapping{})
+
+	if rowList[0].Trade.ExternalID != repeated[0].Trade.ExternalID {
+		t.Errorf("got ID %q, expected %q", repeated[0].Trade.ExternalID, rowList[0].Trade.ExternalID)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := Read("random", []byte("a,b\n"), Mapping{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+
+	if _, err := Read(Kraken, []byte("a,b\n1,2\n"), Mapping{}); err == nil {
+		t.Error("expected an error for a file without a header row")
+	}
+}
@@ -2,7 +2,7 @@
 
 set -eu
 
-for executable in ingest notify adduser pricewarp; do
+for executable in ingest notify snapshot backfill adduser pricewarp; do
     (
         echo "Building bin/$executable..."
         cd "cmd/$executable"
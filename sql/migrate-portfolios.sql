@@ -0,0 +1,43 @@
+-- Rebuild tables created before portfolios were named, so they are keyed by
+-- portfolio. The sorting key of a table can't be changed in place, so each
+-- table is copied into a new table with the new key, and the two are swapped.
+--
+-- Apply sql/schema.sql first, so the tables have a portfolio_id column. Stop
+-- the server while this runs, as rows written during a copy are lost. Running
+-- this again copies the tables again, and changes nothing else.
+
+DROP TABLE IF EXISTS crypto_portfolio_rebuild;
+
+CREATE TABLE crypto_portfolio_rebuild AS crypto_portfolio
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user_id, portfolio_id);
+
+INSERT INTO crypto_portfolio_rebuild SELECT * FROM crypto_portfolio;
+
+EXCHANGE TABLES crypto_portfolio AND crypto_portfolio_rebuild;
+
+DROP TABLE crypto_portfolio_rebuild;
+
+DROP TABLE IF EXISTS crypto_asset_rebuild;
+
+CREATE TABLE crypto_asset_rebuild AS crypto_asset
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user_id, portfolio_id, currency_ticker);
+
+INSERT INTO crypto_asset_rebuild SELECT * FROM crypto_asset;
+
+EXCHANGE TABLES crypto_asset AND crypto_asset_rebuild;
+
+DROP TABLE crypto_asset_rebuild;
+
+DROP TABLE IF EXISTS crypto_portfolio_snapshot_rebuild;
+
+CREATE TABLE crypto_portfolio_snapshot_rebuild AS crypto_portfolio_snapshot
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user_id, portfolio_id, snapshot_date);
+
+INSERT INTO crypto_portfolio_snapshot_rebuild SELECT * FROM crypto_portfolio_snapshot;
+
+EXCHANGE TABLES crypto_portfolio_snapshot AND crypto_portfolio_snapshot_rebuild;
+
+DROP TABLE crypto_portfolio_snapshot_rebuild;
@@ -37,6 +37,32 @@ PARTITION BY yearmonth
 ORDER BY (yearmonth, time, from_currency_ticker, to_currency_ticker)
 SETTINGS index_granularity = 8192;
 
+-- Personal API tokens. Only the SHA-256 hash of each token is stored.
+CREATE TABLE IF NOT EXISTS crypto_api_token
+(
+    token_id Int64,
+    user_id Int64,
+    username LowCardinality(String),
+    name String,
+    scope LowCardinality(String),
+    token_hash FixedString(64),
+    created_at DateTime64(9),
+    updated_at DateTime64(9),
+    is_deleted UInt8
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user_id, token_id, updated_at);
+
+-- The last time each API token was used, kept apart from the tokens so
+-- recording a use can't overwrite a token being revoked.
+CREATE TABLE IF NOT EXISTS crypto_api_token_use
+(
+    token_id Int64,
+    used_at DateTime64(9)
+)
+ENGINE = ReplacingMergeTree(used_at)
+ORDER BY token_id;
+
 CREATE TABLE IF NOT EXISTS crypto_alert
 (
     alert_id Int64,
@@ -51,26 +77,123 @@ CREATE TABLE IF NOT EXISTS crypto_alert
     alert_time DateTime64(9),
     sent UInt8,
     updated_at DateTime64(9),
-    is_deleted UInt8
+    is_deleted UInt8,
+    active_from Nullable(DateTime64(9)),
+    expires_at Nullable(DateTime64(9)),
+    note String DEFAULT '',
+    labels Array(LowCardinality(String)),
+    ladder_id Int64 DEFAULT 0,
+    indicator LowCardinality(String) DEFAULT '',
+    period UInt16 DEFAULT 0,
+    rule LowCardinality(String) DEFAULT '',
+    recurring UInt8 DEFAULT 0,
+    upper Decimal(40, 20) DEFAULT 0
 )
 ENGINE = ReplacingMergeTree(updated_at)
 ORDER BY (user_id, alert_id, updated_at);
 
+-- Add columns to crypto_alert tables created by older versions.
+ALTER TABLE crypto_alert
+    ADD COLUMN IF NOT EXISTS active_from Nullable(DateTime64(9)),
+    ADD COLUMN IF NOT EXISTS expires_at Nullable(DateTime64(9)),
+    ADD COLUMN IF NOT EXISTS note String DEFAULT '',
+    ADD COLUMN IF NOT EXISTS labels Array(LowCardinality(String)),
+    ADD COLUMN IF NOT EXISTS ladder_id Int64 DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS indicator LowCardinality(String) DEFAULT '',
+    ADD COLUMN IF NOT EXISTS period UInt16 DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS rule LowCardinality(String) DEFAULT '',
+    ADD COLUMN IF NOT EXISTS recurring UInt8 DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS upper Decimal(40, 20) DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS crypto_alert_ladder
+(
+    ladder_id Int64,
+    user_id Int64,
+    username LowCardinality(String),
+    from_currency_ticker LowCardinality(String),
+    from_currency_name LowCardinality(String),
+    to_currency_ticker LowCardinality(String),
+    to_currency_name LowCardinality(String),
+    low Decimal(40, 20),
+    high Decimal(40, 20),
+    step Decimal(40, 20),
+    note String,
+    updated_at DateTime64(9),
+    is_deleted UInt8
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user_id, ladder_id, updated_at);
+
+-- Records stale price emails so each stale period is only reported once.
+CREATE TABLE IF NOT EXISTS crypto_stale_price_alarm
+(
+    latest_price_time DateTime64(9),
+    sent_at DateTime64(9)
+)
+ENGINE = MergeTree
+ORDER BY (latest_price_time);
+
 CREATE TABLE IF NOT EXISTS crypto_portfolio
 (
     user_id Int64,
+    portfolio_id Int64,
     username LowCardinality(String),
+    name String DEFAULT 'Main',
     currency_ticker LowCardinality(String),
     currency_name LowCardinality(String),
     cash Decimal(40, 20),
+    cost_basis_method LowCardinality(String) DEFAULT 'average',
+    updated_at DateTime64(9),
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user_id, portfolio_id);
+
+-- Add columns to crypto_portfolio tables created by older versions.
+-- Existing portfolios become portfolio 0, the first portfolio of each user.
+-- Tables from before portfolios were named are keyed without portfolio_id,
+-- and sql/migrate-portfolios.sql rebuilds them with the key above.
+ALTER TABLE crypto_portfolio
+    ADD COLUMN IF NOT EXISTS cost_basis_method LowCardinality(String) DEFAULT 'average',
+    ADD COLUMN IF NOT EXISTS portfolio_id Int64 AFTER user_id,
+    ADD COLUMN IF NOT EXISTS name String DEFAULT 'Main' AFTER username;
+
+-- Every trade in a portfolio. crypto_asset is worked out from these.
+CREATE TABLE IF NOT EXISTS crypto_transactions
+(
+    transaction_id Int64,
+    user_id Int64,
+    portfolio_id Int64 DEFAULT 0,
+    username LowCardinality(String),
+    kind LowCardinality(String),
+    currency_ticker LowCardinality(String),
+    currency_name LowCardinality(String),
+    transaction_time DateTime64(9),
+    quantity Decimal(40, 20),
+    price Decimal(40, 20),
+    fee Decimal(40, 20),
+    fee_currency LowCardinality(String) DEFAULT '',
+    note String,
+    external_id String DEFAULT '',
+    transfer_id Int64 DEFAULT 0,
+    swap_id Int64 DEFAULT 0,
     updated_at DateTime64(9),
+    is_deleted UInt8
 )
 ENGINE = ReplacingMergeTree(updated_at)
-ORDER BY (user_id, currency_ticker);
+ORDER BY (user_id, transaction_id, updated_at);
+
+-- Add columns to crypto_transactions tables created by older versions.
+ALTER TABLE crypto_transactions
+    ADD COLUMN IF NOT EXISTS fee_currency LowCardinality(String) DEFAULT '',
+    ADD COLUMN IF NOT EXISTS external_id String DEFAULT '',
+    ADD COLUMN IF NOT EXISTS portfolio_id Int64 DEFAULT 0 AFTER user_id,
+    ADD COLUMN IF NOT EXISTS transfer_id Int64 DEFAULT 0 AFTER external_id,
+    ADD COLUMN IF NOT EXISTS swap_id Int64 DEFAULT 0 AFTER transfer_id;
 
 CREATE TABLE IF NOT EXISTS crypto_asset
 (
     user_id Int64,
+    portfolio_id Int64,
     username LowCardinality(String),
     currency_ticker LowCardinality(String),
     currency_name LowCardinality(String),
@@ -79,4 +202,53 @@ CREATE TABLE IF NOT EXISTS crypto_asset
     updated_at DateTime64(9),
 )
 ENGINE = ReplacingMergeTree(updated_at)
-ORDER BY (user_id, currency_ticker);
+ORDER BY (user_id, portfolio_id, currency_ticker);
+
+-- Add columns to crypto_asset tables created by older versions.
+ALTER TABLE crypto_asset
+    ADD COLUMN IF NOT EXISTS portfolio_id Int64 AFTER user_id;
+
+-- Cash deposited into and withdrawn from each portfolio.
+CREATE TABLE IF NOT EXISTS crypto_cash_flow
+(
+    cash_flow_id Int64,
+    user_id Int64,
+    portfolio_id Int64,
+    username LowCardinality(String),
+    kind LowCardinality(String),
+    flow_time DateTime64(9),
+    currency_ticker LowCardinality(String),
+    currency_name LowCardinality(String),
+    amount Decimal(40, 20),
+    note String,
+    transfer_id Int64 DEFAULT 0,
+    updated_at DateTime64(9),
+    is_deleted UInt8
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user_id, cash_flow_id, updated_at);
+
+-- Add columns to crypto_cash_flow tables created by older versions.
+ALTER TABLE crypto_cash_flow
+    ADD COLUMN IF NOT EXISTS transfer_id Int64 DEFAULT 0 AFTER note;
+
+-- Daily values of each portfolio, saved by bin/snapshot.
+CREATE TABLE IF NOT EXISTS crypto_portfolio_snapshot
+(
+    user_id Int64,
+    portfolio_id Int64,
+    username LowCardinality(String),
+    snapshot_date Date,
+    currency_ticker LowCardinality(String),
+    currency_name LowCardinality(String),
+    value Decimal(40, 20),
+    purchased Decimal(40, 20),
+    cash Decimal(40, 20),
+    updated_at DateTime64(9)
+)
+ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (user_id, portfolio_id, snapshot_date);
+
+-- Add columns to crypto_portfolio_snapshot tables created by older versions.
+ALTER TABLE crypto_portfolio_snapshot
+    ADD COLUMN IF NOT EXISTS portfolio_id Int64 AFTER user_id;
@@ -38,15 +38,16 @@ document
     })
   })
 
-// Opening a modal to confirm deleting an alert.
+// Opening a modal to confirm deleting an alert, or anything else with a URL.
 document
-  .querySelectorAll("button[data-try-delete-id]")
+  .querySelectorAll("button[data-try-delete-id], button[data-try-delete-url]")
   .forEach(button => {
     button.addEventListener("click", () => {
       document
         .querySelectorAll("[data-confirm-delete-modal] [data-confirm]")
         .forEach(confirmButton => {
-          confirmButton.dataset.deleteId = button.dataset.tryDeleteId
+          confirmButton.dataset.deleteUrl = button.dataset.tryDeleteUrl
+            || "/alert/" + button.dataset.tryDeleteId
         })
 
       document
@@ -62,12 +63,16 @@ document
   .querySelectorAll("[data-confirm-delete-modal] [data-confirm]")
   .forEach(button => {
     button.addEventListener("click", () => {
-      fetch("/alert/" + button.dataset.deleteId, {
+      fetch(button.dataset.deleteUrl, {
         method: "DELETE",
       })
         .then(response => {
           if (response.ok) {
-            window.location.reload()
+            if (button.dataset.deleteRedirect) {
+              window.location.assign(button.dataset.deleteRedirect)
+            } else {
+              window.location.reload()
+            }
           }
         })
     })
@@ -144,3 +149,31 @@ document
         })
       })
   })
+
+// Live prices and alert updates, for pages showing prices or alerts.
+if (document.querySelector("[data-live-price], [data-alert-id]")) {
+  const events = new EventSource("/stream")
+
+  events.addEventListener("price", event => {
+    const price = JSON.parse(event.data)
+
+    document
+      .querySelectorAll(`[data-live-price="${price.from}/${price.to}"]`)
+      .forEach(elem => {
+        // Multiply by an amount to show the value of an asset instead.
+        const amount = elem.dataset.liveAmount || "1"
+
+        elem.textContent = (Number(price.value) * Number(amount)).toFixed(2)
+      })
+  })
+
+  events.addEventListener("alert", event => {
+    const alert = JSON.parse(event.data)
+
+    document
+      .querySelectorAll(`[data-alert-id="${alert.id}"] .alert-description`)
+      .forEach(elem => {
+        elem.classList.add("sent")
+      })
+  })
+}